@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jonhadfield/carbo/policy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePolicyToRestoreBackupOnly(t *testing.T) {
+	policyTwo, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-two.json")
+	require.NoError(t, err)
+
+	policyTwoStatic, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-two.json")
+	require.NoError(t, err)
+
+	// test that if only backup provided, that backup is returned
+	generatedPolicyOne := GeneratePolicyToRestore(policy.WrappedPolicy{}, policyTwo, RestorePoliciesInput{})
+	require.NotNil(t, generatedPolicyOne)
+	require.True(t, reflect.DeepEqual(generatedPolicyOne.Policy, policyTwoStatic.Policy))
+}
+
+func TestGeneratePolicyToRestoreBackupWithoutOptions(t *testing.T) {
+	policyOne, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+	policyTwo, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-two.json")
+	require.NoError(t, err)
+
+	policyTwoStatic, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-two.json")
+	require.NoError(t, err)
+
+	// test that providing two policies without options returns Original with backup rules replacing Original's
+	generatedPolicyTwo := GeneratePolicyToRestore(policyOne, policyTwo, RestorePoliciesInput{})
+	require.NotNil(t, generatedPolicyTwo)
+	require.True(t, reflect.DeepEqual(generatedPolicyTwo.Policy, policyTwoStatic.Policy))
+}
+
+func TestGeneratePolicyToRestoreBackupCustomOnly(t *testing.T) {
+	policyOne, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+	policyTwo, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-two.json")
+	require.NoError(t, err)
+
+	policyOneStatic, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+	policyTwoStatic, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-two.json")
+	require.NoError(t, err)
+
+	// test that providing two policies (with both different custom rules and managed rules) with option to only replace
+	// custom rules with backup's custom rules
+	generatedPolicyThree := GeneratePolicyToRestore(policyOne, policyTwo, RestorePoliciesInput{
+		CustomRulesOnly: true,
+	})
+
+	require.NotNil(t, generatedPolicyThree)
+	// generated policy's custom rules should be identical to policy two's
+	require.True(t, reflect.DeepEqual(generatedPolicyThree.Policy.CustomRules, policyTwoStatic.Policy.CustomRules))
+	// generated policy's custom rules should be different from policy one's custom rules
+	require.False(t, reflect.DeepEqual(generatedPolicyThree.Policy.CustomRules, policyOneStatic.Policy.CustomRules))
+	// generated policy's managed rules should still be the same as policy one's, i.e. not replaced
+	require.True(t, reflect.DeepEqual(generatedPolicyThree.Policy.ManagedRules, policyOneStatic.Policy.ManagedRules))
+	// generated policy's managed rules should still be different from policy two's
+	require.False(t, reflect.DeepEqual(generatedPolicyThree.Policy.ManagedRules, policyTwoStatic.Policy.ManagedRules))
+}
+
+func TestGeneratePolicyToRestoreBackupManagedOnly(t *testing.T) {
+	policyOne, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+	policyTwo, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-two.json")
+	require.NoError(t, err)
+
+	policyOneStatic, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+	policyTwoStatic, err := policy.LoadWrappedPolicyFromFile("../testfiles/wrapped-policy-two.json")
+	require.NoError(t, err)
+
+	// test that providing two policies (with both different custom rules and managed rules) with option to only replace
+	// custom rules with backup's custom rules
+	generatedPolicyThree := GeneratePolicyToRestore(policyOne, policyTwo, RestorePoliciesInput{
+		ManagedRulesOnly: true,
+	})
+
+	require.NotNil(t, generatedPolicyThree)
+	// generated policy's custom rules should be identical to policy one's
+	require.True(t, reflect.DeepEqual(generatedPolicyThree.Policy.CustomRules, policyOneStatic.Policy.CustomRules))
+	// generated policy's custom rules should be different from policy two's custom rules
+	require.False(t, reflect.DeepEqual(generatedPolicyThree.Policy.CustomRules, policyTwoStatic.Policy.CustomRules))
+	// generated policy's managed rules should be the same as policy two's, i.e. replaced
+	require.True(t, reflect.DeepEqual(generatedPolicyThree.Policy.ManagedRules, policyTwoStatic.Policy.ManagedRules))
+	// generated policy's managed rules should be different from policy one's
+	require.False(t, reflect.DeepEqual(generatedPolicyThree.Policy.ManagedRules, policyOneStatic.Policy.ManagedRules))
+}
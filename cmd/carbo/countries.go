@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/jonhadfield/carbo/helpers"
+	. "github.com/jonhadfield/carbo/policy"
+	"github.com/urfave/cli/v2"
+)
+
+// countriesCommand returns a command that replaces the countries matched by the policy's rules for the action
+func countriesCommand(action string) *cli.Command {
+	return &cli.Command{
+		Name:      "countries",
+		Usage:     fmt.Sprintf("specify ISO 3166-1 alpha-2 country codes to %s, e.g. CN RU", strings.ToLower(action)),
+		ArgsUsage: "<policy id> [country code ...] (existing rules are removed if none are provided)",
+		Aliases:   []string{"c"},
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "output", Usage: "create and output new policy without applying", Aliases: []string{"o"}},
+			&cli.BoolFlag{Name: "dry-run", Usage: "show changes without applying", Aliases: []string{"d"}},
+		},
+		Action: func(c *cli.Context) error {
+			input := c.Args().First()
+			if input == "" {
+				_ = cli.ShowSubcommandHelp(c)
+
+				return nil
+			}
+
+			if err := ValidateResourceID(input, false); err != nil {
+				_ = cli.ShowSubcommandHelp(c)
+
+				return err
+			}
+
+			return ApplyCountryChanges(ApplyCountriesInput{
+				Action:       action,
+				RID:          ParseResourceID(input),
+				Countries:    c.Args().Tail(),
+				DryRun:       c.Bool("dry-run"),
+				Output:       c.Bool("output"),
+				OutputFormat: c.String("output-format"),
+			})
+		},
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	. "github.com/jonhadfield/carbo/helpers"
+	. "github.com/jonhadfield/carbo/policy"
+	"github.com/urfave/cli/v2"
+)
+
+// defaultDenyCommand returns a command that sets and removes the rule blocking every address not in a policy's list
+func defaultDenyCommand() *cli.Command {
+	outputFlags := []cli.Flag{
+		&cli.BoolFlag{Name: "output", Usage: "create and output new policy without applying", Aliases: []string{"o"}},
+		&cli.BoolFlag{Name: "dry-run", Usage: "show changes without applying", Aliases: []string{"d"}},
+	}
+
+	return &cli.Command{
+		Name:  "default-deny",
+		Usage: "manage the rule blocking every address not in a list",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "set",
+				Usage:     "create or replace the default deny rule, exempting the networks in file",
+				ArgsUsage: "<policy id>",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{Name: "file", Usage: "path to file or directory of ips to exempt", Aliases: []string{"f"}, Required: true},
+					&cli.StringFlag{Name: "match-variable", Usage: "address to match networks against: RemoteAddr or SocketAddr", Value: "RemoteAddr"},
+					&cli.StringFlag{Name: "egress-ip", Usage: "address that must be exempt if no protected ips specified (default: looked up)"},
+					&cli.BoolFlag{Name: "skip-lockout-check", Usage: "apply without checking the protected or egress ips are exempt"},
+				}, outputFlags...),
+				Action: func(c *cli.Context) error {
+					input := c.Args().First()
+					if input == "" {
+						_ = cli.ShowSubcommandHelp(c)
+
+						return nil
+					}
+
+					if err := ValidateResourceID(input, false); err != nil {
+						_ = cli.ShowSubcommandHelp(c)
+
+						return err
+					}
+
+					return ApplyDefaultDeny(DefaultDenyInput{
+						RID:              ParseResourceID(input),
+						Filepath:         c.String("file"),
+						MatchVariable:    c.String("match-variable"),
+						ProtectedPath:    c.String("protected"),
+						EgressIP:         c.String("egress-ip"),
+						SkipLockoutCheck: c.Bool("skip-lockout-check"),
+						DryRun:           c.Bool("dry-run"),
+						Output:           c.Bool("output"),
+						OutputFormat:     c.String("output-format"),
+					})
+				},
+			},
+			{
+				Name:      "remove",
+				Usage:     "remove the default deny rule",
+				ArgsUsage: "<policy id>",
+				Flags:     outputFlags,
+				Action: func(c *cli.Context) error {
+					input := c.Args().First()
+					if input == "" {
+						_ = cli.ShowSubcommandHelp(c)
+
+						return nil
+					}
+
+					if err := ValidateResourceID(input, false); err != nil {
+						_ = cli.ShowSubcommandHelp(c)
+
+						return err
+					}
+
+					return ApplyDefaultDeny(DefaultDenyInput{
+						RID:          ParseResourceID(input),
+						Remove:       true,
+						DryRun:       c.Bool("dry-run"),
+						Output:       c.Bool("output"),
+						OutputFormat: c.String("output-format"),
+					})
+				},
+			},
+		},
+	}
+}
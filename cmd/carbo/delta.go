@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	. "github.com/jonhadfield/carbo/helpers"
+	. "github.com/jonhadfield/carbo/policy"
+	"github.com/urfave/cli/v2"
+)
+
+// readIPArgs returns the networks provided as arguments following the policy id, or read from stdin if there are
+// none, or the only one is "-"
+func readIPArgs(c *cli.Context) (ipns IPNets, expiries IPNetExpiries, err error) {
+	args := c.Args().Tail()
+
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-") {
+		return ReadIPsFromReader("stdin", os.Stdin)
+	}
+
+	return ReadIPsFromReader("arguments", strings.NewReader(strings.Join(args, "\n")))
+}
+
+// ipDeltaCommand returns a command that adds networks to, or removes networks from, those already in the policy's
+// rules for the action
+func ipDeltaCommand(action string, maxRules int, remove bool) *cli.Command {
+	name, usage := "add", fmt.Sprintf("add ips to the %s list", strings.ToLower(action))
+	flags := []cli.Flag{
+		&cli.StringFlag{Name: "expires", Usage: "expire ips after a duration, e.g. 24h, or at a timestamp, e.g. 2022-07-21T09:00:00Z"},
+	}
+
+	if remove {
+		name, usage, flags = "remove", fmt.Sprintf("remove ips from the %s list", strings.ToLower(action)), nil
+	}
+
+	flags = append(flags,
+		&cli.IntFlag{Name: "max-rules", Usage: "maximum number of custom rules to create", Aliases: []string{"m"}, Value: maxRules},
+		&cli.BoolFlag{Name: "output", Usage: "create and output new policy without applying", Aliases: []string{"o"}},
+		&cli.BoolFlag{Name: "dry-run", Usage: "show changes without applying", Aliases: []string{"d"}},
+		&cli.BoolFlag{Name: "allow-truncation", Usage: "apply changes even if some ips exceed the maximum rules"},
+		&cli.StringFlag{Name: "dropped-output", Usage: "write ips exceeding the maximum rules to path"},
+		&cli.BoolFlag{Name: "fail-on-overlap", Usage: "fail if ips overlap those of other actions"},
+	)
+	flags = append(flags, scopeFlags()...)
+
+	return &cli.Command{
+		Name:      name,
+		Usage:     usage,
+		ArgsUsage: "<policy id> [ip ...] (ips are read from stdin if none are provided)",
+		Flags:     flags,
+		Action: func(c *cli.Context) error {
+			input := c.Args().First()
+			if input == "" {
+				_ = cli.ShowSubcommandHelp(c)
+
+				return nil
+			}
+
+			if err := ValidateResourceID(input, false); err != nil {
+				_ = cli.ShowSubcommandHelp(c)
+
+				return err
+			}
+
+			ipns, expiries, err := readIPArgs(c)
+			if err != nil {
+				return err
+			}
+
+			delta := ApplyIPDeltaInput{
+				ApplyIPsInput: ApplyIPsInput{
+					Action:          action,
+					RID:             ParseResourceID(input),
+					DryRun:          c.Bool("dry-run"),
+					Output:          c.Bool("output"),
+					MaxRules:        c.Int("max-rules"),
+					AllowTruncation: c.Bool("allow-truncation"),
+					DroppedPath:     c.String("dropped-output"),
+					FailOnOverlap:   c.Bool("fail-on-overlap"),
+					ProtectedPath:   c.String("protected"),
+					RejectProtected: c.Bool("reject-protected"),
+					Expires:         c.String("expires"),
+					ExpiryStatePath: c.String("expiry-state"),
+					OutputFormat:    c.String("output-format"),
+					Scope:           scopeFromContext(c),
+				},
+			}
+
+			if remove {
+				delta.Remove = ipns
+			} else {
+				delta.Add = ipns
+				delta.AddExpiries = expiries
+			}
+
+			return ApplyIPDelta(delta)
+		},
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	. "github.com/jonhadfield/carbo/policy"
+	"github.com/urfave/cli/v2"
+)
+
+// ipsFlags returns the flags of the commands replacing an action's networks with those read from files
+func ipsFlags(maxRules int) []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringFlag{Name: "file", Usage: "path to file or directory of ips", Aliases: []string{"f"}, Required: true},
+		&cli.StringFlag{Name: "format", Usage: "format of ip files: text, csv, json, azure-service-tags, aws, gcp, cloudflare, spamhaus, firehol, abuseipdb or emerging-threats (detected if not specified)"},
+		&cli.StringFlag{Name: "column", Usage: "name or position of csv column containing ips"},
+		&cli.StringFlag{Name: "json-path", Usage: "path to ips in json documents, e.g. prefixes.#.ip_prefix"},
+		&cli.StringFlag{Name: "expires", Usage: "expire ips after a duration, e.g. 24h, or at a timestamp, e.g. 2022-07-21T09:00:00Z"},
+		&cli.IntFlag{Name: "max-rules", Usage: "maximum number of custom rules to create", Aliases: []string{"m"}, Value: maxRules},
+		&cli.BoolFlag{Name: "output", Usage: "create and output new policy without applying", Aliases: []string{"o"}},
+		&cli.BoolFlag{Name: "dry-run", Usage: "show changes without applying", Aliases: []string{"d"}},
+		&cli.BoolFlag{Name: "allow-truncation", Usage: "apply changes even if some ips exceed the maximum rules"},
+		&cli.StringFlag{Name: "dropped-output", Usage: "write ips exceeding the maximum rules to path"},
+		&cli.BoolFlag{Name: "fail-on-overlap", Usage: "fail if ips overlap those of other actions"},
+	}, scopeFlags()...)
+}
+
+// ipsInputFromContext returns the input replacing the action's networks in the policy with those specified by the
+// ips flags
+func ipsInputFromContext(c *cli.Context, action, policyID string) ApplyIPsInput {
+	return ApplyIPsInput{
+		Action:          action,
+		RID:             ParseResourceID(policyID),
+		DryRun:          c.Bool("dry-run"),
+		Output:          c.Bool("output"),
+		Filepath:        c.String("file"),
+		FileFormat:      c.String("format"),
+		FileColumn:      c.String("column"),
+		FileJSONPath:    c.String("json-path"),
+		MaxRules:        c.Int("max-rules"),
+		AllowTruncation: c.Bool("allow-truncation"),
+		DroppedPath:     c.String("dropped-output"),
+		FailOnOverlap:   c.Bool("fail-on-overlap"),
+		ProtectedPath:   c.String("protected"),
+		RejectProtected: c.Bool("reject-protected"),
+		Expires:         c.String("expires"),
+		ExpiryStatePath: c.String("expiry-state"),
+		OutputFormat:    c.String("output-format"),
+		Scope:           scopeFromContext(c),
+	}
+}
@@ -6,6 +6,7 @@ import (
 	. "github.com/jonhadfield/carbo/helpers"
 	. "github.com/jonhadfield/carbo/policy"
 	"os"
+	"strings"
 	"time"
 
 	. "github.com/jonhadfield/carbo"
@@ -44,6 +45,18 @@ func main() {
 			Required: false,
 		},
 		&cli.BoolFlag{Name: "quiet", Usage: "suppress output"},
+		&cli.StringFlag{
+			Name:    "protected",
+			Usage:   "path to file or directory of ips that must never be blocked or logged",
+			EnvVars: []string{"CARBO_PROTECTED"},
+		},
+		&cli.BoolFlag{Name: "reject-protected", Usage: "fail instead of stripping protected ips from block, log and redirect lists"},
+		&cli.StringFlag{Name: "expiry-state", Usage: "path to file recording when ips expire (default: user config directory)"},
+		&cli.StringFlag{
+			Name:    "output-format",
+			Usage:   "format of results and changes output: table, json, yaml or csv (default: table, or json for get)",
+			EnvVars: []string{"CARBO_OUTPUT"},
+		},
 	}
 	app.Commands = []*cli.Command{
 		{
@@ -155,6 +168,8 @@ func main() {
 			Flags: []cli.Flag{
 				&cli.BoolFlag{Name: "dry-run", Usage: "show changes without applying", Aliases: []string{"d"}},
 				&cli.BoolFlag{Name: "no-verify", Usage: "skip manual verification", Aliases: []string{"n"}},
+				&cli.BoolFlag{Name: "allow-truncation", Usage: "apply changes even if some ips exceed the maximum rules"},
+				&cli.BoolFlag{Name: "fail-on-overlap", Usage: "fail if ips overlap those of other actions"},
 			},
 			Action: func(c *cli.Context) error {
 				input := c.Args().First()
@@ -165,11 +180,93 @@ func main() {
 				}
 
 				return RunActions(RunActionsInput{
-					Path:   input,
-					DryRun: c.Bool("dry-run"),
+					Path:            input,
+					DryRun:          c.Bool("dry-run"),
+					AllowTruncation: c.Bool("allow-truncation"),
+					FailOnOverlap:   c.Bool("fail-on-overlap"),
+					ProtectedPath:   c.String("protected"),
+					RejectProtected: c.Bool("reject-protected"),
+					ExpiryStatePath: c.String("expiry-state"),
+					OutputFormat:    c.String("output-format"),
 				})
 			},
 		},
+		{
+			Name:  "check",
+			Usage: "check policies",
+			Action: func(c *cli.Context) error {
+				_ = cli.ShowSubcommandHelp(c)
+
+				return nil
+			},
+			Subcommands: []*cli.Command{
+				{
+					Name:    "overlaps",
+					Usage:   "check overlaps <policy resource id>",
+					Aliases: []string{"o"},
+					Flags: []cli.Flag{
+						&cli.BoolFlag{Name: "fail-on-overlap", Usage: "return an error if overlaps are found"},
+					},
+					Action: func(c *cli.Context) error {
+						policyID := c.Args().First()
+						if err := ValidateResourceID(policyID, false); err != nil {
+							_ = cli.ShowSubcommandHelp(c)
+
+							return err
+						}
+
+						return CheckPolicyOverlaps(policyID, c.Bool("fail-on-overlap"))
+					},
+				},
+			},
+		},
+		{
+			Name:  "export",
+			Usage: "export policy resources",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "ips",
+					Usage:     "export the ips held in a policy's custom rules to a list",
+					ArgsUsage: "<policy id>",
+					Flags: []cli.Flag{
+						&cli.StringFlag{Name: "action", Usage: "export the rules generated for an action: block, allow, log or redirect", Aliases: []string{"a"}},
+						&cli.StringFlag{Name: "prefix", Usage: "export rules with names starting with prefix, instead of those for an action", Aliases: []string{"p"}},
+						&cli.StringSliceFlag{Name: "rule", Usage: "export the named rule (can be repeated)", Aliases: []string{"r"}},
+						&cli.StringFlag{Name: "file", Usage: "path to write ips to (default: stdout)", Aliases: []string{"f"}},
+						&cli.StringFlag{Name: "format", Usage: "format of ips: text, csv or json", Value: IPListFormatText},
+					},
+					Action: func(c *cli.Context) error {
+						input := c.Args().First()
+						if input == "" {
+							_ = cli.ShowSubcommandHelp(c)
+
+							return nil
+						}
+
+						if err := ValidateResourceID(input, false); err != nil {
+							_ = cli.ShowSubcommandHelp(c)
+
+							return err
+						}
+
+						// actions are capitalised, e.g. Block
+						action := c.String("action")
+						if action != "" {
+							action = strings.ToUpper(action[:1]) + strings.ToLower(action[1:])
+						}
+
+						return ExportIPs(ExportIPsInput{
+							PolicyID:  input,
+							Action:    action,
+							Prefix:    c.String("prefix"),
+							RuleNames: c.StringSlice("rule"),
+							Path:      c.String("file"),
+							Format:    c.String("format"),
+						})
+					},
+				},
+			},
+		},
 		{
 			Name:    "delete",
 			Aliases: []string{"d"},
@@ -196,6 +293,8 @@ func main() {
 				return nil
 			},
 		},
+		rateLimitCommand(),
+		defaultDenyCommand(),
 		{
 			Name:    "block",
 			Aliases: []string{"b"},
@@ -204,12 +303,8 @@ func main() {
 				{
 					Name:  "ips",
 					Usage: "specify list(s) of IPs to block",
-					Flags: []cli.Flag{
-						&cli.StringFlag{Name: "file", Usage: "path to file or directory of ips", Aliases: []string{"f"}, Required: true},
-						&cli.IntFlag{Name: "max-rules", Usage: "maximum number of custom rules to create", Aliases: []string{"m"}, Value: MaxBlockNetsRules},
-						&cli.BoolFlag{Name: "output", Usage: "create and output new policy without applying", Aliases: []string{"o"}},
-						&cli.BoolFlag{Name: "dry-run", Usage: "show changes without applying", Aliases: []string{"d"}},
-					},
+					Flags: append(ipsFlags(MaxBlockNetsRules),
+						&cli.StringSliceFlag{Name: "shard", Usage: "further policy id to spread ips across, in any order (can be repeated)"}),
 					Aliases: []string{"i"},
 					Action: func(c *cli.Context) error {
 						input := c.Args().First()
@@ -220,20 +315,26 @@ func main() {
 
 								return err
 							}
-							return ApplyIPChanges(ApplyIPsInput{
-								Action:   "Block",
-								RID:      ParseResourceID(input),
-								DryRun:   c.Bool("dry-run"),
-								Output:   c.Bool("output"),
-								Filepath: c.String("file"),
-								MaxRules: c.Int("max-rules"),
-							})
+
+							for _, shard := range c.StringSlice("shard") {
+								if err := ValidateResourceID(shard, false); err != nil {
+									return err
+								}
+							}
+
+							ipsInput := ipsInputFromContext(c, "Block", input)
+							ipsInput.Shards = ParseResourceIDs(c.StringSlice("shard"))
+
+							return ApplyIPChanges(ipsInput)
 						}
 						_ = cli.ShowSubcommandHelp(c)
 
 						return nil
 					},
 				},
+				ipDeltaCommand("Block", MaxBlockNetsRules, false),
+				ipDeltaCommand("Block", MaxBlockNetsRules, true),
+				countriesCommand("Block"),
 			},
 		},
 		{
@@ -242,14 +343,9 @@ func main() {
 			Usage:   "allow requests",
 			Subcommands: []*cli.Command{
 				{
-					Name:  "ips",
-					Usage: "specify list(s) of IPs to allow",
-					Flags: []cli.Flag{
-						&cli.StringFlag{Name: "file", Usage: "path to file or directory of ips", Aliases: []string{"f"}, Required: true},
-						&cli.IntFlag{Name: "max-rules", Usage: "maximum number of custom rules to create", Aliases: []string{"m"}, Value: MaxAllowNetsRules},
-						&cli.BoolFlag{Name: "output", Usage: "create and output new policy without applying", Aliases: []string{"o"}},
-						&cli.BoolFlag{Name: "dry-run", Usage: "show changes without applying", Aliases: []string{"d"}},
-					},
+					Name:    "ips",
+					Usage:   "specify list(s) of IPs to allow",
+					Flags:   ipsFlags(MaxAllowNetsRules),
 					Aliases: []string{"i"},
 					Action: func(c *cli.Context) error {
 						input := c.Args().First()
@@ -260,20 +356,16 @@ func main() {
 
 								return err
 							}
-							return ApplyIPChanges(ApplyIPsInput{
-								Action:   "Allow",
-								RID:      ParseResourceID(input),
-								DryRun:   c.Bool("dry-run"),
-								Output:   c.Bool("output"),
-								Filepath: c.String("file"),
-								MaxRules: c.Int("max-rules"),
-							})
+							return ApplyIPChanges(ipsInputFromContext(c, "Allow", input))
 						}
 						_ = cli.ShowSubcommandHelp(c)
 
 						return nil
 					},
 				},
+				ipDeltaCommand("Allow", MaxAllowNetsRules, false),
+				ipDeltaCommand("Allow", MaxAllowNetsRules, true),
+				countriesCommand("Allow"),
 			},
 		},
 		{
@@ -281,14 +373,9 @@ func main() {
 			Usage: "log requests",
 			Subcommands: []*cli.Command{
 				{
-					Name:  "ips",
-					Usage: "specify list(s) of IPs to log",
-					Flags: []cli.Flag{
-						&cli.StringFlag{Name: "file", Usage: "path to file or directory of ips", Aliases: []string{"f"}, Required: true},
-						&cli.IntFlag{Name: "max-rules", Usage: "maximum number of custom rules to create", Aliases: []string{"m"}, Value: MaxLogNetsRules},
-						&cli.BoolFlag{Name: "output", Usage: "create and output new policy without applying", Aliases: []string{"o"}},
-						&cli.BoolFlag{Name: "dry-run", Usage: "show changes without applying", Aliases: []string{"d"}},
-					},
+					Name:    "ips",
+					Usage:   "specify list(s) of IPs to log",
+					Flags:   ipsFlags(MaxLogNetsRules),
 					Aliases: []string{"i"},
 					Action: func(c *cli.Context) error {
 						input := c.Args().First()
@@ -300,22 +387,19 @@ func main() {
 								return err
 							}
 
-							return ApplyIPChanges(ApplyIPsInput{
-								Action:   "Log",
-								RID:      ParseResourceID(input),
-								DryRun:   c.Bool("dry-run"),
-								Output:   c.Bool("output"),
-								Filepath: c.String("file"),
-								MaxRules: c.Int("max-rules"),
-							})
+							return ApplyIPChanges(ipsInputFromContext(c, "Log", input))
 						}
 						_ = cli.ShowSubcommandHelp(c)
 
 						return nil
 					},
 				},
+				ipDeltaCommand("Log", MaxLogNetsRules, false),
+				ipDeltaCommand("Log", MaxLogNetsRules, true),
+				countriesCommand("Log"),
 			},
 		},
+		redirectCommand(),
 		{
 			Name:    "list",
 			Aliases: []string{"l"},
@@ -335,7 +419,7 @@ func main() {
 							return fmt.Errorf("subscription-id required")
 						}
 
-						return ListFrontDoors(c.String("subscription-id"))
+						return ListFrontDoors(c.String("subscription-id"), c.String("output-format"))
 					},
 				},
 				{
@@ -350,11 +434,29 @@ func main() {
 							return fmt.Errorf("subscription-id required")
 						}
 
-						return ListPolicies(c.String("subscription-id"), versionOutput, c.Int("max"))
+						return ListPolicies(c.String("subscription-id"), versionOutput, c.Int("max"), c.String("output-format"))
 					},
 				},
 			},
 		},
+		{
+			Name:      "diff",
+			Usage:     "show differences between two policies",
+			ArgsUsage: "<original> <new> (each a policy resource id, backup file or policy json file)",
+			Action: func(c *cli.Context) error {
+				if c.Args().Len() != 2 {
+					_ = cli.ShowSubcommandHelp(c)
+
+					return nil
+				}
+
+				return DiffPolicySources(DiffPoliciesInput{
+					Original:     c.Args().Get(0),
+					New:          c.Args().Get(1),
+					OutputFormat: c.String("output-format"),
+				})
+			},
+		},
 		{
 			Name:    "show",
 			Aliases: []string{"s"},
@@ -370,14 +472,16 @@ func main() {
 					Usage:   "show policy <policy resource id>",
 					Aliases: []string{"p"},
 					Flags: []cli.Flag{
-						&cli.StringFlag{Name: "rule-name", Usage: "filter by specific rule", Hidden: true},
+						&cli.StringSliceFlag{Name: "rule-name", Usage: "show rules with name or glob, e.g. BlockNets* (can be repeated)", Aliases: []string{"r"}},
+						&cli.StringFlag{Name: "prefix", Usage: "show rules with names starting with prefix", Aliases: []string{"p"}},
+						&cli.StringSliceFlag{Name: "action", Usage: "show rules with action: allow, block, log or redirect (can be repeated)", Aliases: []string{"a"}},
+						&cli.StringFlag{Name: "priority", Usage: "show rules with priorities in range, e.g. 1000-1999, 5000- or 100"},
+						&cli.StringFlag{Name: "state", Usage: "show rules that are enabled or disabled"},
+						&cli.StringSliceFlag{Name: "match-variable", Usage: "show rules with a condition on match variable, e.g. RemoteAddr (can be repeated)"},
+						&cli.BoolFlag{Name: "summary", Usage: "show number of rules per action and carbo prefix instead of rules"},
 						&cli.BoolFlag{Name: "show-full", Usage: "show all match conditions"},
 					},
 					Action: func(c *cli.Context) error {
-						if c.String("rule-name") != "" {
-							// TODO: filter by rule-name
-							fmt.Println("not yet implemented")
-						}
 						policyID := c.Args().First()
 						if err := ValidateResourceID(policyID, false); err != nil {
 							_ = cli.ShowSubcommandHelp(c)
@@ -385,7 +489,26 @@ func main() {
 							return err
 						}
 
-						return ShowPolicy(policyID, c.Bool("show-full"))
+						min, max, err := ParsePriorityRange(c.String("priority"))
+						if err != nil {
+							return err
+						}
+
+						return ShowPolicy(ShowPolicyInput{
+							PolicyID: policyID,
+							ShowFull: c.Bool("show-full"),
+							Filter: CustomRuleFilter{
+								Names:          c.StringSlice("rule-name"),
+								Prefix:         c.String("prefix"),
+								Actions:        c.StringSlice("action"),
+								PriorityMin:    min,
+								PriorityMax:    max,
+								EnabledState:   c.String("state"),
+								MatchVariables: c.StringSlice("match-variable"),
+							},
+							Summary:      c.Bool("summary"),
+							OutputFormat: c.String("output-format"),
+						})
 					},
 				},
 			},
@@ -414,7 +537,7 @@ func main() {
 							return err
 						}
 
-						return PrintPolicy(input)
+						return PrintPolicy(input, c.String("output-format"))
 					},
 				},
 				{
@@ -422,7 +545,7 @@ func main() {
 					Usage:   "get custom-rule using format \"<policy id>|<rule-name>\"",
 					Aliases: []string{"c"},
 					Flags: []cli.Flag{
-						&cli.StringFlag{Name: "output", Usage: "save custom-rule to path"},
+						&cli.StringFlag{Name: "save-path", Usage: "save custom-rule to path as json"},
 					},
 					Action: func(c *cli.Context) error {
 						// get custom rule match-value field using format "<policy id>|<rule-name>"
@@ -434,7 +557,7 @@ func main() {
 							return err
 						}
 
-						return PrintPolicyCustomRule(input)
+						return PrintPolicyCustomRule(input, c.String("output-format"), c.String("save-path"))
 					},
 				},
 			},
@@ -0,0 +1,94 @@
+package main
+
+import (
+	. "github.com/jonhadfield/carbo/helpers"
+	. "github.com/jonhadfield/carbo/policy"
+	"github.com/urfave/cli/v2"
+)
+
+// rateLimitCommand returns a command that sets and removes the rate limit rules in a policy
+func rateLimitCommand() *cli.Command {
+	outputFlags := []cli.Flag{
+		&cli.BoolFlag{Name: "output", Usage: "create and output new policy without applying", Aliases: []string{"o"}},
+		&cli.BoolFlag{Name: "dry-run", Usage: "show changes without applying", Aliases: []string{"d"}},
+	}
+
+	return &cli.Command{
+		Name:  "rate-limit",
+		Usage: "manage rate limits",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "set",
+				Usage:     "create a rate limit, or replace one with the same name",
+				ArgsUsage: "<policy id>",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{Name: "name", Usage: "name of rate limit (letters and numbers only)", Aliases: []string{"n"}, Required: true},
+					&cli.StringFlag{Name: "path", Usage: "limit requests with paths beginning with path, ignoring case, e.g. /api/login", Aliases: []string{"p"}},
+					&cli.StringFlag{Name: "host", Usage: "limit requests with host header"},
+					&cli.IntFlag{Name: "threshold", Usage: "number of requests allowed per client within duration", Aliases: []string{"t"}, Required: true},
+					&cli.IntFlag{Name: "duration", Usage: "minutes to count requests over: 1 or 5", Value: 1},
+					&cli.StringFlag{Name: "action", Usage: "action once threshold exceeded: block, log or redirect", Value: "block"},
+					&cli.StringSliceFlag{Name: "ip", Usage: "only limit clients within ip or network (can be repeated)"},
+				}, outputFlags...),
+				Action: func(c *cli.Context) error {
+					input := c.Args().First()
+					if input == "" {
+						_ = cli.ShowSubcommandHelp(c)
+
+						return nil
+					}
+
+					if err := ValidateResourceID(input, false); err != nil {
+						_ = cli.ShowSubcommandHelp(c)
+
+						return err
+					}
+
+					return ApplyRateLimits(ApplyRateLimitsInput{
+						RID: ParseResourceID(input),
+						RateLimits: []RateLimit{{
+							Name:      c.String("name"),
+							Path:      c.String("path"),
+							Host:      c.String("host"),
+							Threshold: c.Int("threshold"),
+							Duration:  c.Int("duration"),
+							Action:    c.String("action"),
+							IPs:       c.StringSlice("ip"),
+						}},
+						DryRun:       c.Bool("dry-run"),
+						Output:       c.Bool("output"),
+						OutputFormat: c.String("output-format"),
+					})
+				},
+			},
+			{
+				Name:      "remove",
+				Usage:     "remove rate limits by name",
+				ArgsUsage: "<policy id> <name> [name ...]",
+				Flags:     outputFlags,
+				Action: func(c *cli.Context) error {
+					input := c.Args().First()
+					if input == "" || c.Args().Len() < 2 {
+						_ = cli.ShowSubcommandHelp(c)
+
+						return nil
+					}
+
+					if err := ValidateResourceID(input, false); err != nil {
+						_ = cli.ShowSubcommandHelp(c)
+
+						return err
+					}
+
+					return ApplyRateLimits(ApplyRateLimitsInput{
+						RID:          ParseResourceID(input),
+						Remove:       c.Args().Tail(),
+						DryRun:       c.Bool("dry-run"),
+						Output:       c.Bool("output"),
+						OutputFormat: c.String("output-format"),
+					})
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	. "github.com/jonhadfield/carbo/helpers"
+	. "github.com/jonhadfield/carbo/policy"
+	"github.com/urfave/cli/v2"
+)
+
+// redirectCommand returns a command that manages the networks whose requests are redirected to the policy's
+// redirect url
+func redirectCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "redirect",
+		Usage: "redirect requests to the policy's redirect url",
+		Subcommands: []*cli.Command{
+			{
+				Name:    "ips",
+				Usage:   "specify list(s) of IPs to redirect",
+				Flags:   ipsFlags(MaxRedirectNetsRules),
+				Aliases: []string{"i"},
+				Action: func(c *cli.Context) error {
+					input := c.Args().First()
+					if input == "" {
+						_ = cli.ShowSubcommandHelp(c)
+
+						return nil
+					}
+
+					if err := ValidateResourceID(input, false); err != nil {
+						_ = cli.ShowSubcommandHelp(c)
+
+						return err
+					}
+
+					return ApplyIPChanges(ipsInputFromContext(c, "Redirect", input))
+				},
+			},
+			ipDeltaCommand("Redirect", MaxRedirectNetsRules, false),
+			ipDeltaCommand("Redirect", MaxRedirectNetsRules, true),
+		},
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	. "github.com/jonhadfield/carbo/policy"
+	"github.com/urfave/cli/v2"
+)
+
+// scopeFlags returns the flags limiting the requests an action's ips apply to
+func scopeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{Name: "scope-path", Usage: "only match requests with paths beginning with path, ignoring case, e.g. /api/partner/ (can be repeated)"},
+		&cli.StringSliceFlag{Name: "scope-host", Usage: "only match requests with host header (can be repeated)"},
+		&cli.StringSliceFlag{Name: "scope-method", Usage: "only match requests with method, e.g. POST (can be repeated)"},
+		&cli.StringFlag{Name: "match-variable", Usage: "address to match ips against: RemoteAddr or SocketAddr", Value: "RemoteAddr"},
+	}
+}
+
+// scopeFromContext returns the scope specified by the scope flags
+func scopeFromContext(c *cli.Context) RuleScope {
+	return RuleScope{
+		Paths:         c.StringSlice("scope-path"),
+		Hosts:         c.StringSlice("scope-host"),
+		Methods:       c.StringSlice("scope-method"),
+		MatchVariable: c.String("match-variable"),
+	}
+}
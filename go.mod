@@ -19,7 +19,7 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.8.0
-	github.com/tidwall/gjson v1.14.1 // indirect
+	github.com/tidwall/gjson v1.14.1
 	github.com/urfave/cli/v2 v2.11.0
 	github.com/wI2L/jsondiff v0.2.0
 	github.com/ztrue/tracerr v0.3.0
@@ -0,0 +1,48 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleActions are the actions Front Door can take when a rule matches. AnomalyScoring is only supported by managed
+// rules, with the score from every matching rule determining if a request is blocked.
+var RuleActions = []string{"Allow", "Block", "Log", "Redirect", "AnomalyScoring"}
+
+// CustomRuleActions are the actions supported by custom rules
+var CustomRuleActions = []string{"Allow", "Block", "Log", "Redirect"}
+
+// TerminatingActions are the custom rule actions that stop any further rules being evaluated. Log is excluded as
+// requests continue to be evaluated once logged.
+var TerminatingActions = []string{"Allow", "Block", "Redirect"}
+
+// NormaliseRuleAction returns the action in the case used by Front Door, e.g. Block, or an error if it is not an
+// action Front Door supports
+func NormaliseRuleAction(action string) (string, error) {
+	for _, a := range RuleActions {
+		if strings.EqualFold(strings.TrimSpace(action), a) {
+			return a, nil
+		}
+	}
+
+	return "", fmt.Errorf("unexpected action: %s", action)
+}
+
+// NormaliseCustomRuleAction behaves as NormaliseRuleAction but only accepts actions supported by custom rules
+func NormaliseCustomRuleAction(action string) (string, error) {
+	a, err := NormaliseRuleAction(action)
+	if err != nil {
+		return "", err
+	}
+
+	if !StringInSlice(a, CustomRuleActions, false) {
+		return "", fmt.Errorf("action %s is only supported by managed rules", a)
+	}
+
+	return a, nil
+}
+
+// IsTerminatingAction returns true if matching a rule with the action stops any further rules being evaluated
+func IsTerminatingAction(action string) bool {
+	return StringInSlice(strings.TrimSpace(action), TerminatingActions, true)
+}
@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormaliseRuleAction(t *testing.T) {
+	for input, expected := range map[string]string{
+		"block":          "Block",
+		" Allow":         "Allow",
+		"LOG":            "Log",
+		"redirect":       "Redirect",
+		"anomalyscoring": "AnomalyScoring",
+	} {
+		a, err := NormaliseRuleAction(input)
+		require.NoError(t, err)
+		require.Equal(t, expected, a)
+	}
+
+	_, err := NormaliseRuleAction("deny")
+	require.Error(t, err)
+
+	a, err := NormaliseCustomRuleAction("redirect")
+	require.NoError(t, err)
+	require.Equal(t, "Redirect", a)
+
+	_, err = NormaliseCustomRuleAction("AnomalyScoring")
+	require.Error(t, err)
+}
+
+func TestIsTerminatingAction(t *testing.T) {
+	for _, a := range []string{"Allow", "block", "REDIRECT"} {
+		require.True(t, IsTerminatingAction(a))
+	}
+
+	for _, a := range []string{"Log", "AnomalyScoring", ""} {
+		require.False(t, IsTerminatingAction(a))
+	}
+}
@@ -5,6 +5,9 @@ const (
 	// - 1: Log (manual 0-999, carbo 1000-1999)
 	// - 2: Allow (manual 2000-2999, carbo 3000-3999)
 	// - 3: Block (manual 4000-4999, carbo 5000-5999)
+	// - 4: Rate limits (carbo 6000-6999)
+	// - 5: Redirect (carbo 7000-7999)
+	// within each carbo range, networks start at x000 and countries at x500
 
 	// MaxPoliciesToFetch is the maximum number to attempt to retrieve (not an Azure limit)
 	MaxPoliciesToFetch = 200
@@ -18,8 +21,17 @@ const (
 	MaxBlockNetsRules = 40
 	// MaxAllowNetsRules is the maximum number of custom rules to create from Azure's hard limit of 90 per Policy
 	MaxAllowNetsRules = 10
+	// MaxRedirectNetsRules is the maximum number of custom rules to create from Azure's hard limit of 90 per Policy
+	MaxRedirectNetsRules = 10
 	// MaxIPMatchValues is Azure's hard limit on IPMatch values per rule
 	MaxIPMatchValues = 600
+	// MaxMatchConditions is Azure's hard limit on match conditions per rule
+	MaxMatchConditions = 10
+
+	// IPv4MatchAll is the IPv4 network matching every address
+	IPv4MatchAll = "0.0.0.0/0"
+	// IPv6MatchAll is the IPv6 network matching every address
+	IPv6MatchAll = "::/0"
 
 	// LogNetsPrefix is the prefix for Custom Rules used for logging IP networks
 	LogNetsPrefix = "LogNets"
@@ -39,6 +51,37 @@ const (
 	// Manual block rules should be numbered 4000-4999
 	BlockNetsPriorityStart = 5000
 
+	// RedirectNetsPrefix is the prefix for Custom Rules used for redirecting IP networks
+	RedirectNetsPrefix = "RedirectNets"
+	// RedirectNetsPriorityStart is the first custom rule priority number
+	// Redirects follow blocks and rate limits so blocked networks are never redirected
+	RedirectNetsPriorityStart = 7000
+
+	// LogCountriesPrefix is the prefix for Custom Rules used for logging countries
+	LogCountriesPrefix = "LogCountries"
+	// LogCountriesPriorityStart is the first custom rule priority number for logging countries
+	LogCountriesPriorityStart = 1500
+
+	// AllowCountriesPrefix is the prefix for Custom Rules used for allowing countries
+	AllowCountriesPrefix = "AllowCountries"
+	// AllowCountriesPriorityStart is the first custom rule priority number for allowing countries
+	AllowCountriesPriorityStart = 3500
+
+	// BlockCountriesPrefix is the prefix for Custom Rules used for blocking countries
+	BlockCountriesPrefix = "BlockCountries"
+	// BlockCountriesPriorityStart is the first custom rule priority number for blocking countries
+	BlockCountriesPriorityStart = 5500
+
+	// DefaultDenyPrefix is the name of the Custom Rule blocking requests from networks not in its allow list
+	DefaultDenyPrefix = "DefaultDeny"
+	// DefaultDenyPriority is the priority of the default deny rule, following the allow rules so they still apply
+	DefaultDenyPriority = 3999
+
+	// RateLimitPrefix is the prefix for Custom Rules used for rate limiting requests
+	RateLimitPrefix = "RateLimit"
+	// RateLimitPriorityStart is the first custom rule priority number for rate limits
+	RateLimitPriorityStart = 6000
+
 	// MaxMatchValuesPerColumn is the number of match values to output per column when showing policies and rules
 	MaxMatchValuesPerColumn = 3
 	// MaxMatchValuesOutput is the maximum number of match values to output when showing policies and rules
@@ -0,0 +1,73 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isoCountryCodes are the ISO 3166-1 alpha-2 country codes, plus XK, the user-assigned code for Kosovo in common use
+// by geolocation providers, and ZZ which Front Door uses for addresses it cannot map to a country
+var isoCountryCodes = makeCountryCodes(`
+AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ
+BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ
+CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ
+DE DJ DK DM DO DZ
+EC EE EG EH ER ES ET
+FI FJ FK FM FO FR
+GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY
+HK HM HN HR HT HU
+ID IE IL IM IN IO IQ IR IS IT
+JE JM JO JP
+KE KG KH KI KM KN KP KR KW KY KZ
+LA LB LC LI LK LR LS LT LU LV LY
+MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ
+NA NC NE NF NG NI NL NO NP NR NU NZ
+OM
+PA PE PF PG PH PK PL PM PN PR PS PT PW PY
+QA
+RE RO RS RU RW
+SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ
+TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ
+UA UG UM US UY UZ
+VA VC VE VG VI VN VU
+WF WS
+XK
+YE YT
+ZA ZM ZW
+ZZ
+`)
+
+func makeCountryCodes(codes string) map[string]bool {
+	res := make(map[string]bool)
+
+	for _, c := range strings.Fields(codes) {
+		res[c] = true
+	}
+
+	return res
+}
+
+// NormaliseCountryCode returns the country code in upper case, or an error if it is not an ISO 3166-1 alpha-2 code
+func NormaliseCountryCode(code string) (string, error) {
+	c := strings.ToUpper(strings.TrimSpace(code))
+
+	if !isoCountryCodes[c] {
+		return "", fmt.Errorf("invalid country code: %s", code)
+	}
+
+	return c, nil
+}
+
+// CountriesPrefixFromAction accepts an action as string and returns the prefix to use in a country custom rule
+func CountriesPrefixFromAction(action string) (prefix string, err error) {
+	switch action {
+	case "Block":
+		return BlockCountriesPrefix, nil
+	case "Allow":
+		return AllowCountriesPrefix, nil
+	case "Log":
+		return LogCountriesPrefix, nil
+	default:
+		return "", fmt.Errorf("unexpected action: %s", action)
+	}
+}
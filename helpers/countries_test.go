@@ -0,0 +1,37 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormaliseCountryCode(t *testing.T) {
+	c, err := NormaliseCountryCode(" gb ")
+	require.NoError(t, err)
+	require.Equal(t, "GB", c)
+
+	c, err = NormaliseCountryCode("ZZ")
+	require.NoError(t, err)
+	require.Equal(t, "ZZ", c)
+
+	c, err = NormaliseCountryCode("xk")
+	require.NoError(t, err)
+	require.Equal(t, "XK", c)
+
+	_, err = NormaliseCountryCode("UK")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid country code: UK")
+
+	_, err = NormaliseCountryCode("GBR")
+	require.Error(t, err)
+}
+
+func TestCountriesPrefixFromAction(t *testing.T) {
+	p, err := CountriesPrefixFromAction("Block")
+	require.NoError(t, err)
+	require.Equal(t, BlockCountriesPrefix, p)
+
+	_, err = CountriesPrefixFromAction("Deny")
+	require.Error(t, err)
+}
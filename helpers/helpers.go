@@ -137,8 +137,9 @@ func Confirm(item, request string) bool {
 	return false
 }
 
+// SortRules orders custom rules by priority, retaining the existing order of any with equal priority
 func SortRules(customRules []frontdoor.CustomRule) {
-	sort.Slice(customRules, func(i, j int) bool {
+	sort.SliceStable(customRules, func(i, j int) bool {
 		return *customRules[i].Priority < *customRules[j].Priority
 	})
 }
@@ -152,6 +153,8 @@ func PrefixFromAction(action string) (prefix string, err error) {
 		return AllowNetsPrefix, nil
 	case "Log":
 		return LogNetsPrefix, nil
+	case "Redirect":
+		return RedirectNetsPrefix, nil
 	default:
 		return "", fmt.Errorf("unexpected action: %s", action)
 	}
@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"fmt"
+	"net"
 	"regexp"
 	"strings"
 
@@ -43,13 +44,27 @@ func ValidateResourceIDs(ids []string) error {
 	return nil
 }
 
+// IsMatchAllNet returns true if the value is a network matching every IPv4 or IPv6 address, e.g. 0.0.0.0/0 or ::/0
+func IsMatchAllNet(value string) bool {
+	_, ipn, err := net.ParseCIDR(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+
+	ones, _ := ipn.Mask.Size()
+
+	return ones == 0
+}
+
 func MatchValuesHasMatchAll(mvs *[]string, matchVariable frontdoor.MatchVariable, operator frontdoor.Operator) (res bool, err error) {
 	switch matchVariable {
-	case "RemoteAddr":
+	case "RemoteAddr", "SocketAddr":
 		switch operator {
 		case "IPMatch":
-			if StringInSlice("0.0.0.0/0", *mvs, false) {
-				return true, nil
+			for _, mv := range *mvs {
+				if IsMatchAllNet(mv) {
+					return true, nil
+				}
 			}
 		}
 	default:
@@ -74,33 +89,39 @@ func MatchConditionHasDefaultUnknown(mc frontdoor.MatchCondition) (result bool,
 	return
 }
 
+// CustomRuleHasDefaultDeny returns true if the rule blocks every address other than those it excludes, i.e. each of
+// its conditions is an IPMatch that either matches all addresses or is negated. Rules with other conditions, such as
+// a path, only apply to some requests so are not a default deny.
 func CustomRuleHasDefaultDeny(c frontdoor.CustomRule) (defaultDeny bool, err error) {
-	// if all match conditions have "if not... then deny" (other than a single rule saying if not 0.0.0.0/0 then deny) then they do
-	// if a rule only has "if ip 0.0.0.0/0 then deny" then true
-	switch c.Action {
-	case "Block":
+	if c.Action != "Block" || c.MatchConditions == nil || len(*c.MatchConditions) == 0 {
+		return
+	}
+
+	for _, mc := range *c.MatchConditions {
+		if mc.Operator != "IPMatch" || (mc.MatchVariable != "RemoteAddr" && mc.MatchVariable != "SocketAddr") {
+			return false, nil
+		}
+
 		var du bool
-		// check if any match condition has a default unknown
-		for _, mc := range *c.MatchConditions {
-			du, err = MatchConditionHasDefaultUnknown(mc)
-			if err != nil {
-				return
-			}
 
-			if du {
-				return true, nil
-			}
+		du, err = MatchConditionHasDefaultUnknown(mc)
+		if err != nil || !du {
+			return false, err
 		}
 	}
 
-	return
+	return true, nil
 }
 
 func PolicyHasDefaultDeny(p frontdoor.WebApplicationFirewallPolicy) (defaultDeny bool, err error) {
 	// if Policy has "if not... then deny" then they do
 	// if Policy has "if ip 0.0.0.0/0 then deny" then true
+	if p.CustomRules == nil || p.CustomRules.Rules == nil {
+		return
+	}
+
 	for _, cr := range *p.CustomRules.Rules {
-		if cr.EnabledState != "CustomRuleEnabledStateEnabled" {
+		if cr.EnabledState != frontdoor.CustomRuleEnabledStateDisabled {
 			var dd bool
 
 			dd, err = CustomRuleHasDefaultDeny(cr)
@@ -112,8 +133,3 @@ func PolicyHasDefaultDeny(p frontdoor.WebApplicationFirewallPolicy) (defaultDeny
 
 	return
 }
-
-// TODO: Add a default deny option where it's Deny if IP 0.0.0.0/0 (IPv6?)
-// func AddDefaultDeny(p frontdoor.WebApplicationFirewallPolicy) (up frontdoor.WebApplicationFirewallPolicy, err error) {
-//
-// }
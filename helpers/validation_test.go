@@ -202,6 +202,49 @@ func TestCustomRuleHasDefaultDenyFive(t *testing.T) {
 	require.True(t, dd)
 }
 
+// Condition 1: negative match for specific ips
+// Condition 2: positive match for a path, so only some requests are denied
+func TestCustomRuleHasDefaultDenySix(t *testing.T) {
+	ipnpi := ipMatchValuesNoPublicInternet()
+	paths := []string{"/admin"}
+
+	mcSet := []frontdoor.MatchCondition{{
+		MatchVariable:   "SocketAddr",
+		Operator:        "IPMatch",
+		NegateCondition: boolToPointer(true),
+		MatchValue:      &ipnpi,
+	}, {
+		MatchVariable:   "RequestUri",
+		Operator:        "Contains",
+		NegateCondition: boolToPointer(false),
+		MatchValue:      &paths,
+	}}
+
+	dd, err := CustomRuleHasDefaultDeny(frontdoor.CustomRule{
+		Name:            strToPointer("CustomRuleWithScopedDeny"),
+		Priority:        int32ToPointer(1),
+		EnabledState:    "Enabled",
+		RuleType:        "MatchRule",
+		MatchConditions: &mcSet,
+		Action:          "Block",
+	})
+	require.NoError(t, err)
+	require.False(t, dd)
+
+	mcSet = mcSet[:1]
+
+	dd, err = CustomRuleHasDefaultDeny(frontdoor.CustomRule{
+		Name:            strToPointer("CustomRuleWithDefaultDeny"),
+		Priority:        int32ToPointer(1),
+		EnabledState:    "Enabled",
+		RuleType:        "MatchRule",
+		MatchConditions: &mcSet,
+		Action:          "Block",
+	})
+	require.NoError(t, err)
+	require.True(t, dd)
+}
+
 // func customRuleWithDefaultDeny() frontdoor.CustomRule {
 // 	ipnpi := ipMatchValuesNoPublicInternet()
 // 	mc1 := frontdoor.MatchCondition{
@@ -299,6 +342,25 @@ func TestMatchValuesHasMatchAll(t *testing.T) {
 	res, err = MatchValuesHasMatchAll(&ipnpi, "RemoteAddr", "IPMatch")
 	require.NoError(t, err)
 	require.False(t, res)
+
+	ipv6wpi := []string{"2001:db8::/32", IPv6MatchAll}
+	res, err = MatchValuesHasMatchAll(&ipv6wpi, "RemoteAddr", "IPMatch")
+	require.NoError(t, err)
+	require.True(t, res)
+
+	ipv6npi := []string{"2001:db8::/32", "::1/128"}
+	res, err = MatchValuesHasMatchAll(&ipv6npi, "RemoteAddr", "IPMatch")
+	require.NoError(t, err)
+	require.False(t, res)
+}
+
+func TestIsMatchAllNet(t *testing.T) {
+	require.True(t, IsMatchAllNet("0.0.0.0/0"))
+	require.True(t, IsMatchAllNet("::/0"))
+	require.True(t, IsMatchAllNet("0:0::/0"))
+	require.False(t, IsMatchAllNet("0.0.0.0/1"))
+	require.False(t, IsMatchAllNet("::/128"))
+	require.False(t, IsMatchAllNet("not an ip"))
 }
 
 // func MatchValuesHasMatchAll(mvs *[]string, matchVariable frontdoor.MatchVariable, operator frontdoor.Operator) (res bool, err error) {
@@ -51,7 +51,7 @@ func TestGenerateCustomRulesFromIPNets(t *testing.T) {
 
 	require.Len(t, ipns, 16382)
 
-	crs, err := policy.GenCustomRulesFromIPNets(ipns, 90, "Block")
+	crs, _, err := policy.GenCustomRulesFromIPNets(ipns, 90, "Block")
 	require.NoError(t, err)
 	require.Len(t, crs, 28)
 
@@ -70,10 +70,13 @@ func TestGenerateCustomRulesFromIPNetsLimitsToMaxRules(t *testing.T) {
 	ipns := generateIPNets("10.0.0.0/21")
 	require.Len(t, ipns, 2046)
 
-	crs, err := policy.GenCustomRulesFromIPNets(ipns, 3, "Block")
+	crs, dropped, err := policy.GenCustomRulesFromIPNets(ipns, 3, "Block")
 	require.NoError(t, err)
 
 	require.Len(t, crs, 3)
+	// networks that did not fit in the rules are returned as dropped
+	require.Len(t, dropped, 2046-3*helpers.MaxIPMatchValues)
+	require.Equal(t, ipns[3*helpers.MaxIPMatchValues].String(), dropped[0].String())
 }
 
 // Require that setting a zero value for max rules does not limit the number of rules generated
@@ -81,10 +84,11 @@ func TestGenerateCustomRulesFromIPNetsLimitsNotLimitedWhenMaxRulesZero(t *testin
 	ipns := generateIPNets("10.0.0.0/21")
 	require.Len(t, ipns, 2046)
 
-	crs, err := policy.GenCustomRulesFromIPNets(ipns, 0, "Block")
+	crs, dropped, err := policy.GenCustomRulesFromIPNets(ipns, 0, "Block")
 
 	require.NoError(t, err)
 	require.Len(t, crs, 4)
+	require.Empty(t, dropped)
 }
 
 // Require error if action not recognised
@@ -92,6 +96,28 @@ func TestGenerateCustomRulesFromIPNetsWithInvalidAction(t *testing.T) {
 	ipns := generateIPNets("10.0.0.0/21")
 
 	require.Len(t, ipns, 2046)
-	_, err := policy.GenCustomRulesFromIPNets(ipns, 0, "Blocker")
+	_, _, err := policy.GenCustomRulesFromIPNets(ipns, 0, "Blocker")
 	require.Error(t, err)
 }
+
+// Require that a sharded action's networks are compared with the lists of its shard policies
+func TestCheckActionsOverlapsWithShards(t *testing.T) {
+	first := "/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorWebApplicationFirewallPolicies/apple"
+	shard := "/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorWebApplicationFirewallPolicies/banana"
+
+	block, err := policy.ParseIPNet("10.0.0.1")
+	require.NoError(t, err)
+
+	allow, err := policy.ParseIPNet("10.0.0.0/24")
+	require.NoError(t, err)
+
+	as := []policy.Action{
+		{ActionType: "Block", Policy: first, Shards: []string{shard}, Nets: policy.IPNets{block}},
+		{ActionType: "Allow", Policy: shard, Nets: policy.IPNets{allow}},
+	}
+
+	require.Error(t, checkActionsOverlaps(as, true))
+
+	as[0].Shards = nil
+	require.NoError(t, checkActionsOverlaps(as, true))
+}
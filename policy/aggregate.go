@@ -0,0 +1,132 @@
+package policy
+
+import (
+	"bytes"
+	"net"
+	"sort"
+)
+
+// normaliseIPNet returns a copy of the network with its address masked and, for IPv4, stored in its four byte form
+func normaliseIPNet(ipn net.IPNet) net.IPNet {
+	ones, bits := ipn.Mask.Size()
+
+	ip := ipn.IP.To4()
+	if ip == nil || bits == 8*net.IPv6len {
+		ip = ipn.IP.To16()
+	}
+
+	mask := net.CIDRMask(ones, 8*len(ip))
+
+	return net.IPNet{
+		IP:   ip.Mask(mask),
+		Mask: mask,
+	}
+}
+
+// compareIPNets orders networks by address family, then address, then prefix length (shortest first)
+func compareIPNets(a, b net.IPNet) int {
+	if len(a.IP) != len(b.IP) {
+		if len(a.IP) < len(b.IP) {
+			return -1
+		}
+
+		return 1
+	}
+
+	if c := bytes.Compare(a.IP, b.IP); c != 0 {
+		return c
+	}
+
+	aOnes, _ := a.Mask.Size()
+	bOnes, _ := b.Mask.Size()
+
+	switch {
+	case aOnes < bOnes:
+		return -1
+	case aOnes > bOnes:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// sortIPNets sorts normalised networks in place using compareIPNets
+func sortIPNets(ipns IPNets) {
+	sort.Slice(ipns, func(i, j int) bool {
+		return compareIPNets(ipns[i], ipns[j]) < 0
+	})
+}
+
+// ipNetContains returns true if network b is equal to, or falls entirely within, network a
+func ipNetContains(a, b net.IPNet) bool {
+	if len(a.IP) != len(b.IP) {
+		return false
+	}
+
+	aOnes, _ := a.Mask.Size()
+	bOnes, _ := b.Mask.Size()
+
+	return aOnes <= bOnes && a.Contains(b.IP)
+}
+
+// supernetOfSiblings returns the parent network if a and b are the two halves of the same supernet
+func supernetOfSiblings(a, b net.IPNet) (parent net.IPNet, ok bool) {
+	if len(a.IP) != len(b.IP) {
+		return
+	}
+
+	aOnes, bits := a.Mask.Size()
+	bOnes, _ := b.Mask.Size()
+
+	if aOnes != bOnes || aOnes == 0 {
+		return
+	}
+
+	mask := net.CIDRMask(aOnes-1, bits)
+
+	if !a.IP.Mask(mask).Equal(b.IP.Mask(mask)) || a.IP.Equal(b.IP) {
+		return
+	}
+
+	return net.IPNet{
+		IP:   a.IP.Mask(mask),
+		Mask: mask,
+	}, true
+}
+
+// AggregateIPNets accepts a slice of IPv4 and/or IPv6 networks and returns the smallest equivalent set by removing
+// duplicates, dropping networks contained within others, and merging adjacent networks into their supernets.
+// The result is sorted, and removed is the number of entries no longer required.
+func AggregateIPNets(ipns IPNets) (res IPNets, removed int) {
+	if len(ipns) == 0 {
+		return
+	}
+
+	sorted := make(IPNets, 0, len(ipns))
+	for _, ipn := range ipns {
+		sorted = append(sorted, normaliseIPNet(ipn))
+	}
+
+	sortIPNets(sorted)
+
+	for _, ipn := range sorted {
+		// sorting places any containing network before those it contains
+		if len(res) > 0 && ipNetContains(res[len(res)-1], ipn) {
+			continue
+		}
+
+		res = append(res, ipn)
+
+		// merge the two most recent networks for as long as they form a supernet
+		for len(res) > 1 {
+			parent, ok := supernetOfSiblings(res[len(res)-2], res[len(res)-1])
+			if !ok {
+				break
+			}
+
+			res = append(res[:len(res)-2], parent)
+		}
+	}
+
+	return res, len(ipns) - len(res)
+}
@@ -0,0 +1,50 @@
+package policy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parseIPNets(t *testing.T, cidrs ...string) (ipns IPNets) {
+	t.Helper()
+
+	for _, c := range cidrs {
+		_, ipn, err := net.ParseCIDR(c)
+		require.NoError(t, err)
+
+		ipns = append(ipns, *ipn)
+	}
+
+	return
+}
+
+func TestAggregateIPNetsRemovesContained(t *testing.T) {
+	res, removed := AggregateIPNets(parseIPNets(t, "10.0.0.5/32", "10.0.0.0/24", "10.0.0.0/24", "10.0.0.128/25"))
+	require.Equal(t, 3, removed)
+	require.Equal(t, []string{"10.0.0.0/24"}, res.toString())
+}
+
+func TestAggregateIPNetsMergesAdjacent(t *testing.T) {
+	res, removed := AggregateIPNets(parseIPNets(t, "10.0.1.0/25", "10.0.0.128/25", "10.0.0.0/25", "10.0.1.128/25"))
+	require.Equal(t, 3, removed)
+	require.Equal(t, []string{"10.0.0.0/23"}, res.toString())
+
+	// adjacent networks that do not share a supernet must not be merged
+	res, removed = AggregateIPNets(parseIPNets(t, "10.0.0.128/25", "10.0.1.0/25"))
+	require.Equal(t, 0, removed)
+	require.Equal(t, []string{"10.0.0.128/25", "10.0.1.0/25"}, res.toString())
+}
+
+func TestAggregateIPNetsSortsAndMergesAfterSupernet(t *testing.T) {
+	res, removed := AggregateIPNets(parseIPNets(t, "192.168.0.3/32", "192.168.0.2/32", "192.168.0.0/31", "1.1.1.1/32"))
+	require.Equal(t, 2, removed)
+	require.Equal(t, []string{"1.1.1.1/32", "192.168.0.0/30"}, res.toString())
+}
+
+func TestAggregateIPNetsEmpty(t *testing.T) {
+	res, removed := AggregateIPNets(nil)
+	require.Empty(t, res)
+	require.Zero(t, removed)
+}
@@ -0,0 +1,158 @@
+package policy
+
+import (
+	"net"
+	"sort"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/jonhadfield/carbo/helpers"
+)
+
+// ruleChunk is a set of networks to be held by a single custom rule with the given priority
+type ruleChunk struct {
+	priority int32
+	nets     IPNets
+}
+
+// chunkIPNets splits sorted networks into chunks of the maximum number of match values per rule
+// with sequential priorities beginning at priorityStart
+func chunkIPNets(ipns IPNets, priorityStart int32) (chunks []ruleChunk) {
+	priority := priorityStart
+
+	for start := 0; start < len(ipns); start += helpers.MaxIPMatchValues {
+		end := start + helpers.MaxIPMatchValues
+		if end > len(ipns) {
+			end = len(ipns)
+		}
+
+		chunks = append(chunks, ruleChunk{
+			priority: priority,
+			nets:     ipns[start:end],
+		})
+
+		priority++
+	}
+
+	return
+}
+
+// ipNetsFromCustomRule returns the networks matched by a custom rule's non-negated RemoteAddr or SocketAddr IPMatch
+// conditions
+func ipNetsFromCustomRule(cr frontdoor.CustomRule) (ipns IPNets) {
+	if cr.MatchConditions == nil {
+		return
+	}
+
+	for _, mc := range *cr.MatchConditions {
+		if (mc.MatchVariable != "RemoteAddr" && mc.MatchVariable != "SocketAddr") || mc.Operator != "IPMatch" || mc.MatchValue == nil {
+			continue
+		}
+
+		if mc.NegateCondition != nil && *mc.NegateCondition {
+			continue
+		}
+
+		for _, mv := range *mc.MatchValue {
+			ipn, err := ParseIPNet(mv)
+			if err != nil {
+				continue
+			}
+
+			ipns = append(ipns, normaliseIPNet(ipn))
+		}
+	}
+
+	return
+}
+
+// chunkIPNetsByRuleRange splits sorted networks into chunks keyed on the address ranges of the existing rules.
+// Each existing rule is keyed on its lowest network and receives every network from that key up to the key of the
+// next rule, retaining the existing rule's priority. Chunks exceeding the maximum number of match values are split
+// evenly, with the additional parts given the lowest priorities not already in use. Without existing rules the
+// networks are split as per chunkIPNets.
+func chunkIPNetsByRuleRange(ipns IPNets, existing []frontdoor.CustomRule, priorityStart int32) (chunks []ruleChunk) {
+	type rangeKey struct {
+		priority int32
+		lowest   net.IPNet
+	}
+
+	var keys []rangeKey
+
+	for _, cr := range existing {
+		nets := ipNetsFromCustomRule(cr)
+		if len(nets) == 0 || cr.Priority == nil {
+			continue
+		}
+
+		sortIPNets(nets)
+
+		keys = append(keys, rangeKey{priority: *cr.Priority, lowest: nets[0]})
+	}
+
+	if len(keys) == 0 {
+		return chunkIPNets(ipns, priorityStart)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return compareIPNets(keys[i].lowest, keys[j].lowest) < 0
+	})
+
+	grouped := make([]IPNets, len(keys))
+
+	for _, ipn := range ipns {
+		// find the last rule keyed at or below the network, defaulting to the first
+		x := sort.Search(len(keys), func(i int) bool {
+			return compareIPNets(keys[i].lowest, ipn) > 0
+		}) - 1
+		if x < 0 {
+			x = 0
+		}
+
+		grouped[x] = append(grouped[x], ipn)
+	}
+
+	used := make(map[int32]bool)
+	for _, k := range keys {
+		used[k.priority] = true
+	}
+
+	nextPriority := priorityStart
+
+	var overflow []IPNets
+
+	for x, g := range grouped {
+		if len(g) == 0 {
+			continue
+		}
+
+		// split a full chunk evenly so each part has capacity for later additions
+		parts := 1
+		if len(g) > helpers.MaxIPMatchValues {
+			parts = len(g)/helpers.MaxIPMatchValues + 1
+		}
+
+		size := (len(g) + parts - 1) / parts
+
+		chunks = append(chunks, ruleChunk{priority: keys[x].priority, nets: g[:size]})
+
+		for start := size; start < len(g); start += size {
+			end := start + size
+			if end > len(g) {
+				end = len(g)
+			}
+
+			overflow = append(overflow, g[start:end])
+		}
+	}
+
+	for _, o := range overflow {
+		for used[nextPriority] {
+			nextPriority++
+		}
+
+		chunks = append(chunks, ruleChunk{priority: nextPriority, nets: o})
+		used[nextPriority] = true
+	}
+
+	return
+}
@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+// hostIPNets returns n IPv4 host networks, spaced two addresses apart, starting at 10.0.0.0
+func hostIPNets(n int) (ipns IPNets) {
+	for x := 0; x < n; x++ {
+		ipns = append(ipns, net.IPNet{
+			IP:   net.IPv4(10, byte(x*2>>16), byte(x*2>>8), byte(x*2)).To4(),
+			Mask: net.CIDRMask(32, 32),
+		})
+	}
+
+	return
+}
+
+// changedRules returns the names of rules that differ between the two sets
+func changedRules(before, after []frontdoor.CustomRule) (changed []string) {
+	rules := make(map[string]frontdoor.CustomRule)
+	for _, cr := range before {
+		rules[*cr.Name] = cr
+	}
+
+	for _, cr := range after {
+		if b, ok := rules[*cr.Name]; !ok || !reflect.DeepEqual(b, cr) {
+			changed = append(changed, *cr.Name)
+		}
+
+		delete(rules, *cr.Name)
+	}
+
+	for name := range rules {
+		changed = append(changed, name)
+	}
+
+	return
+}
+
+func TestGenCustomRulesFromIPNetsSortsNetworks(t *testing.T) {
+	crs, _, err := GenCustomRulesFromIPNets(parseIPNets(t, "2001:db8::1/128", "10.0.0.2/32", "1.1.1.1/32"), 1, "Block")
+	require.NoError(t, err)
+	require.Equal(t, []string{"1.1.1.1/32", "10.0.0.2/32", "2001:db8::1/128"}, *(*crs[0].MatchConditions)[0].MatchValue)
+}
+
+func TestGenStableCustomRulesAddingNetworkChangesOneRule(t *testing.T) {
+	ipns := hostIPNets(3000)
+
+	fresh, _, err := GenCustomRulesFromIPNets(ipns, 10, "Block")
+	require.NoError(t, err)
+	require.Len(t, fresh, 5)
+
+	// add an address to the full third rule, which is split to leave capacity
+	added := append(IPNets{}, ipns...)
+	added = append(added, parseIPNets(t, "10.0.9.97/32")...)
+
+	before, dropped, err := GenStableCustomRulesFromIPNets(added, fresh, 10, "Block")
+	require.NoError(t, err)
+	require.Empty(t, dropped)
+	require.Len(t, before, 6)
+	require.ElementsMatch(t, []string{
+		fmt.Sprintf("%s%d", helpers.BlockNetsPrefix, helpers.BlockNetsPriorityStart+2),
+		fmt.Sprintf("%s%d", helpers.BlockNetsPrefix, helpers.BlockNetsPriorityStart+5),
+	}, changedRules(fresh, before))
+
+	// a further addition now only changes the rule covering its range
+	added = append(added, parseIPNets(t, "10.0.9.99/32")...)
+
+	after, _, err := GenStableCustomRulesFromIPNets(added, before, 10, "Block")
+	require.NoError(t, err)
+	require.Len(t, after, 6)
+	require.Len(t, changedRules(before, after), 1)
+
+	// removing the first address of a rule only changes that rule
+	removed := append(IPNets{}, ipns[:600]...)
+	removed = append(removed, ipns[601:]...)
+
+	after, _, err = GenStableCustomRulesFromIPNets(removed, fresh, 10, "Block")
+	require.NoError(t, err)
+	require.Len(t, after, 5)
+	require.Equal(t, []string{fmt.Sprintf("%s%d", helpers.BlockNetsPrefix, helpers.BlockNetsPriorityStart+1)}, changedRules(fresh, after))
+}
+
+func TestGenStableCustomRulesSplitsFullRule(t *testing.T) {
+	ipns := hostIPNets(1200)
+
+	before, _, err := GenCustomRulesFromIPNets(ipns, 10, "Block")
+	require.NoError(t, err)
+	require.Len(t, before, 2)
+
+	// adding to the first, full, rule should add a new rule rather than shift the second
+	added := append(IPNets{}, ipns...)
+	added = append(added, parseIPNets(t, "10.0.0.1/32")...)
+
+	after, _, err := GenStableCustomRulesFromIPNets(added, before, 10, "Block")
+	require.NoError(t, err)
+	require.Len(t, after, 3)
+	require.ElementsMatch(t, []string{
+		fmt.Sprintf("%s%d", helpers.BlockNetsPrefix, helpers.BlockNetsPriorityStart),
+		fmt.Sprintf("%s%d", helpers.BlockNetsPrefix, helpers.BlockNetsPriorityStart+2),
+	}, changedRules(before, after))
+
+	// when the split would exceed the maximum rules, the networks are repacked instead
+	after, dropped, err := GenStableCustomRulesFromIPNets(added, before, 2, "Block")
+	require.NoError(t, err)
+	require.Len(t, after, 2)
+	require.Len(t, dropped, 1)
+	require.Equal(t, "10.0.9.94/32", dropped[0].String())
+}
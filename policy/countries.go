@@ -0,0 +1,296 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/alexeyco/simpletable"
+	"github.com/gookit/color"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/jonhadfield/carbo/session"
+)
+
+// ApplyCountriesInput specifies the countries, as ISO 3166-1 alpha-2 codes, to block, allow or log in a policy.
+// The countries replace any already managed by carbo for the action.
+type ApplyCountriesInput struct {
+	RID       ResourceID
+	Action    string
+	Countries []string
+	Output    bool
+	DryRun    bool
+	// OutputFormat is the format the countries and rules changed are output in: table, json, yaml or csv
+	OutputFormat string
+}
+
+// CountryChangeSummary describes the countries added to, and removed from, an action's list, and the custom rules
+// that are created, changed or deleted to hold them
+type CountryChangeSummary struct {
+	PolicyID         string   `json:"policy_id"`
+	Action           string   `json:"action"`
+	DryRun           bool     `json:"dry_run"`
+	CountriesAdded   []string `json:"countries_added"`
+	CountriesRemoved []string `json:"countries_removed"`
+	RulesCreated     []string `json:"rules_created"`
+	RulesChanged     []string `json:"rules_changed"`
+	RulesDeleted     []string `json:"rules_deleted"`
+}
+
+// NormaliseCountryCodes returns the unique country codes in upper case and sorted, or an error if any are not
+// ISO 3166-1 alpha-2 codes
+func NormaliseCountryCodes(codes []string) (res []string, err error) {
+	seen := make(map[string]bool)
+
+	for _, code := range codes {
+		var c string
+
+		c, err = helpers.NormaliseCountryCode(code)
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[c] {
+			continue
+		}
+
+		seen[c] = true
+
+		res = append(res, c)
+	}
+
+	sort.Strings(res)
+
+	return
+}
+
+// createGeoMatchCustomRule will return a frontdoor CustomRule matching requests from the countries
+func createGeoMatchCustomRule(name, action string, priority int32, countries []string) frontdoor.CustomRule {
+	f := false
+
+	t := &[]frontdoor.TransformType{}
+
+	return frontdoor.CustomRule{
+		Name:         &name,
+		Priority:     &priority,
+		EnabledState: "Enabled",
+		RuleType:     "MatchRule",
+		MatchConditions: &[]frontdoor.MatchCondition{{
+			MatchVariable:   "RemoteAddr",
+			NegateCondition: &f,
+			Operator:        "GeoMatch",
+			MatchValue:      &countries,
+			Transforms:      t,
+		}},
+		Action: frontdoor.ActionType(action),
+	}
+}
+
+// countriesFromCustomRule returns the countries matched by a custom rule's non-negated GeoMatch conditions
+func countriesFromCustomRule(cr frontdoor.CustomRule) (countries []string) {
+	if cr.MatchConditions == nil {
+		return
+	}
+
+	for _, mc := range *cr.MatchConditions {
+		if mc.Operator != "GeoMatch" || mc.MatchValue == nil {
+			continue
+		}
+
+		if mc.NegateCondition != nil && *mc.NegateCondition {
+			continue
+		}
+
+		for _, mv := range *mc.MatchValue {
+			countries = append(countries, strings.ToUpper(mv))
+		}
+	}
+
+	return
+}
+
+// customRuleCountriesKey returns a value that differs between rules if their priority or countries differ
+func customRuleCountriesKey(cr frontdoor.CustomRule) string {
+	var priority int32
+	if cr.Priority != nil {
+		priority = *cr.Priority
+	}
+
+	countries := countriesFromCustomRule(cr)
+	sort.Strings(countries)
+
+	return fmt.Sprintf("%d|%s|%s", priority, cr.Action, strings.Join(countries, ","))
+}
+
+// GenCustomRulesFromCountries returns the custom rule matching the countries for the action, in the action's country
+// priority range
+func GenCustomRulesFromCountries(countries []string, action string) (crs []frontdoor.CustomRule, err error) {
+	var priorityStart int
+
+	var ruleNamePrefix string
+
+	switch action {
+	case "Block":
+		priorityStart = helpers.BlockCountriesPriorityStart
+		ruleNamePrefix = helpers.BlockCountriesPrefix
+	case "Allow":
+		priorityStart = helpers.AllowCountriesPriorityStart
+		ruleNamePrefix = helpers.AllowCountriesPrefix
+	case "Log":
+		priorityStart = helpers.LogCountriesPriorityStart
+		ruleNamePrefix = helpers.LogCountriesPrefix
+	default:
+		return nil, fmt.Errorf("invalid action: %s", action)
+	}
+
+	countries, err = NormaliseCountryCodes(countries)
+	if err != nil {
+		return
+	}
+
+	if len(countries) == 0 {
+		return
+	}
+
+	ruleName := fmt.Sprintf("%s%d", ruleNamePrefix, priorityStart)
+
+	return []frontdoor.CustomRule{createGeoMatchCustomRule(ruleName, action, int32(priorityStart), countries)}, nil
+}
+
+// SummariseCountryChanges compares the rules holding an action's countries before and after they are regenerated
+func SummariseCountryChanges(existing, generated []frontdoor.CustomRule) (summary CountryChangeSummary) {
+	summary.CountriesAdded, summary.CountriesRemoved = []string{}, []string{}
+	summary.RulesCreated, summary.RulesChanged, summary.RulesDeleted = diffCustomRules(existing, generated, customRuleCountriesKey)
+
+	before := make(map[string]bool)
+	for _, cr := range existing {
+		for _, c := range countriesFromCustomRule(cr) {
+			before[c] = true
+		}
+	}
+
+	after := make(map[string]bool)
+	for _, cr := range generated {
+		for _, c := range countriesFromCustomRule(cr) {
+			after[c] = true
+		}
+	}
+
+	for c := range after {
+		if !before[c] {
+			summary.CountriesAdded = append(summary.CountriesAdded, c)
+		}
+	}
+
+	for c := range before {
+		if !after[c] {
+			summary.CountriesRemoved = append(summary.CountriesRemoved, c)
+		}
+	}
+
+	sort.Strings(summary.CountriesAdded)
+	sort.Strings(summary.CountriesRemoved)
+
+	return
+}
+
+// ShowCountryChangeSummary outputs the summary in the requested format: table, json, yaml or csv
+func ShowCountryChangeSummary(summary CountryChangeSummary, format string) error {
+	return Render(summary, format)
+}
+
+// RenderTable outputs the number of countries added and removed, followed by tables of the countries and rules changed
+func (summary CountryChangeSummary) RenderTable() {
+	verb := "are"
+	if summary.DryRun {
+		verb = "would be"
+	}
+
+	fmt.Printf("%d countries %s added to, and %d removed from, %s list\n",
+		len(summary.CountriesAdded), verb, len(summary.CountriesRemoved), strings.ToLower(summary.Action))
+
+	if len(summary.CountriesAdded)+len(summary.CountriesRemoved) > 0 {
+		table := simpletable.New()
+		table.Header = &simpletable.Header{
+			Cells: []*simpletable.Cell{
+				{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Change")},
+				{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("%s Country", summary.Action)},
+			},
+		}
+
+		for _, c := range summary.CountriesAdded {
+			table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+				{Text: color.HiGreen.Sprint("added")},
+				{Text: c},
+			})
+		}
+
+		for _, c := range summary.CountriesRemoved {
+			table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+				{Text: color.HiRed.Sprint("removed")},
+				{Text: c},
+			})
+		}
+
+		table.SetStyle(simpletable.StyleRounded)
+
+		table.Println()
+	}
+
+	showRuleChanges(summary.RulesCreated, summary.RulesChanged, summary.RulesDeleted)
+}
+
+// CSVRecords returns a row per country and rule changed with columns type, change and name, where type is either
+// country or rule
+func (summary CountryChangeSummary) CSVRecords() [][]string {
+	records := [][]string{{"type", "change", "name"}}
+
+	for _, c := range summary.CountriesAdded {
+		records = append(records, []string{"country", "added", c})
+	}
+
+	for _, c := range summary.CountriesRemoved {
+		records = append(records, []string{"country", "removed", c})
+	}
+
+	return append(records, ruleChangeRecords(summary.RulesCreated, summary.RulesChanged, summary.RulesDeleted)...)
+}
+
+// ApplyCountryChanges replaces the countries blocked, allowed or logged by a policy's custom rules for the action.
+// If no countries are provided, the policy's rules for the action are removed.
+func ApplyCountryChanges(input ApplyCountriesInput) error {
+	s := session.Session{}
+
+	return applyCountryChanges(&s, input)
+}
+
+func applyCountryChanges(s *session.Session, input ApplyCountriesInput) (err error) {
+	prefix, err := helpers.CountriesPrefixFromAction(input.Action)
+	if err != nil {
+		return
+	}
+
+	// without countries, the existing rules for the action are removed
+	crs, err := GenCustomRulesFromCountries(input.Countries, input.Action)
+	if err != nil {
+		return
+	}
+
+	return applyManagedRules(s, managedRulesInput{
+		RID:    input.RID,
+		Prefix: prefix,
+		Output: input.Output,
+		DryRun: input.DryRun,
+		generate: func([]frontdoor.CustomRule) ([]frontdoor.CustomRule, error) {
+			return crs, nil
+		},
+		show: func(existing, generated []frontdoor.CustomRule) error {
+			summary := SummariseCountryChanges(existing, generated)
+			summary.PolicyID = input.RID.Raw
+			summary.Action = input.Action
+			summary.DryRun = input.DryRun
+
+			return ShowCountryChangeSummary(summary, input.OutputFormat)
+		},
+	})
+}
@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormaliseCountryCodes(t *testing.T) {
+	codes, err := NormaliseCountryCodes([]string{"ru", "CN", " RU"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"CN", "RU"}, codes)
+
+	_, err = NormaliseCountryCodes([]string{"CN", "XX"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "XX")
+}
+
+func TestGenCustomRulesFromCountries(t *testing.T) {
+	crs, err := GenCustomRulesFromCountries([]string{"ru", "cn"}, "Allow")
+	require.NoError(t, err)
+	require.Len(t, crs, 1)
+	require.Equal(t, "AllowCountries3500", *crs[0].Name)
+	require.Equal(t, int32(helpers.AllowCountriesPriorityStart), *crs[0].Priority)
+	require.Equal(t, "GeoMatch", string((*crs[0].MatchConditions)[0].Operator))
+	require.Equal(t, []string{"CN", "RU"}, countriesFromCustomRule(crs[0]))
+
+	_, err = GenCustomRulesFromCountries([]string{"CN"}, "Deny")
+	require.Error(t, err)
+}
+
+func TestSummariseCountryChanges(t *testing.T) {
+	existing, err := GenCustomRulesFromCountries([]string{"CN", "RU"}, "Block")
+	require.NoError(t, err)
+
+	generated, err := GenCustomRulesFromCountries([]string{"CN", "KP"}, "Block")
+	require.NoError(t, err)
+
+	summary := SummariseCountryChanges(existing, generated)
+	require.Equal(t, []string{"KP"}, summary.CountriesAdded)
+	require.Equal(t, []string{"RU"}, summary.CountriesRemoved)
+	require.Equal(t, []string{"BlockCountries5500"}, summary.RulesChanged)
+	require.Empty(t, summary.RulesCreated)
+	require.Empty(t, summary.RulesDeleted)
+
+	summary = SummariseCountryChanges(nil, generated)
+	require.Equal(t, []string{"CN", "KP"}, summary.CountriesAdded)
+	require.Equal(t, []string{"BlockCountries5500"}, summary.RulesCreated)
+
+	// without countries no rules are generated, so the existing rules are removed
+	none, err := GenCustomRulesFromCountries(nil, "Block")
+	require.NoError(t, err)
+	require.Empty(t, none)
+
+	merged, err := mergeCustomRules(existing, none, helpers.BlockCountriesPrefix)
+	require.NoError(t, err)
+	require.Empty(t, merged)
+
+	summary = SummariseCountryChanges(existing, none)
+	require.Equal(t, []string{"CN", "RU"}, summary.CountriesRemoved)
+	require.Equal(t, []string{"BlockCountries5500"}, summary.RulesDeleted)
+}
+
+func TestLoadActionsFromPathWithCountries(t *testing.T) {
+	as, err := LoadActionsFromPath("testfiles/actions-countries.yaml")
+	require.NoError(t, err)
+	require.Len(t, as, 1)
+	require.Equal(t, "block", as[0].CountryAction)
+	require.Equal(t, []string{"CN", "RU"}, as[0].Countries)
+
+	_, err = LoadActionsFromPath("testfiles/actions-countries-invalid.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid country code: UK")
+}
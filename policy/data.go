@@ -12,11 +12,11 @@ import (
 	"io/fs"
 	"io/ioutil"
 	"log"
-	"net"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -93,11 +93,20 @@ type ResourceID struct {
 }
 
 type Action struct {
-	ActionType string `yaml:"action"`
-	Policy     string
-	Paths      []string `yaml:"paths"`
-	MaxRules   int      `yaml:"max-rules"`
-	Nets       IPNets
+	ActionType      string `yaml:"action"`
+	Policy          string
+	Paths           []IPListPath `yaml:"paths"`
+	MaxRules        int          `yaml:"max-rules"`
+	AllowTruncation bool         `yaml:"allow-truncation"`
+	DroppedPath     string       `yaml:"dropped-path"`
+	Shards          []string     `yaml:"shards"`
+	Countries       []string     `yaml:"countries"`
+	CountryAction   string       `yaml:"country-action"`
+	RateLimits      []RateLimit  `yaml:"rate-limits"`
+	Scope           RuleScope    `yaml:"scope"`
+	Nets            IPNets
+	Sources         IPNetSources  `yaml:"-"`
+	Expiries        IPNetExpiries `yaml:"-"`
 }
 
 func LoadActionsFromPath(f string) (actions []Action, err error) {
@@ -115,8 +124,39 @@ func LoadActionsFromPath(f string) (actions []Action, err error) {
 	// get ipns
 	for _, ra := range rawActions {
 		a := ra
+		a.Sources = make(IPNetSources)
+		a.Expiries = make(IPNetExpiries)
+
+		// validate countries before any action is run
+		if strings.EqualFold(a.ActionType, "countries") {
+			if a.Countries, err = NormaliseCountryCodes(a.Countries); err != nil {
+				return
+			}
+
+			if a.CountryAction != "" && !helpers.StringInSlice(a.CountryAction, []string{"block", "allow", "log"}, true) {
+				return nil, fmt.Errorf("country action '%s' is not supported", a.CountryAction)
+			}
+		}
+
+		// validate the scope of networks before any action is run
+		if a.Scope, err = NormaliseRuleScope(a.Scope); err != nil {
+			return
+		}
+
+		// validate rate limits before any action is run
+		if strings.EqualFold(a.ActionType, "rate-limits") {
+			for x := range a.RateLimits {
+				if a.RateLimits[x], err = NormaliseRateLimit(a.RateLimits[x]); err != nil {
+					return
+				}
+			}
+		}
+
+		var excluded IPNets
+
+		for _, lp := range ra.Paths {
+			p := lp.Path
 
-		for _, p := range ra.Paths {
 			usr, _ := user.Current()
 
 			dir := usr.HomeDir
@@ -130,14 +170,43 @@ func LoadActionsFromPath(f string) (actions []Action, err error) {
 				p = filepath.Join(dir, p[2:])
 			}
 
-			var ipns []net.IPNet
+			var ipns IPNets
+
+			var sources IPNetSources
+
+			lp.Path = p
 
-			ipns, err = LoadIPsFromPath(p)
+			var expiries IPNetExpiries
+
+			ipns, sources, expiries, err = LoadExpiringIPsFromListPath(lp)
 			if err != nil {
 				return
 			}
 
+			if lp.Exclude {
+				excluded = append(excluded, ipns...)
+
+				continue
+			}
+
 			a.Nets = append(a.Nets, ipns...)
+
+			for k, v := range sources {
+				a.Sources[k] = append(a.Sources[k], v...)
+			}
+
+			for k, v := range expiries {
+				a.Expiries[k] = v
+			}
+		}
+
+		if len(excluded) > 0 {
+			var stripped []StrippedIPNet
+
+			a.Nets, stripped = ExcludeProtectedIPNets(a.Nets, excluded)
+			retainStrippedSources(a.Sources, stripped, a.Nets)
+
+			logrus.Debugf("excluded %d networks from %s action\n", len(stripped), a.ActionType)
 		}
 
 		actions = append(actions, a)
@@ -241,125 +310,235 @@ func LoadWrappedPolicyFromFile(f string) (wp WrappedPolicy, err error) {
 
 // applyIPChanges updates an existing custom policy with IPs matching the requested action
 func applyIPChanges(s *session.Session, input ApplyIPsInput) (err error) {
-	prefix, err := helpers.PrefixFromAction(input.Action)
+	if _, err = helpers.PrefixFromAction(input.Action); err != nil {
+		return
+	}
+
+	if _, err = NormaliseRuleScope(input.Scope); err != nil {
+		return
+	}
+
+	if len(input.Shards) > 0 {
+		return applyShardedIPChanges(s, input)
+	}
+
+	loadedNets, err := prepareIPNets(&input)
 	if err != nil {
 		return
 	}
 
+	return applyPolicyIPChanges(s, input, loadedNets)
+}
+
+// prepareIPNets loads the networks from the input's file, removes those that have expired or are protected, and
+// then aggregates them. The networks as loaded, before aggregation, are returned so that overlaps can be attributed
+// to their sources.
+func prepareIPNets(input *ApplyIPsInput) (loadedNets IPNets, err error) {
 	lowercaseAction := strings.ToLower(input.Action)
 
+	if input.Sources == nil {
+		input.Sources = make(IPNetSources)
+	}
+
+	if input.Expiries == nil {
+		input.Expiries = make(IPNetExpiries)
+	}
+
 	if input.Filepath != "" {
 		var fipns IPNets
 
-		fipns, err = LoadIPsFromPath(input.Filepath)
+		var sources IPNetSources
+
+		var expiries IPNetExpiries
+
+		fipns, sources, expiries, err = LoadExpiringIPsFromListPath(IPListPath{
+			Path:     input.Filepath,
+			Format:   input.FileFormat,
+			Column:   input.FileColumn,
+			JSONPath: input.FileJSONPath,
+		})
 		if err != nil {
 			return
 		}
 
 		input.Nets = append(input.Nets, fipns...)
+
+		for k, v := range sources {
+			input.Sources[k] = append(input.Sources[k], v...)
+		}
+
+		// the requested expiry applies to every network in the file without its own
+		if input.Expires != "" {
+			var expiry time.Time
+
+			expiry, err = ParseExpiry(input.Expires, expiryNow())
+			if err != nil {
+				return
+			}
+
+			for _, ipn := range fipns {
+				if _, ok := expiries.Get(ipn); !ok {
+					input.Expiries.Set(IPNets{ipn}, expiry)
+				}
+			}
+		}
+
+		for k, v := range expiries {
+			input.Expiries[k] = v
+		}
 	}
 
 	if len(input.Nets) == 0 {
-		return fmt.Errorf("no IPs loaded")
+		return nil, fmt.Errorf("no IPs loaded")
 	}
 
-	var p frontdoor.WebApplicationFirewallPolicy
+	// drop networks whose expiry has passed
+	if err = applyIPNetExpiries(input); err != nil {
+		return
+	}
 
-	subscription := input.RID.SubscriptionID
-	resourceGroup := input.RID.ResourceGroup
-	name := input.RID.Name
+	if len(input.Nets) == 0 {
+		return nil, fmt.Errorf("no IPs remain after removing expired networks")
+	}
 
-	// check if Policy exists
-	p, err = GetRawPolicy(s, subscription, resourceGroup, name)
-	if err != nil {
-		return err
+	// ensure protected networks are never blocked or logged
+	if err = applyProtectedIPNets(input); err != nil {
+		return
 	}
 
-	if p.Name == nil {
-		return fmt.Errorf("specified Policy not found")
+	if len(input.Nets) == 0 {
+		return nil, fmt.Errorf("no IPs remain after removing protected networks")
 	}
 
-	// take a copy of the Policy for later comparison
-	origPolicyJSON, err := json.MarshalIndent(p, "", "    ")
+	// keep the networks as loaded so that overlaps can be attributed to their sources
+	loadedNets = input.Nets
+
+	// reduce the networks to the fewest entries before they consume match values
+	var removed int
+
+	input.Nets, removed = AggregateIPNets(input.Nets)
+	if removed > 0 {
+		log.Printf("aggregation removed %d networks from %s list, leaving %d\n", removed, lowercaseAction, len(input.Nets))
+	}
+
+	return
+}
+
+// applyPolicyIPChanges replaces the rules for the action in the input's policy with rules holding its prepared networks
+func applyPolicyIPChanges(s *session.Session, input ApplyIPsInput, loadedNets IPNets) (err error) {
+	prefix, err := helpers.PrefixFromAction(input.Action)
 	if err != nil {
 		return
 	}
 
-	crs, err := GenCustomRulesFromIPNets(input.Nets, input.MaxRules, input.Action)
+	lowercaseAction := strings.ToLower(input.Action)
+
+	scope, err := NormaliseRuleScope(input.Scope)
 	if err != nil {
 		return
 	}
 
-	// remove existing net rules from Policy before adding New
-	var ecrs []frontdoor.CustomRule
+	if desc := scope.String(); desc != "" {
+		log.Printf("%s list scoped to %s\n", lowercaseAction, desc)
+	}
+
+	return applyManagedRules(s, managedRulesInput{
+		RID:    input.RID,
+		Prefix: prefix,
+		Output: input.Output,
+		DryRun: input.DryRun,
+		check: func(p frontdoor.WebApplicationFirewallPolicy) error {
+			// redirect rules send requests to the url in the policy's settings so cannot be applied without one
+			if input.Action == "Redirect" && (p.PolicySettings == nil || stringValue(p.PolicySettings.RedirectURL) == "") {
+				return fmt.Errorf("policy %s has no redirect url for the redirect list to use", *p.Name)
+			}
+
+			// check the networks do not overlap those of other actions in the policy
+			return checkPolicyIPNetOverlaps(p, IPNetList{
+				Action:  input.Action,
+				Nets:    loadedNets,
+				Sources: input.Sources,
+				Scope:   scope,
+			}, prefix, input.FailOnOverlap)
+		},
+		generate: func(existing []frontdoor.CustomRule) ([]frontdoor.CustomRule, error) {
+			// the existing rules are passed so that their ranges are retained
+			crs, dropped, err := GenScopedCustomRulesFromIPNets(input.Nets, existing, input.MaxRules, input.Action, scope)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(dropped) > 0 {
+				if err = reportDroppedIPNets(dropped, lowercaseAction, input.DroppedPath); err != nil {
+					return nil, err
+				}
+
+				if !input.AllowTruncation {
+					return nil, fmt.Errorf("%d networks exceed the %s list limit of %d rules and truncation is not allowed", len(dropped), lowercaseAction, input.MaxRules)
+				}
+			}
+
+			return crs, nil
+		},
+		show: func(existing, generated []frontdoor.CustomRule) error {
+			summary := SummariseIPChanges(existing, generated)
+			summary.PolicyID = input.RID.Raw
+			summary.Action = input.Action
+			summary.DryRun = input.DryRun
+
+			return ShowIPChangeSummary(summary, input.OutputFormat)
+		},
+		applied: func() error {
+			// expiries are only recorded once the networks they apply to are
+			return saveIPNetExpiries(input)
+		},
+	})
+}
+
+// checkPolicyIPNetOverlaps reports any overlaps between the list being applied and the networks of other actions
+// in the policy's custom rules, excluding those with the prefix that are to be replaced
+func checkPolicyIPNetOverlaps(p frontdoor.WebApplicationFirewallPolicy, list IPNetList, prefix string, failOnOverlap bool) error {
+	var overlaps []IPNetOverlap
 
-	for _, existingCustomRule := range *p.CustomRules.Rules {
-		// if New custom rule name doesn't have the prefix in the Action, then add it
-		// this means all the ones not matching the action go at the beginning #### WRONG BEHAVIOUR - MUST BE IN PRIORITY ORDER
-		if !strings.HasPrefix(*existingCustomRule.Name, prefix) {
-			ecrs = append(ecrs, existingCustomRule)
+	for _, o := range FindIPNetOverlaps(append(IPNetListsFromPolicy(p, prefix), list)) {
+		if o.Action == list.Action || o.OtherAction == list.Action {
+			overlaps = append(overlaps, o)
 		}
 	}
 
-	// New rule list
-	// for existing-rule in existing-rules
-	//		for New rule in New rules
-	//			  if existing-rule.priority < New-rule.priority:
-	//					New-rule-list = append(New-rule-list
-
-	// add the New custom rules to the existing
-	*p.CustomRules.Rules = append(ecrs, crs...)
-	// check we don't exceed Azure rules limit
-	if len(*p.CustomRules.Rules) > helpers.MaxCustomRules {
-		return fmt.Errorf("operation exceededs custom rules limit of %d", helpers.MaxCustomRules)
-	}
-	//
-	// // check we don't exceed the user specified max rules limit
-	// if len(*p.CustomRules.Rules) > input.MaxRules {
-	//	return fmt.Errorf("operation exceededs custom rules limit of %d", MaxCustomRules)
-	// }
-
-	// SortRules(*p.CustomRules.Rules)
-	// check if rules differ from Original
-	// num, patch, err := GeneratePolicyPatch(origPolicyJSON, p)
-	gppO, err := GeneratePolicyPatch(GeneratePolicyPatchInput{Original: origPolicyJSON, New: p})
-	if err != nil {
-		return err
+	if len(overlaps) == 0 {
+		return nil
 	}
 
-	if gppO.CustomRuleChanges == 0 {
-		log.Println("nothing to do")
+	ReportIPNetOverlaps(overlaps)
 
-		return nil
+	if failOnOverlap {
+		return fmt.Errorf("%d overlaps found between %s list and existing rules", len(overlaps), strings.ToLower(list.Action))
 	}
 
-	if input.DryRun {
-		log.Printf("%d changes to %s list would be applied\n", gppO.CustomRuleChanges, lowercaseAction)
+	return nil
+}
 
-		return nil
-	}
+// reportDroppedIPNets outputs the networks that could not be placed in custom rules and, if a path is provided,
+// writes them to a file that can be loaded as an IP list
+func reportDroppedIPNets(dropped IPNets, action, path string) error {
+	log.Printf("%d networks could not be added to %s list as maximum rules reached\n", len(dropped), action)
+
+	if path != "" {
+		if err := WriteIPsToFile(path, dropped); err != nil {
+			return err
+		}
 
-	if input.Output {
-		o, _ := json.MarshalIndent(p, "", "    ")
-		fmt.Println(string(o))
+		log.Printf("dropped networks written to: %s\n", path)
 
 		return nil
 	}
 
-	log.Printf("updating Policy %s\n", *p.Name)
-
-	err = PushPolicy(s, PushPolicyInput{
-		Name:          *p.Name,
-		Subscription:  input.RID.SubscriptionID,
-		ResourceGroup: input.RID.ResourceGroup,
-		Policy:        p,
-	})
-
-	if err == nil {
-		fmt.Printf("%d changes to %s list have been applied\n", gppO.CustomRuleChanges, lowercaseAction)
+	for _, d := range dropped {
+		log.Printf("dropped: %s\n", d.String())
 	}
 
-	return err
+	return nil
 }
 
 // ApplyIPChanges accepts user input specifying IPs, or filepath containing IPs, and then adds them to custom rules
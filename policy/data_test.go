@@ -25,22 +25,23 @@ func TestLoadValidActionsFromPath(t *testing.T) {
 			require.Equal(t, "log", as[x].ActionType)
 			require.Equal(t, 2, as[x].MaxRules)
 			require.Equal(t, "/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorWebApplicationFirewallPolicies/lemon", as[x].Policy)
-			require.Equal(t, "testfiles/ipsets/block-list-one.ipset", as[x].Paths[0])
-			require.Equal(t, "testfiles/ipsets/block-list-two.ipset", as[x].Paths[1])
+			require.Equal(t, "testfiles/ipsets/block-list-one.ipset", as[x].Paths[0].Path)
+			require.Equal(t, "testfiles/ipsets/block-list-two.ipset", as[x].Paths[1].Path)
 			require.Len(t, as[x].Nets, 1870)
+			require.Equal(t, []string{"testfiles/ipsets/block-list-one.ipset"}, as[x].Sources.Get(as[x].Nets[0]))
 		case 1:
 			require.Equal(t, "block", as[x].ActionType)
 			require.Equal(t, 3, as[x].MaxRules)
 			require.Equal(t, "/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorWebApplicationFirewallPolicies/apple", as[x].Policy)
-			require.Equal(t, "testfiles/ipsets/sslproxies_7d.ipset", as[x].Paths[0])
+			require.Equal(t, "testfiles/ipsets/sslproxies_7d.ipset", as[x].Paths[0].Path)
 			// 2446 but the last IP is duplicated, so 2445 should be loaded after deduplication
 			require.Len(t, as[x].Nets, 2445)
 		case 2:
 			require.Equal(t, "allow", as[x].ActionType)
 			require.Equal(t, 4, as[x].MaxRules)
 			require.Equal(t, "/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorWebApplicationFirewallPolicies/banana", as[x].Policy)
-			require.Equal(t, "testfiles/ipsets/allow-list-one.ipset", as[x].Paths[0])
-			require.Equal(t, "testfiles/ipsets/block-list-two.ipset", as[x].Paths[1])
+			require.Equal(t, "testfiles/ipsets/allow-list-one.ipset", as[x].Paths[0].Path)
+			require.Equal(t, "testfiles/ipsets/block-list-two.ipset", as[x].Paths[1].Path)
 			require.Len(t, as[x].Nets, 1900)
 		}
 	}
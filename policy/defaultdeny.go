@@ -0,0 +1,304 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/jonhadfield/carbo/session"
+	"github.com/pkg/errors"
+)
+
+// EgressIPURLEnv is the environment variable that overrides the url used to look up the current egress ip
+const EgressIPURLEnv = "CARBO_EGRESS_IP_URL"
+
+// defaultEgressIPURL returns the address of the requesting client as plain text
+const defaultEgressIPURL = "https://api.ipify.org"
+
+// defaultDenyExempt is the name given to the networks a default deny rule does not block when showing changes
+const defaultDenyExempt = "Exempt"
+
+// DefaultDenyInput specifies the networks that are exempt from a policy's default deny rule, or that the rule is to
+// be removed
+type DefaultDenyInput struct {
+	RID ResourceID
+	// Filepath is a file or directory of networks to exempt, in addition to Nets
+	Filepath string
+	Nets     IPNets
+	// Remove deletes the default deny rule instead
+	Remove bool
+	// MatchVariable is the address the networks are matched against, either RemoteAddr or SocketAddr
+	MatchVariable string
+	// ProtectedPath is a list of networks that must be exempt to pass the lockout check
+	ProtectedPath string
+	// EgressIP is the address that must be exempt to pass the lockout check if there are no protected networks.
+	// It is looked up if not provided.
+	EgressIP         string
+	SkipLockoutCheck bool
+	Output           bool
+	DryRun           bool
+	// OutputFormat is the format the networks and rules changed are output in: table, json, yaml or csv
+	OutputFormat string
+}
+
+// GenDefaultDenyCustomRule returns a rule blocking requests from every address not within the allowed networks. The
+// networks are split across negated IPMatch conditions, which are ANDed, so a request is only blocked if it is not
+// within any of them.
+func GenDefaultDenyCustomRule(allowed IPNets, matchVariable string) (cr frontdoor.CustomRule, err error) {
+	scope, err := NormaliseRuleScope(RuleScope{MatchVariable: matchVariable})
+	if err != nil {
+		return
+	}
+
+	for _, ipn := range allowed {
+		if helpers.IsMatchAllNet(ipn.String()) {
+			return cr, fmt.Errorf("allowed network %s matches every address so nothing would be denied", ipn.String())
+		}
+	}
+
+	nets, _ := AggregateIPNets(allowed)
+	sortIPNets(nets)
+
+	if len(nets) == 0 {
+		return cr, fmt.Errorf("no networks to allow")
+	}
+
+	limit := helpers.MaxMatchConditions * helpers.MaxIPMatchValues
+	if len(nets) > limit {
+		return cr, fmt.Errorf("%d networks exceed the default deny limit of %d", len(nets), limit)
+	}
+
+	t := true
+
+	var mcs []frontdoor.MatchCondition
+
+	for _, c := range chunkIPNets(nets, 0) {
+		values := c.nets.toString()
+
+		mcs = append(mcs, frontdoor.MatchCondition{
+			MatchVariable:   frontdoor.MatchVariable(scope.MatchVariable),
+			NegateCondition: &t,
+			Operator:        "IPMatch",
+			MatchValue:      &values,
+			Transforms:      &[]frontdoor.TransformType{},
+		})
+	}
+
+	name := helpers.DefaultDenyPrefix
+	priority := int32(helpers.DefaultDenyPriority)
+
+	return frontdoor.CustomRule{
+		Name:            &name,
+		Priority:        &priority,
+		EnabledState:    "Enabled",
+		RuleType:        "MatchRule",
+		MatchConditions: &mcs,
+		Action:          "Block",
+	}, nil
+}
+
+// allowedIPNetsFromCustomRule returns the networks excluded by a rule's negated IPMatch conditions
+func allowedIPNetsFromCustomRule(cr frontdoor.CustomRule) (ipns IPNets) {
+	if cr.MatchConditions == nil {
+		return
+	}
+
+	for _, mc := range *cr.MatchConditions {
+		if mc.Operator != "IPMatch" || mc.MatchValue == nil || mc.NegateCondition == nil || !*mc.NegateCondition {
+			continue
+		}
+
+		for _, mv := range *mc.MatchValue {
+			ipn, err := ParseIPNet(mv)
+			if err != nil {
+				continue
+			}
+
+			ipns = append(ipns, normaliseIPNet(ipn))
+		}
+	}
+
+	return
+}
+
+// SummariseDefaultDenyChanges compares the default deny rule before and after it is regenerated, with the networks
+// added and removed being those exempt from it
+func SummariseDefaultDenyChanges(existing, generated []frontdoor.CustomRule) (summary IPChangeSummary) {
+	summary.NetworksAdded, summary.NetworksRemoved = []string{}, []string{}
+	summary.RulesCreated, summary.RulesChanged, summary.RulesDeleted = diffCustomRules(existing, generated, func(cr frontdoor.CustomRule) string {
+		return fmt.Sprintf("%s|%s", allowedIPNetsFromCustomRule(cr).toString(), scopeFromCustomRule(cr).MatchVariable)
+	})
+
+	var before, after IPNets
+
+	for _, cr := range existing {
+		before = append(before, allowedIPNetsFromCustomRule(cr)...)
+	}
+
+	for _, cr := range generated {
+		after = append(after, allowedIPNetsFromCustomRule(cr)...)
+	}
+
+	for _, c := range diffIPNets(before, after) {
+		if c.Added {
+			summary.NetworksAdded = append(summary.NetworksAdded, c.Net)
+
+			continue
+		}
+
+		summary.NetworksRemoved = append(summary.NetworksRemoved, c.Net)
+	}
+
+	return
+}
+
+// LookupEgressIP returns the public address requests from this host are made from
+func LookupEgressIP() (ip net.IP, err error) {
+	u := os.Getenv(EgressIPURLEnv)
+	if u == "" {
+		u = defaultEgressIPURL
+	}
+
+	resp, err := feedClient.Get(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to look up egress ip")
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to look up egress ip: %s returned %s", u, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to look up egress ip")
+	}
+
+	if ip = net.ParseIP(strings.TrimSpace(string(b))); ip == nil {
+		return nil, fmt.Errorf("failed to look up egress ip: invalid response from %s", u)
+	}
+
+	return ip, nil
+}
+
+// CheckDefaultDenyLockout returns an error if the default deny rule would block any of the protected networks or, if
+// there are none, the egress ip
+func CheckDefaultDenyLockout(allowed, protected IPNets, egressIP net.IP) error {
+	if len(protected) > 0 {
+		blocked, _ := ExcludeProtectedIPNets(protected, allowed)
+		if len(blocked) > 0 {
+			return fmt.Errorf("default deny would block protected networks: %s", strings.Join(blocked.toString(), ", "))
+		}
+
+		return nil
+	}
+
+	if egressIP == nil {
+		return fmt.Errorf("no egress ip or protected networks to check default deny against")
+	}
+
+	egress, err := ParseIPNet(egressIP.String())
+	if err != nil {
+		return err
+	}
+
+	for _, ipn := range allowed {
+		if ipNetContains(normaliseIPNet(ipn), egress) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("default deny would block the current egress ip %s", egressIP.String())
+}
+
+// checkDefaultDenyLockout checks the default deny would not block the protected networks or operator's egress ip
+func checkDefaultDenyLockout(allowed IPNets, input DefaultDenyInput) (err error) {
+	if input.SkipLockoutCheck {
+		log.Println("skipping default deny lockout check")
+
+		return nil
+	}
+
+	var protected IPNets
+
+	if input.ProtectedPath != "" {
+		if protected, err = LoadIPsFromPath(input.ProtectedPath); err != nil {
+			return
+		}
+	}
+
+	var egressIP net.IP
+
+	if len(protected) == 0 {
+		if input.EgressIP != "" {
+			if egressIP = net.ParseIP(strings.TrimSpace(input.EgressIP)); egressIP == nil {
+				return fmt.Errorf("invalid egress ip: %s", input.EgressIP)
+			}
+		} else if egressIP, err = LookupEgressIP(); err != nil {
+			return errors.Wrap(err, "provide an egress ip or protected networks, or skip the lockout check")
+		}
+	}
+
+	return CheckDefaultDenyLockout(allowed, protected, egressIP)
+}
+
+// ApplyDefaultDeny adds or updates a policy's default deny rule, blocking every address not in its list, or removes it
+func ApplyDefaultDeny(input DefaultDenyInput) error {
+	s := session.Session{}
+
+	return applyDefaultDeny(&s, input)
+}
+
+func applyDefaultDeny(s *session.Session, input DefaultDenyInput) (err error) {
+	var crs []frontdoor.CustomRule
+
+	if !input.Remove {
+		allowed := input.Nets
+
+		if input.Filepath != "" {
+			var fipns IPNets
+
+			if fipns, err = LoadIPsFromPath(input.Filepath); err != nil {
+				return
+			}
+
+			allowed = append(allowed, fipns...)
+		}
+
+		var cr frontdoor.CustomRule
+
+		if cr, err = GenDefaultDenyCustomRule(allowed, input.MatchVariable); err != nil {
+			return
+		}
+
+		if err = checkDefaultDenyLockout(allowed, input); err != nil {
+			return
+		}
+
+		crs = []frontdoor.CustomRule{cr}
+	}
+
+	return applyManagedRules(s, managedRulesInput{
+		RID:    input.RID,
+		Prefix: helpers.DefaultDenyPrefix,
+		Output: input.Output,
+		DryRun: input.DryRun,
+		generate: func([]frontdoor.CustomRule) ([]frontdoor.CustomRule, error) {
+			return crs, nil
+		},
+		show: func(existing, generated []frontdoor.CustomRule) error {
+			summary := SummariseDefaultDenyChanges(existing, generated)
+			summary.PolicyID = input.RID.Raw
+			summary.Action = defaultDenyExempt
+			summary.DryRun = input.DryRun
+
+			return ShowIPChangeSummary(summary, input.OutputFormat)
+		},
+	})
+}
@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenDefaultDenyCustomRule(t *testing.T) {
+	cr, err := GenDefaultDenyCustomRule(parseIPNets(t, "192.0.2.0/25", "192.0.2.128/25", "2001:db8::/32"), "")
+	require.NoError(t, err)
+	require.Equal(t, helpers.DefaultDenyPrefix, *cr.Name)
+	require.Equal(t, int32(helpers.DefaultDenyPriority), *cr.Priority)
+	require.Len(t, *cr.MatchConditions, 1)
+
+	mc := (*cr.MatchConditions)[0]
+	require.Equal(t, "RemoteAddr", string(mc.MatchVariable))
+	require.True(t, *mc.NegateCondition)
+	require.Equal(t, []string{"192.0.2.0/24", "2001:db8::/32"}, *mc.MatchValue)
+
+	dd, err := helpers.CustomRuleHasDefaultDeny(cr)
+	require.NoError(t, err)
+	require.True(t, dd)
+
+	// networks beyond a condition's limit are split across further conditions
+	cr, err = GenDefaultDenyCustomRule(hostIPNets(helpers.MaxIPMatchValues+1), "socketaddr")
+	require.NoError(t, err)
+	require.Len(t, *cr.MatchConditions, 2)
+	require.Equal(t, "SocketAddr", string((*cr.MatchConditions)[1].MatchVariable))
+	require.Len(t, allowedIPNetsFromCustomRule(cr), helpers.MaxIPMatchValues+1)
+
+	_, err = GenDefaultDenyCustomRule(hostIPNets(helpers.MaxIPMatchValues*helpers.MaxMatchConditions+1), "")
+	require.Error(t, err)
+
+	_, err = GenDefaultDenyCustomRule(nil, "")
+	require.Error(t, err)
+
+	_, err = GenDefaultDenyCustomRule(parseIPNets(t, "192.0.2.0/24", "::/0"), "")
+	require.Error(t, err)
+
+	_, err = GenDefaultDenyCustomRule(parseIPNets(t, "0.0.0.0/0"), "")
+	require.Error(t, err)
+
+	_, err = GenDefaultDenyCustomRule(parseIPNets(t, "192.0.2.0/24"), "RequestUri")
+	require.Error(t, err)
+}
+
+func TestCheckDefaultDenyLockout(t *testing.T) {
+	allowed := parseIPNets(t, "192.0.2.0/24", "2001:db8::/32")
+
+	require.NoError(t, CheckDefaultDenyLockout(allowed, parseIPNets(t, "192.0.2.0/28", "2001:db8:1::/48"), nil))
+	require.Error(t, CheckDefaultDenyLockout(allowed, parseIPNets(t, "192.0.2.0/23"), nil))
+	require.Error(t, CheckDefaultDenyLockout(allowed, parseIPNets(t, "198.51.100.1/32"), nil))
+
+	require.NoError(t, CheckDefaultDenyLockout(allowed, nil, net.ParseIP("192.0.2.10")))
+	require.NoError(t, CheckDefaultDenyLockout(allowed, nil, net.ParseIP("2001:db8::1")))
+	require.Error(t, CheckDefaultDenyLockout(allowed, nil, net.ParseIP("198.51.100.1")))
+	require.Error(t, CheckDefaultDenyLockout(allowed, nil, nil))
+}
+
+func TestLookupEgressIP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("192.0.2.10\n"))
+	}))
+	defer ts.Close()
+
+	t.Setenv(EgressIPURLEnv, ts.URL)
+
+	ip, err := LookupEgressIP()
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.10", ip.String())
+
+	require.NoError(t, checkDefaultDenyLockout(parseIPNets(t, "192.0.2.0/24"), DefaultDenyInput{}))
+	require.Error(t, checkDefaultDenyLockout(parseIPNets(t, "198.51.100.0/24"), DefaultDenyInput{}))
+	require.NoError(t, checkDefaultDenyLockout(parseIPNets(t, "198.51.100.0/24"), DefaultDenyInput{EgressIP: "198.51.100.1"}))
+	require.NoError(t, checkDefaultDenyLockout(parseIPNets(t, "198.51.100.0/24"), DefaultDenyInput{SkipLockoutCheck: true}))
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html></html>"))
+	}))
+	defer bad.Close()
+
+	t.Setenv(EgressIPURLEnv, bad.URL)
+
+	_, err = LookupEgressIP()
+	require.Error(t, err)
+}
+
+func TestSummariseDefaultDenyChanges(t *testing.T) {
+	existing, err := GenDefaultDenyCustomRule(parseIPNets(t, "192.0.2.0/24", "198.51.100.0/24"), "")
+	require.NoError(t, err)
+
+	generated, err := GenDefaultDenyCustomRule(parseIPNets(t, "192.0.2.0/24", "203.0.113.0/24"), "")
+	require.NoError(t, err)
+
+	summary := SummariseDefaultDenyChanges([]frontdoor.CustomRule{existing}, []frontdoor.CustomRule{generated})
+	require.Equal(t, []string{"203.0.113.0/24"}, summary.NetworksAdded)
+	require.Equal(t, []string{"198.51.100.0/24"}, summary.NetworksRemoved)
+	require.Equal(t, []string{helpers.DefaultDenyPrefix}, summary.RulesChanged)
+	require.Empty(t, summary.RulesCreated)
+
+	summary = SummariseDefaultDenyChanges([]frontdoor.CustomRule{existing}, nil)
+	require.Len(t, summary.NetworksRemoved, 2)
+	require.Equal(t, []string{helpers.DefaultDenyPrefix}, summary.RulesDeleted)
+}
@@ -0,0 +1,306 @@
+package policy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/alexeyco/simpletable"
+	"github.com/gookit/color"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/jonhadfield/carbo/session"
+)
+
+// ApplyIPDeltaInput specifies networks to add to, and remove from, those already in the policy's rules for the action
+type ApplyIPDeltaInput struct {
+	ApplyIPsInput
+	Add    IPNets
+	Remove IPNets
+	// AddExpiries records expiries set by entries of Add
+	AddExpiries IPNetExpiries
+}
+
+// IPNetChange is a network added to, or removed from, an action's list
+type IPNetChange struct {
+	Net   string
+	Added bool
+}
+
+// ReadIPsFromReader reads addresses, networks or ranges, one per line, in the same format as a text IP list,
+// returning any expiries set by the entries
+func ReadIPsFromReader(name string, r io.Reader) (ipns IPNets, expiries IPNetExpiries, err error) {
+	expiries = make(IPNetExpiries)
+
+	ipns, err = parseTextIPList(name, r, expiries)
+
+	return
+}
+
+// IPNetsFromPolicy returns the networks held in the policy's custom rules with the prefix, along with the names of
+// the rules holding each network
+func IPNetsFromPolicy(p frontdoor.WebApplicationFirewallPolicy, prefix string) (ipns IPNets, sources IPNetSources) {
+	sources = make(IPNetSources)
+
+	if p.CustomRules == nil || p.CustomRules.Rules == nil {
+		return
+	}
+
+	for _, cr := range *p.CustomRules.Rules {
+		if !strings.HasPrefix(*cr.Name, prefix) {
+			continue
+		}
+
+		n := ipNetsFromCustomRule(cr)
+
+		ipns = append(ipns, n...)
+		sources.Add(n, *cr.Name)
+	}
+
+	return
+}
+
+// ApplyIPNetDelta returns the networks after adding those in add, and removing those in remove. Removing a network
+// also removes any networks within it, and removes it from any network containing it, leaving the remainder.
+func ApplyIPNetDelta(existing, add, remove IPNets) (res IPNets, changes []IPNetChange) {
+	existing, _ = deDupeIPNets(normaliseIPNets(existing))
+
+	present := make(map[string]bool, len(existing))
+	for _, ipn := range existing {
+		present[ipn.String()] = true
+	}
+
+	combined := append(IPNets{}, existing...)
+
+	added, _ := deDupeIPNets(normaliseIPNets(add))
+
+	for _, ipn := range added {
+		if !present[ipn.String()] {
+			combined = append(combined, ipn)
+		}
+	}
+
+	res = combined
+	if len(remove) > 0 {
+		res, _ = ExcludeProtectedIPNets(combined, remove)
+	}
+
+	sortIPNets(res)
+
+	changes = diffIPNets(existing, res)
+
+	return
+}
+
+// normaliseIPNets returns a copy of the networks with each in its canonical form
+func normaliseIPNets(ipns IPNets) (res IPNets) {
+	for _, ipn := range ipns {
+		res = append(res, normaliseIPNet(ipn))
+	}
+
+	return
+}
+
+// diffIPNets returns the networks in after that are not in before as added, and those in before that are not in
+// after as removed, in address order
+func diffIPNets(before, after IPNets) (changes []IPNetChange) {
+	inBefore := make(map[string]bool, len(before))
+	for _, ipn := range before {
+		n := normaliseIPNet(ipn)
+		inBefore[n.String()] = true
+	}
+
+	inAfter := make(map[string]bool, len(after))
+	for _, ipn := range after {
+		n := normaliseIPNet(ipn)
+		inAfter[n.String()] = true
+	}
+
+	all := append(normaliseIPNets(before), normaliseIPNets(after)...)
+	sortIPNets(all)
+
+	seen := make(map[string]bool)
+
+	for _, ipn := range all {
+		key := ipn.String()
+		if seen[key] || inBefore[key] == inAfter[key] {
+			continue
+		}
+
+		seen[key] = true
+
+		changes = append(changes, IPNetChange{Net: key, Added: inAfter[key]})
+	}
+
+	return
+}
+
+// ShowIPNetChanges displays a table listing the networks added to, and removed from, the action's list
+func ShowIPNetChanges(changes []IPNetChange, action string) {
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Change")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("%s Network", action)},
+		},
+	}
+
+	for _, c := range changes {
+		change := color.HiGreen.Sprint("added")
+		if !c.Added {
+			change = color.HiRed.Sprint("removed")
+		}
+
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: change},
+			{Text: c.Net},
+		})
+	}
+
+	table.SetStyle(simpletable.StyleRounded)
+
+	table.Println()
+}
+
+// ApplyIPDelta adds networks to, and removes networks from, those already held in the policy's rules for the action,
+// without requiring the complete list. The existing rules keep their scope and, if shards are provided, the networks
+// held by each shard are included and the result is spread across them again.
+func ApplyIPDelta(input ApplyIPDeltaInput) error {
+	s := session.Session{}
+
+	return applyIPDelta(&s, input)
+}
+
+func applyIPDelta(s *session.Session, input ApplyIPDeltaInput) (err error) {
+	prefix, err := helpers.PrefixFromAction(input.Action)
+	if err != nil {
+		return
+	}
+
+	if len(input.Add) == 0 && len(input.Remove) == 0 {
+		return fmt.Errorf("no IPs to add or remove")
+	}
+
+	// the networks of a sharded list are spread across the policy and its shards so are read from each
+	policies := append([]ResourceID{input.RID}, input.Shards...)
+
+	var (
+		existing      IPNets
+		existingRules []frontdoor.CustomRule
+	)
+
+	sources := make(IPNetSources)
+
+	for _, rid := range policies {
+		p, gErr := GetRawPolicy(s, rid.SubscriptionID, rid.ResourceGroup, rid.Name)
+		if gErr != nil {
+			return gErr
+		}
+
+		if p.Name == nil {
+			return fmt.Errorf("specified Policy not found: %s", rid.Raw)
+		}
+
+		ipns, srcs := IPNetsFromPolicy(p, prefix)
+
+		existing = append(existing, ipns...)
+
+		for k, v := range srcs {
+			sources[k] = append(sources[k], v...)
+		}
+
+		for _, cr := range customRules(p) {
+			if strings.HasPrefix(*cr.Name, prefix) {
+				existingRules = append(existingRules, cr)
+			}
+		}
+	}
+
+	scope, err := ipDeltaScope(existingRules, input.Scope)
+	if err != nil {
+		return
+	}
+
+	nets, changes := ApplyIPNetDelta(existing, input.Add, input.Remove)
+	if len(changes) == 0 {
+		log.Println("nothing to do")
+
+		return nil
+	}
+
+	// once every network is removed there is nothing to prepare, so each policy's rules for the action are replaced
+	// with none
+	if len(nets) == 0 {
+		for _, rid := range policies {
+			removeInput := input.ApplyIPsInput
+			removeInput.RID = rid
+			removeInput.Shards = nil
+			removeInput.Scope = scope
+			removeInput.Nets = nil
+
+			if err = applyPolicyIPChanges(s, removeInput, nil); err != nil {
+				return
+			}
+		}
+
+		return nil
+	}
+
+	apply := input.ApplyIPsInput
+	apply.Scope = scope
+	apply.Filepath = ""
+	apply.Nets = nets
+	apply.Sources = sources
+	apply.Sources.Add(input.Add, "command line")
+	apply.aggregated = true
+	apply.added = input.Add
+
+	// expiries only apply to the networks being added
+	apply.Expiries = make(IPNetExpiries)
+
+	if input.Expires != "" {
+		expiry, pErr := ParseExpiry(input.Expires, expiryNow())
+		if pErr != nil {
+			return pErr
+		}
+
+		apply.Expiries.Set(input.Add, expiry)
+	}
+
+	for k, v := range input.AddExpiries {
+		apply.Expiries[k] = v
+	}
+
+	apply.Expires = ""
+
+	return applyIPChanges(s, apply)
+}
+
+// ipDeltaScope returns the scope of the existing rules for the action so that changing their networks does not
+// change the requests they apply to. The requested scope is used if there are no existing rules, and must otherwise
+// either be empty or match theirs.
+func ipDeltaScope(existing []frontdoor.CustomRule, requested RuleScope) (scope RuleScope, err error) {
+	if len(existing) == 0 {
+		return requested, nil
+	}
+
+	scope = scopeFromCustomRule(existing[0])
+
+	for _, cr := range existing[1:] {
+		if scopeFromCustomRule(cr).key() != scope.key() {
+			return scope, fmt.Errorf("existing rules %s and %s have different scopes", *existing[0].Name, *cr.Name)
+		}
+	}
+
+	if requested.key() != (RuleScope{}).key() && requested.key() != scope.key() {
+		existingScope := scope.key()
+		if existingScope == "" {
+			existingScope = "none"
+		}
+
+		return scope, fmt.Errorf("scope '%s' does not match the scope '%s' of the existing rules: apply the complete list to change it", requested.key(), existingScope)
+	}
+
+	return scope, nil
+}
@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyIPNetDelta(t *testing.T) {
+	existing := parseIPNets(t, "10.0.0.0/24", "192.0.2.1/32", "2001:db8::/64")
+
+	// adding a network already present, and one already within a network, only adds what is new
+	res, changes := ApplyIPNetDelta(existing, parseIPNets(t, "192.0.2.1/32", "192.0.2.2/32", "2001:db8::1/128"), nil)
+	require.Equal(t, []string{"10.0.0.0/24", "192.0.2.1/32", "192.0.2.2/32", "2001:db8::/64", "2001:db8::1/128"}, res.toString())
+	require.Equal(t, []IPNetChange{
+		{Net: "192.0.2.2/32", Added: true},
+		{Net: "2001:db8::1/128", Added: true},
+	}, changes)
+
+	// removing a network within an existing network leaves the remainder
+	res, changes = ApplyIPNetDelta(existing, nil, parseIPNets(t, "192.0.2.1/32", "10.0.0.128/25"))
+	require.Equal(t, []string{"10.0.0.0/25", "2001:db8::/64"}, res.toString())
+	require.Equal(t, []IPNetChange{
+		{Net: "10.0.0.0/24", Added: false},
+		{Net: "10.0.0.0/25", Added: true},
+		{Net: "192.0.2.1/32", Added: false},
+	}, changes)
+
+	// removing a network that is not present changes nothing
+	res, changes = ApplyIPNetDelta(existing, nil, parseIPNets(t, "198.51.100.1/32"))
+	require.Len(t, res, 3)
+	require.Empty(t, changes)
+
+	res, changes = ApplyIPNetDelta(existing, nil, parseIPNets(t, "0.0.0.0/0", "::/0"))
+	require.Empty(t, res)
+	require.Len(t, changes, 3)
+}
+
+func TestIPNetsFromPolicy(t *testing.T) {
+	wp, err := LoadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+
+	ipns, sources := IPNetsFromPolicy(wp.Policy, "BlockList")
+	require.Equal(t, []string{"1.1.0.0/22", "2.2.0.0/22", "3.3.0.0/22"}, ipns.toString())
+	require.Equal(t, []string{"BlockListTwo"}, sources.Get(ipns[2]))
+
+	ipns, _ = IPNetsFromPolicy(wp.Policy, "AllowList")
+	require.Empty(t, ipns)
+}
+
+func TestReadIPsFromReader(t *testing.T) {
+	ipns, expiries, err := ReadIPsFromReader("arguments", strings.NewReader("192.0.2.1\n10.0.0.0-10.0.0.3 expires=2022-07-21\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.0.2.1/32", "10.0.0.0/30"}, ipns.toString())
+	require.Len(t, expiries, 1)
+
+	_, _, err = ReadIPsFromReader("arguments", strings.NewReader("192.0.2.1\nnot-an-ip\n"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "arguments:2:")
+}
+
+func TestIPDeltaScope(t *testing.T) {
+	scope, err := NormaliseRuleScope(RuleScope{Paths: []string{"/admin"}, Hosts: []string{"admin.example.com"}})
+	require.NoError(t, err)
+
+	scoped, _, err := GenScopedCustomRulesFromIPNets(hostIPNets(700), nil, 0, "Allow", scope)
+	require.NoError(t, err)
+	require.Len(t, scoped, 2)
+
+	// the existing rules' scope is kept when none is requested
+	res, err := ipDeltaScope(scoped, RuleScope{})
+	require.NoError(t, err)
+	require.Equal(t, scope, res)
+
+	// or when the requested scope is the same
+	res, err = ipDeltaScope(scoped, RuleScope{Hosts: []string{"Admin.Example.com"}, Paths: []string{"/Admin*"}})
+	require.NoError(t, err)
+	require.Equal(t, scope, res)
+
+	_, err = ipDeltaScope(scoped, RuleScope{Paths: []string{"/"}})
+	require.Error(t, err)
+
+	// a scope cannot be added to unscoped rules
+	unscoped, _, err := GenStableCustomRulesFromIPNets(hostIPNets(2), nil, 0, "Allow")
+	require.NoError(t, err)
+
+	_, err = ipDeltaScope(unscoped, scope)
+	require.Error(t, err)
+
+	// the requested scope is used for new rules
+	res, err = ipDeltaScope(nil, scope)
+	require.NoError(t, err)
+	require.Equal(t, scope, res)
+
+	_, err = ipDeltaScope([]frontdoor.CustomRule{scoped[0], unscoped[0]}, RuleScope{})
+	require.Error(t, err)
+}
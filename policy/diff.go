@@ -0,0 +1,694 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/gookit/color"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/jonhadfield/carbo/session"
+	"github.com/pkg/errors"
+)
+
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// DiffPoliciesInput specifies the two policies to compare, each being a policy resource id, a backup file or a file
+// containing a policy's json
+type DiffPoliciesInput struct {
+	Original string
+	New      string
+	// OutputFormat is the format the differences are output in: table, json, yaml or csv
+	OutputFormat string
+}
+
+// FieldDiff describes a value that differs between two policies
+type FieldDiff struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// MatchConditionDiff describes a match condition added to, removed from, or changed in a custom rule. Conditions are
+// compared by their position in the rule.
+type MatchConditionDiff struct {
+	Index         int         `json:"index"`
+	Change        string      `json:"change"`
+	Condition     string      `json:"condition"`
+	Fields        []FieldDiff `json:"fields,omitempty"`
+	ValuesAdded   []string    `json:"values_added,omitempty"`
+	ValuesRemoved []string    `json:"values_removed,omitempty"`
+}
+
+// CustomRuleDiff describes a custom rule added, removed or changed, matched between policies by name
+type CustomRuleDiff struct {
+	Name            string               `json:"name"`
+	Change          string               `json:"change"`
+	Fields          []FieldDiff          `json:"fields,omitempty"`
+	MatchConditions []MatchConditionDiff `json:"match_conditions,omitempty"`
+}
+
+// ManagedRuleSetDiff describes a managed rule set added, removed or changed, matched between policies by type
+type ManagedRuleSetDiff struct {
+	RuleSetType string      `json:"rule_set_type"`
+	Change      string      `json:"change"`
+	Fields      []FieldDiff `json:"fields,omitempty"`
+}
+
+// PolicyDiff describes the differences between two policies
+type PolicyDiff struct {
+	Original        string               `json:"original"`
+	New             string               `json:"new"`
+	Settings        []FieldDiff          `json:"settings"`
+	CustomRules     []CustomRuleDiff     `json:"custom_rules"`
+	ManagedRuleSets []ManagedRuleSetDiff `json:"managed_rule_sets"`
+}
+
+// HasDifferences returns true if the policies differ
+func (d PolicyDiff) HasDifferences() bool {
+	return len(d.Settings)+len(d.CustomRules)+len(d.ManagedRuleSets) > 0
+}
+
+// LoadPolicyFromSource returns the policy identified by a resource id, or read from a backup or policy json file
+func LoadPolicyFromSource(s *session.Session, source string) (p frontdoor.WebApplicationFirewallPolicy, err error) {
+	if helpers.ValidateResourceID(source, false) == nil {
+		rid := ParseResourceID(source)
+
+		p, err = GetRawPolicy(s, rid.SubscriptionID, rid.ResourceGroup, rid.Name)
+		if err != nil {
+			return
+		}
+
+		if p.Name == nil {
+			return p, fmt.Errorf("specified Policy not found: %s", source)
+		}
+
+		return
+	}
+
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return p, errors.Wrapf(err, "failed to read policy %s", source)
+	}
+
+	// backups wrap the policy with details of where it was taken from
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return p, errors.Wrapf(err, "failed to parse policy %s", source)
+	}
+
+	if raw, ok := fields["Policy"]; ok {
+		data = raw
+	}
+
+	if err = json.Unmarshal(data, &p); err != nil {
+		return p, errors.Wrapf(err, "failed to parse policy %s", source)
+	}
+
+	return p, nil
+}
+
+// diffField appends a FieldDiff if the values differ
+func diffField(diffs []FieldDiff, field, before, after string) []FieldDiff {
+	if before == after {
+		return diffs
+	}
+
+	return append(diffs, FieldDiff{Field: field, Before: before, After: after})
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+func int32Value(i *int32) string {
+	if i == nil {
+		return ""
+	}
+
+	return strconv.Itoa(int(*i))
+}
+
+func boolValue(b *bool) string {
+	if b == nil {
+		return ""
+	}
+
+	return strconv.FormatBool(*b)
+}
+
+// diffStrings returns the values only in after, and those only in before, sorted
+func diffStrings(before, after []string) (added, removed []string) {
+	inBefore := make(map[string]bool, len(before))
+	for _, v := range before {
+		inBefore[v] = true
+	}
+
+	inAfter := make(map[string]bool, len(after))
+	for _, v := range after {
+		inAfter[v] = true
+
+		if !inBefore[v] {
+			added = append(added, v)
+		}
+	}
+
+	for _, v := range before {
+		if !inAfter[v] {
+			removed = append(removed, v)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return
+}
+
+// describeMatchCondition returns a summary of a match condition, such as "RemoteAddr not IPMatch"
+func describeMatchCondition(mc frontdoor.MatchCondition) string {
+	parts := []string{string(mc.MatchVariable)}
+
+	if mc.Selector != nil && *mc.Selector != "" {
+		parts[0] = fmt.Sprintf("%s[%s]", mc.MatchVariable, *mc.Selector)
+	}
+
+	if mc.NegateCondition != nil && *mc.NegateCondition {
+		parts = append(parts, "not")
+	}
+
+	return strings.Join(append(parts, string(mc.Operator)), " ")
+}
+
+func transformsValue(ts *[]frontdoor.TransformType) string {
+	if ts == nil {
+		return ""
+	}
+
+	var s []string
+	for _, t := range *ts {
+		s = append(s, string(t))
+	}
+
+	return strings.Join(s, ", ")
+}
+
+func matchValues(mc frontdoor.MatchCondition) []string {
+	if mc.MatchValue == nil {
+		return nil
+	}
+
+	return *mc.MatchValue
+}
+
+func matchConditions(cr frontdoor.CustomRule) []frontdoor.MatchCondition {
+	if cr.MatchConditions == nil {
+		return nil
+	}
+
+	return *cr.MatchConditions
+}
+
+// diffMatchConditions compares the conditions of two versions of a custom rule
+func diffMatchConditions(before, after []frontdoor.MatchCondition) (diffs []MatchConditionDiff) {
+	for x := 0; x < len(before) || x < len(after); x++ {
+		switch {
+		case x >= len(before):
+			diffs = append(diffs, MatchConditionDiff{
+				Index:       x + 1,
+				Change:      diffAdded,
+				Condition:   describeMatchCondition(after[x]),
+				ValuesAdded: matchValues(after[x]),
+			})
+		case x >= len(after):
+			diffs = append(diffs, MatchConditionDiff{
+				Index:         x + 1,
+				Change:        diffRemoved,
+				Condition:     describeMatchCondition(before[x]),
+				ValuesRemoved: matchValues(before[x]),
+			})
+		default:
+			b, a := before[x], after[x]
+
+			var fields []FieldDiff
+			fields = diffField(fields, "match variable", string(b.MatchVariable), string(a.MatchVariable))
+			fields = diffField(fields, "selector", stringValue(b.Selector), stringValue(a.Selector))
+			fields = diffField(fields, "operator", string(b.Operator), string(a.Operator))
+			fields = diffField(fields, "negate", boolValue(b.NegateCondition), boolValue(a.NegateCondition))
+			fields = diffField(fields, "transforms", transformsValue(b.Transforms), transformsValue(a.Transforms))
+
+			added, removed := diffStrings(matchValues(b), matchValues(a))
+
+			if len(fields)+len(added)+len(removed) == 0 {
+				continue
+			}
+
+			diffs = append(diffs, MatchConditionDiff{
+				Index:         x + 1,
+				Change:        diffChanged,
+				Condition:     describeMatchCondition(a),
+				Fields:        fields,
+				ValuesAdded:   added,
+				ValuesRemoved: removed,
+			})
+		}
+	}
+
+	return
+}
+
+// customRuleFields returns the values of a custom rule, other than its name and conditions, to compare
+func customRuleFields(cr frontdoor.CustomRule) [][2]string {
+	return [][2]string{
+		{"priority", int32Value(cr.Priority)},
+		{"state", string(cr.EnabledState)},
+		{"rule type", string(cr.RuleType)},
+		{"rate limit duration", int32Value(cr.RateLimitDurationInMinutes)},
+		{"rate limit threshold", int32Value(cr.RateLimitThreshold)},
+		{"action", string(cr.Action)},
+	}
+}
+
+func customRules(p frontdoor.WebApplicationFirewallPolicy) (crs []frontdoor.CustomRule) {
+	if p.WebApplicationFirewallPolicyProperties == nil || p.CustomRules == nil || p.CustomRules.Rules == nil {
+		return
+	}
+
+	return *p.CustomRules.Rules
+}
+
+// diffCustomRulesByName compares the custom rules of two policies, matching rules by name
+func diffCustomRulesByName(before, after []frontdoor.CustomRule) (diffs []CustomRuleDiff) {
+	beforeByName := make(map[string]frontdoor.CustomRule, len(before))
+	for _, cr := range before {
+		beforeByName[stringValue(cr.Name)] = cr
+	}
+
+	afterByName := make(map[string]frontdoor.CustomRule, len(after))
+	for _, cr := range after {
+		afterByName[stringValue(cr.Name)] = cr
+	}
+
+	for _, cr := range after {
+		name := stringValue(cr.Name)
+
+		b, ok := beforeByName[name]
+		if !ok {
+			d := CustomRuleDiff{Name: name, Change: diffAdded}
+
+			for _, f := range customRuleFields(cr) {
+				d.Fields = diffField(d.Fields, f[0], "", f[1])
+			}
+
+			d.MatchConditions = diffMatchConditions(nil, matchConditions(cr))
+			diffs = append(diffs, d)
+
+			continue
+		}
+
+		d := CustomRuleDiff{Name: name, Change: diffChanged}
+
+		bFields := customRuleFields(b)
+		for x, f := range customRuleFields(cr) {
+			d.Fields = diffField(d.Fields, f[0], bFields[x][1], f[1])
+		}
+
+		d.MatchConditions = diffMatchConditions(matchConditions(b), matchConditions(cr))
+
+		if len(d.Fields)+len(d.MatchConditions) > 0 {
+			diffs = append(diffs, d)
+		}
+	}
+
+	for _, cr := range before {
+		name := stringValue(cr.Name)
+
+		if _, ok := afterByName[name]; ok {
+			continue
+		}
+
+		d := CustomRuleDiff{Name: name, Change: diffRemoved}
+
+		for _, f := range customRuleFields(cr) {
+			d.Fields = diffField(d.Fields, f[0], f[1], "")
+		}
+
+		d.MatchConditions = diffMatchConditions(matchConditions(cr), nil)
+		diffs = append(diffs, d)
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Name < diffs[j].Name
+	})
+
+	return
+}
+
+// exclusionStrings returns a description of each exclusion, such as "RequestHeaderNames Equals User-Agent", sorted
+func exclusionStrings(es *[]frontdoor.ManagedRuleExclusion) (s []string) {
+	if es == nil {
+		return
+	}
+
+	for _, e := range *es {
+		s = append(s, fmt.Sprintf("%s %s %s", e.MatchVariable, e.SelectorMatchOperator, stringValue(e.Selector)))
+	}
+
+	sort.Strings(s)
+
+	return
+}
+
+func exclusionsValue(es *[]frontdoor.ManagedRuleExclusion) string {
+	return strings.Join(exclusionStrings(es), ", ")
+}
+
+// managedRuleSetFields returns the values of a managed rule set to compare, including each rule override keyed by
+// its group and id
+func managedRuleSetFields(rs frontdoor.ManagedRuleSet) map[string]string {
+	fields := map[string]string{
+		"version":    stringValue(rs.RuleSetVersion),
+		"action":     string(rs.RuleSetAction),
+		"exclusions": exclusionsValue(rs.Exclusions),
+	}
+
+	if rs.RuleGroupOverrides == nil {
+		return fields
+	}
+
+	for _, rgo := range *rs.RuleGroupOverrides {
+		group := stringValue(rgo.RuleGroupName)
+
+		fields[fmt.Sprintf("group %s exclusions", group)] = exclusionsValue(rgo.Exclusions)
+
+		if rgo.Rules == nil {
+			continue
+		}
+
+		for _, r := range *rgo.Rules {
+			key := fmt.Sprintf("group %s rule %s", group, stringValue(r.RuleID))
+			fields[key] = strings.TrimSpace(fmt.Sprintf("%s %s", r.EnabledState, r.Action))
+
+			if e := exclusionsValue(r.Exclusions); e != "" {
+				fields[key+" exclusions"] = e
+			}
+		}
+	}
+
+	return fields
+}
+
+func managedRuleSets(p frontdoor.WebApplicationFirewallPolicy) (rss []frontdoor.ManagedRuleSet) {
+	if p.WebApplicationFirewallPolicyProperties == nil || p.ManagedRules == nil || p.ManagedRules.ManagedRuleSets == nil {
+		return
+	}
+
+	return *p.ManagedRules.ManagedRuleSets
+}
+
+// diffManagedRuleSets compares the managed rule sets of two policies, matching sets by type
+func diffManagedRuleSets(before, after []frontdoor.ManagedRuleSet) (diffs []ManagedRuleSetDiff) {
+	beforeFields := make(map[string]map[string]string, len(before))
+	for _, rs := range before {
+		beforeFields[stringValue(rs.RuleSetType)] = managedRuleSetFields(rs)
+	}
+
+	afterFields := make(map[string]map[string]string, len(after))
+	for _, rs := range after {
+		afterFields[stringValue(rs.RuleSetType)] = managedRuleSetFields(rs)
+	}
+
+	types := make(map[string]bool)
+	for t := range beforeFields {
+		types[t] = true
+	}
+
+	for t := range afterFields {
+		types[t] = true
+	}
+
+	for t := range types {
+		b, inBefore := beforeFields[t]
+		a, inAfter := afterFields[t]
+
+		d := ManagedRuleSetDiff{RuleSetType: t, Change: diffChanged}
+
+		switch {
+		case !inBefore:
+			d.Change = diffAdded
+		case !inAfter:
+			d.Change = diffRemoved
+		}
+
+		keys := make(map[string]bool)
+		for k := range b {
+			keys[k] = true
+		}
+
+		for k := range a {
+			keys[k] = true
+		}
+
+		for k := range keys {
+			d.Fields = diffField(d.Fields, k, b[k], a[k])
+		}
+
+		sort.Slice(d.Fields, func(i, j int) bool {
+			return d.Fields[i].Field < d.Fields[j].Field
+		})
+
+		if d.Change != diffChanged || len(d.Fields) > 0 {
+			diffs = append(diffs, d)
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].RuleSetType < diffs[j].RuleSetType
+	})
+
+	return
+}
+
+// diffPolicySettings compares the settings of two policies
+func diffPolicySettings(before, after frontdoor.WebApplicationFirewallPolicy) (diffs []FieldDiff) {
+	var b, a frontdoor.PolicySettings
+
+	if before.WebApplicationFirewallPolicyProperties != nil && before.PolicySettings != nil {
+		b = *before.PolicySettings
+	}
+
+	if after.WebApplicationFirewallPolicyProperties != nil && after.PolicySettings != nil {
+		a = *after.PolicySettings
+	}
+
+	diffs = diffField(diffs, "state", string(b.EnabledState), string(a.EnabledState))
+	diffs = diffField(diffs, "mode", string(b.Mode), string(a.Mode))
+	diffs = diffField(diffs, "redirect url", stringValue(b.RedirectURL), stringValue(a.RedirectURL))
+	diffs = diffField(diffs, "block response status code", int32Value(b.CustomBlockResponseStatusCode), int32Value(a.CustomBlockResponseStatusCode))
+	diffs = diffField(diffs, "block response body", stringValue(b.CustomBlockResponseBody), stringValue(a.CustomBlockResponseBody))
+	diffs = diffField(diffs, "request body check", string(b.RequestBodyCheck), string(a.RequestBodyCheck))
+
+	return
+}
+
+// DiffPolicies returns the differences between the settings, custom rules and managed rule sets of two policies
+func DiffPolicies(original, new frontdoor.WebApplicationFirewallPolicy) PolicyDiff {
+	// empty rather than null when output as json
+	d := PolicyDiff{
+		Settings:        []FieldDiff{},
+		CustomRules:     []CustomRuleDiff{},
+		ManagedRuleSets: []ManagedRuleSetDiff{},
+	}
+
+	d.Settings = append(d.Settings, diffPolicySettings(original, new)...)
+	d.CustomRules = append(d.CustomRules, diffCustomRulesByName(customRules(original), customRules(new))...)
+	d.ManagedRuleSets = append(d.ManagedRuleSets, diffManagedRuleSets(managedRuleSets(original), managedRuleSets(new))...)
+
+	return d
+}
+
+// diffColour returns the colour used to show a change
+func diffColour(change string) color.Color {
+	switch change {
+	case diffAdded:
+		return color.HiGreen
+	case diffRemoved:
+		return color.HiRed
+	default:
+		return color.HiYellow
+	}
+}
+
+// diffSymbol returns the symbol prefixing a change
+func diffSymbol(change string) string {
+	switch change {
+	case diffAdded:
+		return "+"
+	case diffRemoved:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+func showFieldDiffs(fields []FieldDiff, indent string) {
+	for _, f := range fields {
+		switch {
+		case f.Before == "":
+			fmt.Printf("%s%s: %s\n", indent, f.Field, color.HiGreen.Sprint(f.After))
+		case f.After == "":
+			fmt.Printf("%s%s: %s\n", indent, f.Field, color.HiRed.Sprint(f.Before))
+		default:
+			fmt.Printf("%s%s: %s -> %s\n", indent, f.Field, color.HiRed.Sprint(f.Before), color.HiGreen.Sprint(f.After))
+		}
+	}
+}
+
+// ShowPolicyDiff outputs the differences in the requested format: table (coloured text), json, yaml or csv
+func ShowPolicyDiff(diff PolicyDiff, format string) error {
+	return Render(diff, format)
+}
+
+// RenderTable outputs the differences as coloured text, with removals prefixed by - and additions by +
+func (diff PolicyDiff) RenderTable() {
+	color.Bold.Printf("--- %s\n", diff.Original)
+	color.Bold.Printf("+++ %s\n", diff.New)
+
+	if !diff.HasDifferences() {
+		fmt.Println("no differences")
+
+		return
+	}
+
+	if len(diff.Settings) > 0 {
+		color.Bold.Println("\nPolicy Settings")
+		showFieldDiffs(diff.Settings, "  ")
+	}
+
+	if len(diff.CustomRules) > 0 {
+		color.Bold.Println("\nCustom Rules")
+
+		for _, cr := range diff.CustomRules {
+			c := diffColour(cr.Change)
+			fmt.Printf("%s %s %s\n", c.Sprint(diffSymbol(cr.Change)), c.Sprint(cr.Name), cr.Change)
+
+			showFieldDiffs(cr.Fields, "    ")
+
+			for _, mc := range cr.MatchConditions {
+				mcc := diffColour(mc.Change)
+				fmt.Printf("    %s condition %d (%s) %s\n", mcc.Sprint(diffSymbol(mc.Change)), mc.Index, mc.Condition, mc.Change)
+
+				showFieldDiffs(mc.Fields, "        ")
+
+				for _, v := range mc.ValuesAdded {
+					fmt.Printf("        %s\n", color.HiGreen.Sprintf("+ %s", v))
+				}
+
+				for _, v := range mc.ValuesRemoved {
+					fmt.Printf("        %s\n", color.HiRed.Sprintf("- %s", v))
+				}
+			}
+		}
+	}
+
+	if len(diff.ManagedRuleSets) > 0 {
+		color.Bold.Println("\nManaged Rule Sets")
+
+		for _, rs := range diff.ManagedRuleSets {
+			c := diffColour(rs.Change)
+			fmt.Printf("%s %s %s\n", c.Sprint(diffSymbol(rs.Change)), c.Sprint(rs.RuleSetType), rs.Change)
+
+			showFieldDiffs(rs.Fields, "    ")
+		}
+	}
+}
+
+// CSVRecords returns a row per difference with columns section, name, change, condition, field, before and after.
+// Rules and rule sets added or removed have a single row, and each match value added or removed has a row with the
+// field "value".
+func (diff PolicyDiff) CSVRecords() [][]string {
+	records := [][]string{{"section", "name", "change", "condition", "field", "before", "after"}}
+
+	for _, f := range diff.Settings {
+		records = append(records, []string{"settings", "", diffChanged, "", f.Field, f.Before, f.After})
+	}
+
+	for _, cr := range diff.CustomRules {
+		if len(cr.Fields) == 0 && len(cr.MatchConditions) == 0 {
+			records = append(records, []string{"custom_rules", cr.Name, cr.Change, "", "", "", ""})
+		}
+
+		for _, f := range cr.Fields {
+			records = append(records, []string{"custom_rules", cr.Name, cr.Change, "", f.Field, f.Before, f.After})
+		}
+
+		for _, mc := range cr.MatchConditions {
+			condition := strconv.Itoa(mc.Index)
+
+			if len(mc.Fields) == 0 && len(mc.ValuesAdded) == 0 && len(mc.ValuesRemoved) == 0 {
+				records = append(records, []string{"custom_rules", cr.Name, mc.Change, condition, "", "", ""})
+			}
+
+			for _, f := range mc.Fields {
+				records = append(records, []string{"custom_rules", cr.Name, mc.Change, condition, f.Field, f.Before, f.After})
+			}
+
+			for _, v := range mc.ValuesAdded {
+				records = append(records, []string{"custom_rules", cr.Name, mc.Change, condition, "value", "", v})
+			}
+
+			for _, v := range mc.ValuesRemoved {
+				records = append(records, []string{"custom_rules", cr.Name, mc.Change, condition, "value", v, ""})
+			}
+		}
+	}
+
+	for _, rs := range diff.ManagedRuleSets {
+		if len(rs.Fields) == 0 {
+			records = append(records, []string{"managed_rule_sets", rs.RuleSetType, rs.Change, "", "", "", ""})
+		}
+
+		for _, f := range rs.Fields {
+			records = append(records, []string{"managed_rule_sets", rs.RuleSetType, rs.Change, "", f.Field, f.Before, f.After})
+		}
+	}
+
+	return records
+}
+
+// DiffPolicySources loads and compares two policies, each from a resource id, backup file or policy json file
+func DiffPolicySources(input DiffPoliciesInput) error {
+	s := session.Session{}
+
+	return diffPolicySources(&s, input)
+}
+
+func diffPolicySources(s *session.Session, input DiffPoliciesInput) error {
+	original, err := LoadPolicyFromSource(s, input.Original)
+	if err != nil {
+		return err
+	}
+
+	updated, err := LoadPolicyFromSource(s, input.New)
+	if err != nil {
+		return err
+	}
+
+	diff := DiffPolicies(original, updated)
+	diff.Original = input.Original
+	diff.New = input.New
+
+	return ShowPolicyDiff(diff, input.OutputFormat)
+}
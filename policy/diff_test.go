@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/jonhadfield/carbo/session"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPolicyFromSource(t *testing.T) {
+	s := session.Session{}
+
+	p, err := LoadPolicyFromSource(&s, "../testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+	require.Len(t, *p.CustomRules.Rules, 2)
+
+	// a policy's json without the backup wrapper
+	b, err := json.Marshal(p)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, ioutil.WriteFile(path, b, 0o600))
+
+	raw, err := LoadPolicyFromSource(&s, path)
+	require.NoError(t, err)
+	require.False(t, DiffPolicies(p, raw).HasDifferences())
+
+	_, err = LoadPolicyFromSource(&s, "../testfiles/missing.json")
+	require.Error(t, err)
+}
+
+func TestDiffPolicies(t *testing.T) {
+	s := session.Session{}
+
+	original, err := LoadPolicyFromSource(&s, "../testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+
+	updated, err := LoadPolicyFromSource(&s, "../testfiles/wrapped-policy-two.json")
+	require.NoError(t, err)
+
+	diff := DiffPolicies(original, updated)
+	require.True(t, diff.HasDifferences())
+	require.Empty(t, diff.Settings)
+	require.Len(t, diff.CustomRules, 3)
+
+	require.Equal(t, "BlockListOne", diff.CustomRules[0].Name)
+	require.Equal(t, diffChanged, diff.CustomRules[0].Change)
+	require.Empty(t, diff.CustomRules[0].Fields)
+	require.Len(t, diff.CustomRules[0].MatchConditions, 1)
+	require.Equal(t, "RemoteAddr IPMatch", diff.CustomRules[0].MatchConditions[0].Condition)
+	require.Equal(t, []string{"2.2.0.0/22"}, diff.CustomRules[0].MatchConditions[0].ValuesRemoved)
+
+	require.Equal(t, "BlockListThree", diff.CustomRules[1].Name)
+	require.Equal(t, diffAdded, diff.CustomRules[1].Change)
+	require.Len(t, diff.CustomRules[1].MatchConditions[0].ValuesAdded, 5)
+
+	require.Len(t, diff.ManagedRuleSets, 1)
+	require.Equal(t, "Microsoft_DefaultRuleSet", diff.ManagedRuleSets[0].RuleSetType)
+	require.Equal(t, "group SQLI rule 942340 exclusions", diff.ManagedRuleSets[0].Fields[0].Field)
+
+	// reversing the comparison removes the rule added
+	reversed := DiffPolicies(updated, original)
+	require.Equal(t, diffRemoved, reversed.CustomRules[1].Change)
+	require.Len(t, reversed.CustomRules[1].MatchConditions[0].ValuesRemoved, 5)
+
+	require.NoError(t, ShowPolicyDiff(diff, OutputFormatTable))
+	require.NoError(t, ShowPolicyDiff(diff, OutputFormatJSON))
+	require.Error(t, ShowPolicyDiff(diff, "xml"))
+
+	records := diff.CSVRecords()
+	require.Equal(t, []string{"section", "name", "change", "condition", "field", "before", "after"}, records[0])
+	require.Equal(t, []string{"custom_rules", "BlockListOne", diffChanged, "1", "value", "2.2.0.0/22", ""}, records[1])
+
+	// without differences each section is empty rather than null
+	same := DiffPolicies(original, original)
+	require.False(t, same.HasDifferences())
+
+	out, err := json.Marshal(same)
+	require.NoError(t, err)
+	require.Contains(t, string(out), `"settings":[],"custom_rules":[],"managed_rule_sets":[]`)
+}
+
+func TestDiffMatchConditions(t *testing.T) {
+	tr, f := true, false
+	selector := "Host"
+	hosts := []string{"example.com"}
+	ips := []string{"192.0.2.0/24"}
+
+	before := []frontdoor.MatchCondition{
+		{MatchVariable: "RemoteAddr", Operator: "IPMatch", NegateCondition: &f, MatchValue: &ips},
+		{MatchVariable: "RequestHeader", Selector: &selector, Operator: "Equal", NegateCondition: &f, MatchValue: &hosts},
+	}
+
+	after := []frontdoor.MatchCondition{
+		{MatchVariable: "SocketAddr", Operator: "IPMatch", NegateCondition: &tr, MatchValue: &ips},
+	}
+
+	diffs := diffMatchConditions(before, after)
+	require.Len(t, diffs, 2)
+	require.Equal(t, diffChanged, diffs[0].Change)
+	require.Equal(t, "SocketAddr not IPMatch", diffs[0].Condition)
+	require.Equal(t, []FieldDiff{
+		{Field: "match variable", Before: "RemoteAddr", After: "SocketAddr"},
+		{Field: "negate", Before: "false", After: "true"},
+	}, diffs[0].Fields)
+	require.Equal(t, diffRemoved, diffs[1].Change)
+	require.Equal(t, "RequestHeader[Host] Equal", diffs[1].Condition)
+	require.Equal(t, hosts, diffs[1].ValuesRemoved)
+}
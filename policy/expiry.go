@@ -0,0 +1,361 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ExpiryStateEnv is the environment variable that overrides the default path of the expiry state file
+	ExpiryStateEnv = "CARBO_EXPIRY_STATE"
+	// expiresField is the prefix of the field that sets the expiry of an entry in a text IP list
+	expiresField = "expires="
+)
+
+// expiryNow returns the current time, allowing tests to control expiry
+var expiryNow = time.Now
+
+// IPNetExpiries maps a network to the time it should stop being applied
+type IPNetExpiries map[string]time.Time
+
+// Set records the expiry for each of the provided networks
+func (e IPNetExpiries) Set(ipns IPNets, expiry time.Time) {
+	for _, ipn := range ipns {
+		n := normaliseIPNet(ipn)
+
+		e[n.String()] = expiry.UTC()
+	}
+}
+
+// Get returns the expiry recorded for the network, if any
+func (e IPNetExpiries) Get(ipn net.IPNet) (expiry time.Time, ok bool) {
+	n := normaliseIPNet(ipn)
+
+	expiry, ok = e[n.String()]
+
+	return
+}
+
+// ExpiryState records the expiry of networks applied to each policy, by policy id and then action
+type ExpiryState map[string]map[string]IPNetExpiries
+
+// ExpiredIPNet is a network removed from a list as its expiry has passed
+type ExpiredIPNet struct {
+	Net     string
+	Expired time.Time
+}
+
+// ParseExpiry accepts either a duration, e.g. 24h, that is added to base, or an RFC3339 timestamp or date
+func ParseExpiry(s string, base time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("expiry must be in the future: %s", s)
+		}
+
+		return base.Add(d).UTC(), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.UTC(), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid expiry: %s", s)
+}
+
+// parseExpiresField removes an "expires=<timestamp>" field from a line of a text IP list and returns the expiry.
+// Durations are not accepted as there is nothing in the list to say when they began.
+func parseExpiresField(line string) (rest string, expiry time.Time, ok bool, err error) {
+	i := strings.Index(line, expiresField)
+	if i < 0 {
+		return line, expiry, false, nil
+	}
+
+	fields := strings.Fields(line[i+len(expiresField):])
+	if len(fields) != 1 {
+		return line, expiry, false, fmt.Errorf("invalid expiry: %s", line[i:])
+	}
+
+	if _, dErr := time.ParseDuration(fields[0]); dErr == nil {
+		return line, expiry, false, fmt.Errorf("expiry of list entry must be a timestamp: %s", fields[0])
+	}
+
+	expiry, err = ParseExpiry(fields[0], time.Time{})
+	if err != nil {
+		return line, expiry, false, err
+	}
+
+	return strings.TrimSpace(line[:i]), expiry, true, nil
+}
+
+// DefaultExpiryStatePath returns the path of the expiry state file, unless overridden by the environment
+func DefaultExpiryStatePath() (string, error) {
+	if p := os.Getenv(ExpiryStateEnv); p != "" {
+		return p, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find config directory for expiry state")
+	}
+
+	return filepath.Join(dir, "carbo", "expiries.json"), nil
+}
+
+// LoadExpiryState reads the expiry state file, returning empty state if it does not exist
+func LoadExpiryState(path string) (state ExpiryState, err error) {
+	state = make(ExpiryState)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read expiry state %s", path)
+	}
+
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse expiry state %s", path)
+	}
+
+	return
+}
+
+// SaveExpiryState writes the expiry state file, creating its directory if required
+func SaveExpiryState(path string, state ExpiryState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return errors.Wrapf(err, "failed to create directory for expiry state %s", path)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrapf(ioutil.WriteFile(path, data, 0o600), "failed to write expiry state %s", path)
+}
+
+// RemoveExpiredIPNets returns the networks without an expiry, or with one after now, along with those removed
+func RemoveExpiredIPNets(ipns IPNets, expiries IPNetExpiries, now time.Time) (kept IPNets, expired []ExpiredIPNet) {
+	for _, ipn := range ipns {
+		expiry, ok := expiries.Get(ipn)
+		if ok && !now.Before(expiry) {
+			n := normaliseIPNet(ipn)
+			expired = append(expired, ExpiredIPNet{Net: n.String(), Expired: expiry})
+
+			continue
+		}
+
+		kept = append(kept, ipn)
+	}
+
+	return
+}
+
+// RemoveExpiredIPNetsWithin is RemoveExpiredIPNets for networks that may be aggregates, such as those read from
+// custom rules. An expired network is also removed from any network containing it, leaving the remainder.
+func RemoveExpiredIPNetsWithin(ipns IPNets, expiries IPNetExpiries, now time.Time) (kept IPNets, expired []ExpiredIPNet) {
+	var expiredNets IPNets
+
+	for k, v := range expiries {
+		if now.Before(v) {
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(k)
+		if err != nil {
+			continue
+		}
+
+		expiredNets = append(expiredNets, *n)
+	}
+
+	if len(expiredNets) == 0 {
+		return ipns, nil
+	}
+
+	sortIPNets(expiredNets)
+
+	kept, stripped := ExcludeProtectedIPNets(ipns, expiredNets)
+
+	seen := make(map[string]bool)
+
+	for _, s := range stripped {
+		if seen[s.Removed] {
+			continue
+		}
+
+		seen[s.Removed] = true
+
+		expired = append(expired, ExpiredIPNet{Net: s.Removed, Expired: expiries[s.Protected]})
+	}
+
+	return
+}
+
+// ipNetListed returns whether the network is equal to, or within, one of the networks
+func ipNetListed(ipn net.IPNet, ipns IPNets) bool {
+	for _, l := range ipns {
+		if ipNetContains(normaliseIPNet(l), ipn) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReportExpiredIPNets logs each network removed from the list as its expiry has passed
+func ReportExpiredIPNets(expired []ExpiredIPNet, action string) {
+	for _, e := range expired {
+		log.Printf("removed %s from %s list as it expired at %s\n", e.Net, strings.ToLower(action), e.Expired.Format(time.RFC3339))
+	}
+}
+
+// applyIPNetExpiries records the expiries of the networks being applied in the expiry state, and then removes any
+// networks with an expiry that has passed. Expiries provided replace those already recorded for the same network,
+// and networks added again without an expiry are no longer expired. The updated state is kept in the input to be
+// saved, by saveIPNetExpiries, once the networks are applied.
+func applyIPNetExpiries(input *ApplyIPsInput) (err error) {
+	if input.ExpiryStatePath == "" {
+		var pErr error
+
+		if input.ExpiryStatePath, pErr = DefaultExpiryStatePath(); pErr != nil {
+			// without a config directory there is no state to read, which only matters if there are expiries to record
+			if len(input.Expiries) == 0 {
+				logrus.Debugf("not applying expiry state: %s\n", pErr)
+
+				return nil
+			}
+
+			return pErr
+		}
+	}
+
+	state, err := LoadExpiryState(input.ExpiryStatePath)
+	if err != nil {
+		return
+	}
+
+	action := strings.ToLower(input.Action)
+
+	// the expiries of a sharded list are recorded against each of its policies
+	var policyIDs []string
+
+	for _, rid := range append([]ResourceID{input.RID}, input.Shards...) {
+		policyIDs = append(policyIDs, strings.ToLower(rid.Raw))
+	}
+
+	expiries := make(IPNetExpiries)
+
+	for _, policyID := range policyIDs {
+		for k, v := range state[policyID][action] {
+			expiries[k] = v
+		}
+	}
+
+	if len(expiries) == 0 && len(input.Expiries) == 0 {
+		return nil
+	}
+
+	now := expiryNow()
+
+	// networks read from existing rules keep their expiries, whereas those listed, or added, again without an
+	// expiry are applied once more
+	added := input.Nets
+	if input.aggregated {
+		added = input.added
+	}
+
+	for k, v := range expiries {
+		if _, ok := input.Expiries[k]; ok || now.Before(v) {
+			continue
+		}
+
+		if _, n, pErr := net.ParseCIDR(k); pErr == nil && ipNetListed(*n, added) {
+			logrus.Debugf("removing expired %s from expiry state as it was added again\n", k)
+
+			delete(expiries, k)
+		}
+	}
+
+	for k, v := range input.Expiries {
+		expiries[k] = v
+	}
+
+	kept, expired := RemoveExpiredIPNets(input.Nets, expiries, now)
+	if input.aggregated {
+		kept, expired = RemoveExpiredIPNetsWithin(input.Nets, expiries, now)
+	}
+
+	ReportExpiredIPNets(expired, input.Action)
+
+	// expired networks are tracked for as long as they are listed so that they are not applied again, and are no
+	// longer needed once they are not
+	var pruned []string
+
+	for k, v := range expiries {
+		if now.Before(v) {
+			continue
+		}
+
+		_, n, pErr := net.ParseCIDR(k)
+		if pErr != nil || !ipNetListed(*n, input.Nets) {
+			pruned = append(pruned, k)
+		}
+	}
+
+	sort.Strings(pruned)
+
+	for _, k := range pruned {
+		logrus.Debugf("removing expired %s from expiry state\n", k)
+
+		delete(expiries, k)
+	}
+
+	input.Nets = kept
+
+	for _, policyID := range policyIDs {
+		if state[policyID] == nil {
+			state[policyID] = make(map[string]IPNetExpiries)
+		}
+
+		state[policyID][action] = expiries
+		if len(expiries) == 0 {
+			delete(state[policyID], action)
+		}
+
+		if len(state[policyID]) == 0 {
+			delete(state, policyID)
+		}
+	}
+
+	input.expiryState = state
+
+	return nil
+}
+
+// saveIPNetExpiries saves the expiry state updated by applyIPNetExpiries, unless the changes were not applied
+func saveIPNetExpiries(input ApplyIPsInput) error {
+	if input.expiryState == nil || input.DryRun || input.Output {
+		return nil
+	}
+
+	return SaveExpiryState(input.ExpiryStatePath, input.expiryState)
+}
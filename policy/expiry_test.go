@@ -0,0 +1,221 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpiry(t *testing.T) {
+	base := time.Date(2022, 7, 20, 9, 0, 0, 0, time.UTC)
+
+	e, err := ParseExpiry("24h", base)
+	require.NoError(t, err)
+	require.Equal(t, base.Add(24*time.Hour), e)
+
+	e, err = ParseExpiry("2022-07-21T10:00:00+01:00", base)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2022, 7, 21, 9, 0, 0, 0, time.UTC), e)
+
+	e, err = ParseExpiry("2022-07-22", base)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2022, 7, 22, 0, 0, 0, 0, time.UTC), e)
+
+	_, err = ParseExpiry("-1h", base)
+	require.Error(t, err)
+
+	_, err = ParseExpiry("tomorrow", base)
+	require.Error(t, err)
+}
+
+func TestLoadExpiringIPsFromListPath(t *testing.T) {
+	ipns, _, expiries, err := LoadExpiringIPsFromListPath(IPListPath{Path: "testfiles/ipsets/expiring-list-one.ipset"})
+	require.NoError(t, err)
+	require.Len(t, ipns, 3)
+	require.Len(t, expiries, 2)
+
+	e, ok := expiries.Get(ipns[0])
+	require.True(t, ok)
+	require.Equal(t, time.Date(2022, 7, 21, 9, 0, 0, 0, time.UTC), e)
+
+	_, ok = expiries.Get(ipns[2])
+	require.False(t, ok)
+
+	// a list expiry duration is added to the time the list was modified, but entries keep their own
+	path := filepath.Join(t.TempDir(), "list.ipset")
+	data, err := os.ReadFile("testfiles/ipsets/expiring-list-one.ipset")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	modified := time.Date(2022, 7, 20, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(path, modified, modified))
+
+	ipns, _, expiries, err = LoadExpiringIPsFromListPath(IPListPath{Path: path, Expires: "48h"})
+	require.NoError(t, err)
+
+	e, _ = expiries.Get(ipns[0])
+	require.Equal(t, time.Date(2022, 7, 21, 9, 0, 0, 0, time.UTC), e)
+
+	e, _ = expiries.Get(ipns[2])
+	require.Equal(t, modified.Add(48*time.Hour), e)
+}
+
+func TestReadIPsRejectsEntryExpiryDuration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.ipset")
+	require.NoError(t, os.WriteFile(path, []byte("192.0.2.1 expires=24h\n"), 0o600))
+
+	_, err := ReadIPsFromFile(path)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), path+":1:")
+}
+
+func TestApplyIPNetExpiries(t *testing.T) {
+	defer func() { expiryNow = time.Now }()
+
+	statePath := filepath.Join(t.TempDir(), "carbo", "expiries.json")
+	rid := ParseResourceID("/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorWebApplicationFirewallPolicies/apple")
+
+	ipns, _, expiries, err := LoadExpiringIPsFromListPath(IPListPath{Path: "testfiles/ipsets/expiring-list-one.ipset"})
+	require.NoError(t, err)
+
+	apply := func(input ApplyIPsInput) IPNets {
+		require.NoError(t, applyIPNetExpiries(&input))
+		require.NoError(t, saveIPNetExpiries(input))
+
+		return input.Nets
+	}
+
+	recorded := func() IPNetExpiries {
+		state, err := LoadExpiryState(statePath)
+		require.NoError(t, err)
+
+		return state[strings.ToLower(rid.Raw)]["block"]
+	}
+
+	// before either expiry, every network is kept and the expiries are recorded once applied
+	expiryNow = func() time.Time { return time.Date(2022, 7, 20, 0, 0, 0, 0, time.UTC) }
+
+	input := ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns, Expiries: expiries, ExpiryStatePath: statePath}
+	require.NoError(t, applyIPNetExpiries(&input))
+	require.Len(t, input.Nets, 3)
+	require.Empty(t, recorded())
+
+	require.NoError(t, saveIPNetExpiries(input))
+	require.Len(t, recorded(), 2)
+
+	// networks read back from the policy's rules are dropped using the recorded state
+	expiryNow = func() time.Time { return time.Date(2022, 7, 21, 12, 0, 0, 0, time.UTC) }
+
+	require.Equal(t, []string{"192.0.2.11/32", "198.51.100.0/24"}, apply(ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns, ExpiryStatePath: statePath, aggregated: true}).toString())
+	require.Len(t, recorded(), 2)
+
+	// as are networks listed with expiries that have passed
+	require.Equal(t, []string{"192.0.2.11/32", "198.51.100.0/24"}, apply(ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns, Expiries: expiries, ExpiryStatePath: statePath}).toString())
+
+	// dry runs do not update the state
+	expiryNow = func() time.Time { return time.Date(2023, 7, 21, 0, 0, 0, 0, time.UTC) }
+
+	require.Equal(t, []string{"198.51.100.0/24"}, apply(ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns[1:], ExpiryStatePath: statePath, DryRun: true, aggregated: true}).toString())
+	require.Len(t, recorded(), 2)
+
+	// expired networks are tracked for as long as they are listed, however long ago they expired
+	require.Equal(t, []string{"198.51.100.0/24"}, apply(ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns, ExpiryStatePath: statePath, aggregated: true}).toString())
+	require.Len(t, recorded(), 2)
+
+	// and are no longer tracked once removed from the list
+	apply(ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns[1:], ExpiryStatePath: statePath, aggregated: true})
+	require.Len(t, recorded(), 1)
+
+	// a network added again without an expiry is applied and no longer expired
+	added := parseIPNets(t, "192.0.2.11/32")
+
+	require.Equal(t, []string{"192.0.2.10/32", "192.0.2.11/32", "198.51.100.0/24"}, apply(ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns, ExpiryStatePath: statePath, aggregated: true, added: added}).toString())
+	require.Empty(t, recorded())
+
+	require.Len(t, apply(ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns, ExpiryStatePath: statePath, aggregated: true}), 3)
+
+	// as is a network listed again without an expiry
+	input = ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns, Expiries: expiries, ExpiryStatePath: statePath}
+	input.Expiries = IPNetExpiries{"192.0.2.10/32": expiryNow().Add(-time.Hour)}
+	require.Len(t, apply(input), 2)
+	require.Len(t, recorded(), 1)
+
+	require.Len(t, apply(ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns, ExpiryStatePath: statePath}), 3)
+	require.Len(t, apply(ApplyIPsInput{RID: rid, Action: "Block", Nets: ipns, ExpiryStatePath: statePath, aggregated: true}), 3)
+	require.Empty(t, recorded())
+}
+
+func TestApplyIPNetExpiriesWithoutConfigDir(t *testing.T) {
+	t.Setenv(ExpiryStateEnv, "")
+	t.Setenv("HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	rid := ParseResourceID("/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorWebApplicationFirewallPolicies/apple")
+
+	// without expiries to record, there is no state to read
+	input := ApplyIPsInput{RID: rid, Action: "Block", Nets: parseIPNets(t, "192.0.2.10/32")}
+	require.NoError(t, applyIPNetExpiries(&input))
+	require.Len(t, input.Nets, 1)
+	require.NoError(t, saveIPNetExpiries(input))
+
+	input.Expiries = IPNetExpiries{"192.0.2.10/32": time.Now().Add(time.Hour)}
+	require.Error(t, applyIPNetExpiries(&input))
+}
+
+func TestRemoveExpiredIPNetsWithin(t *testing.T) {
+	now := time.Date(2022, 7, 21, 12, 0, 0, 0, time.UTC)
+
+	expiries := make(IPNetExpiries)
+	expiries.Set(parseIPNets(t, "192.0.2.10/32", "10.0.0.0/8"), now.Add(-time.Hour))
+	expiries.Set(parseIPNets(t, "192.0.2.11/32"), now.Add(time.Hour))
+
+	// an expired network aggregated into a supernet is removed from it, leaving the remainder
+	ipns := parseIPNets(t, "192.0.2.10/31", "10.1.0.0/16", "198.51.100.0/24")
+
+	kept, expired := RemoveExpiredIPNetsWithin(ipns, expiries, now)
+	require.Equal(t, []string{"192.0.2.11/32", "198.51.100.0/24"}, kept.toString())
+	require.Equal(t, []ExpiredIPNet{
+		{Net: "192.0.2.10/32", Expired: now.Add(-time.Hour)},
+		{Net: "10.1.0.0/16", Expired: now.Add(-time.Hour)},
+	}, expired)
+
+	// whereas only networks matching an expiry are removed from lists as loaded
+	kept, expired = RemoveExpiredIPNets(ipns, expiries, now)
+	require.Len(t, kept, 3)
+	require.Empty(t, expired)
+}
+
+func TestApplyIPNetExpiriesSharded(t *testing.T) {
+	defer func() { expiryNow = time.Now }()
+
+	expiryNow = func() time.Time { return time.Date(2022, 7, 20, 0, 0, 0, 0, time.UTC) }
+
+	statePath := filepath.Join(t.TempDir(), "expiries.json")
+	shards := shardIDs(2)
+
+	ipns, _, expiries, err := LoadExpiringIPsFromListPath(IPListPath{Path: "testfiles/ipsets/expiring-list-one.ipset"})
+	require.NoError(t, err)
+
+	input := ApplyIPsInput{RID: shards[0], Shards: shards[1:], Action: "Block", Nets: ipns, Expiries: expiries, ExpiryStatePath: statePath}
+	require.NoError(t, applyIPNetExpiries(&input))
+	require.NoError(t, saveIPNetExpiries(input))
+
+	// the expiries are recorded against every shard
+	state, err := LoadExpiryState(statePath)
+	require.NoError(t, err)
+
+	for _, rid := range shards {
+		require.Len(t, state[strings.ToLower(rid.Raw)]["block"], 2)
+	}
+
+	// so are applied when the networks are next read from any of them
+	expiryNow = func() time.Time { return time.Date(2022, 7, 21, 12, 0, 0, 0, time.UTC) }
+
+	input = ApplyIPsInput{RID: shards[1], Action: "Block", Nets: ipns, ExpiryStatePath: statePath, aggregated: true}
+	require.NoError(t, applyIPNetExpiries(&input))
+	require.Equal(t, []string{"192.0.2.11/32", "198.51.100.0/24"}, input.Nets.toString())
+}
@@ -0,0 +1,157 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/jonhadfield/carbo/session"
+	"github.com/pkg/errors"
+)
+
+// ExportIPsInput specifies the rules to collect networks from, and where and how to write them
+type ExportIPsInput struct {
+	PolicyID string
+	// Action selects the rules generated for the action, i.e. those with its prefix
+	Action string
+	// Prefix selects rules with names starting with it, and cannot be combined with Action
+	Prefix string
+	// RuleNames selects rules by name
+	RuleNames []string
+	// Path is the file to write to, or stdout if empty
+	Path   string
+	Format string
+}
+
+// CollectPolicyIPNets returns the sorted, unique networks matched by the policy's custom rules with names starting
+// with the prefix, or matching one of the names
+func CollectPolicyIPNets(p frontdoor.WebApplicationFirewallPolicy, prefix string, names []string) (ipns IPNets) {
+	if p.CustomRules == nil || p.CustomRules.Rules == nil {
+		return
+	}
+
+	for _, cr := range *p.CustomRules.Rules {
+		if cr.Name == nil {
+			continue
+		}
+
+		if (prefix != "" && strings.HasPrefix(*cr.Name, prefix)) || helpers.StringInSlice(*cr.Name, names, false) {
+			ipns = append(ipns, ipNetsFromCustomRule(cr)...)
+		}
+	}
+
+	sortIPNets(ipns)
+
+	ipns, _ = deDupeIPNets(ipns)
+
+	return
+}
+
+// FormatIPNets returns the networks in a format that can be read back as an IP list: text with one network per line,
+// csv with a "network" column, or a json array
+func FormatIPNets(ipns IPNets, format string) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case "", IPListFormatText:
+		var buf bytes.Buffer
+
+		for _, ipn := range ipns {
+			buf.WriteString(ipn.String())
+			buf.WriteString("\n")
+		}
+
+		return buf.Bytes(), nil
+	case IPListFormatCSV:
+		var buf bytes.Buffer
+
+		w := csv.NewWriter(&buf)
+
+		records := [][]string{{"network"}}
+		for _, ipn := range ipns {
+			records = append(records, []string{ipn.String()})
+		}
+
+		if err := w.WriteAll(records); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	case IPListFormatJSON:
+		values := ipns.toString()
+		if values == nil {
+			values = []string{}
+		}
+
+		b, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return append(b, '\n'), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// ExportIPs writes the networks held in the selected rules of a policy to a file, or stdout
+func ExportIPs(input ExportIPsInput) error {
+	s := session.Session{}
+
+	return exportIPs(&s, input)
+}
+
+func exportIPs(s *session.Session, input ExportIPsInput) (err error) {
+	prefix := input.Prefix
+
+	if input.Action != "" && input.Prefix != "" {
+		return fmt.Errorf("an action and a prefix cannot both be specified")
+	}
+
+	if input.Action != "" {
+		if prefix, err = helpers.PrefixFromAction(input.Action); err != nil {
+			return
+		}
+	}
+
+	if prefix == "" && len(input.RuleNames) == 0 {
+		return fmt.Errorf("an action, prefix or rule names must be specified")
+	}
+
+	rid := ParseResourceID(input.PolicyID)
+
+	p, err := GetRawPolicy(s, rid.SubscriptionID, rid.ResourceGroup, rid.Name)
+	if err != nil {
+		return
+	}
+
+	if p.Name == nil {
+		return fmt.Errorf("specified Policy not found")
+	}
+
+	ipns := CollectPolicyIPNets(p, prefix, input.RuleNames)
+
+	b, err := FormatIPNets(ipns, input.Format)
+	if err != nil {
+		return
+	}
+
+	if input.Path == "" {
+		_, err = os.Stdout.Write(b)
+
+		return
+	}
+
+	if err = ioutil.WriteFile(input.Path, b, 0o600); err != nil {
+		return errors.Wrapf(err, "failed to write ips to %s", input.Path)
+	}
+
+	log.Printf("exported %d networks to %s\n", len(ipns), input.Path)
+
+	return nil
+}
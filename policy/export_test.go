@@ -0,0 +1,55 @@
+package policy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/jonhadfield/carbo/session"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectPolicyIPNets(t *testing.T) {
+	wp, err := LoadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+
+	ipns := CollectPolicyIPNets(wp.Policy, "BlockList", nil)
+	require.Equal(t, []string{"1.1.0.0/22", "2.2.0.0/22", "3.3.0.0/22"}, ipns.toString())
+
+	ipns = CollectPolicyIPNets(wp.Policy, "", []string{"BlockListTwo"})
+	require.Equal(t, []string{"3.3.0.0/22"}, ipns.toString())
+
+	ipns = CollectPolicyIPNets(wp.Policy, "Missing", nil)
+	require.Empty(t, ipns)
+}
+
+func TestExportIPsRejectsActionWithPrefix(t *testing.T) {
+	s := session.Session{}
+
+	err := exportIPs(&s, ExportIPsInput{PolicyID: "/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorWebApplicationFirewallPolicies/apple", Action: "Block", Prefix: "BlockList"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot both be specified")
+}
+
+func TestFormatIPNetsRoundTrip(t *testing.T) {
+	ipns := parseIPNets(t, "10.0.0.0/24", "192.0.2.1/32", "2001:db8::/64")
+
+	for _, format := range []string{IPListFormatText, IPListFormatCSV, IPListFormatJSON} {
+		b, err := FormatIPNets(ipns, format)
+		require.NoError(t, err)
+
+		path := filepath.Join(t.TempDir(), "exported."+format)
+		require.NoError(t, ioutil.WriteFile(path, b, 0o600))
+
+		read, err := ReadIPsFromFile(path)
+		require.NoError(t, err, format)
+		require.Equal(t, ipns.toString(), read.toString(), format)
+	}
+
+	b, err := FormatIPNets(nil, IPListFormatJSON)
+	require.NoError(t, err)
+	require.Equal(t, "[]\n", string(b))
+
+	_, err = FormatIPNets(ipns, "xml")
+	require.Error(t, err)
+}
@@ -0,0 +1,254 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FeedCacheDirEnv is the environment variable that overrides the default directory feeds are cached in
+	FeedCacheDirEnv = "CARBO_FEED_CACHE_DIR"
+	feedTimeout     = 60 * time.Second
+)
+
+// feedClient is the client used to fetch feeds
+var feedClient = &http.Client{Timeout: feedTimeout}
+
+// feedNow returns the current time, allowing tests to control cache expiry
+var feedNow = time.Now
+
+// feedMeta records the details of the last good copy of a feed
+type feedMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Checksum     string    `json:"checksum"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// isFeedURL returns true if the path is a http or https url
+func isFeedURL(p string) bool {
+	lower := strings.ToLower(p)
+
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// feedCacheDir returns the directory the list path's feed is cached in
+func feedCacheDir(l IPListPath) (string, error) {
+	if l.CacheDir != "" {
+		return l.CacheDir, nil
+	}
+
+	if dir := os.Getenv(FeedCacheDirEnv); dir != "" {
+		return dir, nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find cache directory for feeds")
+	}
+
+	return filepath.Join(dir, "carbo", "feeds"), nil
+}
+
+// feedCachePaths returns the paths of the cached content and its metadata for the url
+func feedCachePaths(dir, feedURL string) (dataPath, metaPath string) {
+	sum := sha256.Sum256([]byte(feedURL))
+	name := hex.EncodeToString(sum[:])
+
+	return filepath.Join(dir, name+".data"), filepath.Join(dir, name+".json")
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// loadCachedFeed returns the cached content and metadata for the url, provided the content matches its checksum
+func loadCachedFeed(dir, feedURL string) (data []byte, meta feedMeta, ok bool) {
+	dataPath, metaPath := feedCachePaths(dir, feedURL)
+
+	mb, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(mb, &meta); err != nil {
+		return
+	}
+
+	data, err = ioutil.ReadFile(dataPath)
+	if err != nil {
+		return
+	}
+
+	if checksum(data) != meta.Checksum {
+		logrus.Debugf("ignoring cached copy of %s as its checksum does not match\n", feedURL)
+
+		return nil, meta, false
+	}
+
+	return data, meta, true
+}
+
+// saveCachedFeed writes the content and its metadata to the cache
+func saveCachedFeed(dir string, data []byte, meta feedMeta) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return errors.Wrapf(err, "failed to create feed cache %s", dir)
+	}
+
+	dataPath, metaPath := feedCachePaths(dir, meta.URL)
+
+	if data != nil {
+		if err := ioutil.WriteFile(dataPath, data, 0o600); err != nil {
+			return errors.Wrapf(err, "failed to cache %s", meta.URL)
+		}
+	}
+
+	mb, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrapf(ioutil.WriteFile(metaPath, mb, 0o600), "failed to cache %s", meta.URL)
+}
+
+// requestFeed fetches the url, using the cached metadata to make a conditional request. If the feed has not been
+// modified, the returned content is nil.
+func requestFeed(l IPListPath, cached feedMeta, haveCache bool) (data []byte, meta feedMeta, err error) {
+	req, err := http.NewRequest(http.MethodGet, l.URL, nil)
+	if err != nil {
+		return
+	}
+
+	if haveCache {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := feedClient.Do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+
+	meta = cached
+	meta.URL = l.URL
+	meta.Fetched = feedNow()
+
+	if resp.StatusCode == http.StatusNotModified && haveCache {
+		return nil, meta, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, meta, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	meta.Checksum = checksum(data)
+	meta.ETag = resp.Header.Get("ETag")
+	meta.LastModified = resp.Header.Get("Last-Modified")
+
+	if l.Checksum != "" && !strings.EqualFold(l.Checksum, meta.Checksum) {
+		return nil, meta, fmt.Errorf("checksum %s does not match expected %s", meta.Checksum, l.Checksum)
+	}
+
+	return
+}
+
+// feedFileExt returns the extension of the last element of the url's path, so formats can be detected from it
+func feedFileExt(feedURL string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return ""
+	}
+
+	return path.Ext(u.Path)
+}
+
+// ReadIPsFromFeed returns the IPs listed at the list path's url. A cached copy is used if it was fetched within the
+// refresh interval, otherwise the feed is requested, using the cached ETag and Last-Modified values to avoid
+// downloading unchanged content. A response is only cached once it has been parsed successfully and, if the request
+// fails or the response cannot be parsed, the last good copy is used instead.
+func ReadIPsFromFeed(l IPListPath) (ipns IPNets, err error) {
+	dir, err := feedCacheDir(l)
+	if err != nil {
+		return
+	}
+
+	fl := l
+	fl.Path = l.URL
+
+	if fl.Format == "" && fl.JSONPath == "" && fl.Column == "" {
+		switch strings.ToLower(feedFileExt(l.URL)) {
+		case ".json":
+			fl.Format = IPListFormatJSON
+		case ".csv":
+			fl.Format = IPListFormatCSV
+		}
+	}
+
+	cachedData, cached, haveCache := loadCachedFeed(dir, l.URL)
+
+	if haveCache && l.Checksum != "" && !strings.EqualFold(l.Checksum, cached.Checksum) {
+		haveCache = false
+	}
+
+	if haveCache && l.Refresh > 0 && feedNow().Sub(cached.Fetched) < l.Refresh {
+		logrus.Debugf("using cached copy of %s fetched %s\n", l.URL, cached.Fetched.Format(time.RFC3339))
+
+		return parseIPListData(fl, cachedData, nil)
+	}
+
+	data, meta, err := requestFeed(l, cached, haveCache)
+	if err == nil && data == nil {
+		logrus.Debugf("%s not modified since last fetched\n", l.URL)
+
+		data = cachedData
+	}
+
+	if err == nil {
+		ipns, err = parseIPListData(fl, data, nil)
+	}
+
+	if err != nil {
+		if !haveCache {
+			return nil, errors.Wrapf(err, "failed to fetch %s", l.URL)
+		}
+
+		log.Printf("failed to fetch %s, using copy fetched %s: %s\n", l.URL, cached.Fetched.Format(time.RFC3339), err)
+
+		return parseIPListData(fl, cachedData, nil)
+	}
+
+	if err = saveCachedFeed(dir, data, meta); err != nil {
+		log.Printf("%s\n", err)
+	}
+
+	return ipns, nil
+}
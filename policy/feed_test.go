@@ -0,0 +1,169 @@
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testFeedContent = "# test feed\n10.0.0.0/24\n192.168.0.1 # host\n"
+
+// newTestFeedServer returns a server serving the test feed with an ETag, counting requests and those answered with
+// not modified. If failing is set, every request fails.
+func newTestFeedServer(t *testing.T, failing *int32) (srv *httptest.Server, requests, notModified *int32) {
+	requests, notModified = new(int32), new(int32)
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+
+		if failing != nil && atomic.LoadInt32(failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(testFeedContent))
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return
+}
+
+func TestReadIPsFromFeedCaching(t *testing.T) {
+	srv, requests, notModified := newTestFeedServer(t, nil)
+
+	l := IPListPath{URL: srv.URL + "/feed.txt", CacheDir: t.TempDir()}
+
+	ipns, err := ReadIPsFromFeed(l)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.0/24", "192.168.0.1/32"}, ipns.toString())
+	require.Equal(t, int32(1), *requests)
+
+	// without a refresh interval the feed is revalidated using its ETag
+	ipns, err = ReadIPsFromFeed(l)
+	require.NoError(t, err)
+	require.Len(t, ipns, 2)
+	require.Equal(t, int32(2), *requests)
+	require.Equal(t, int32(1), *notModified)
+
+	// within the refresh interval the cached copy is used without a request
+	l.Refresh = time.Hour
+	_, err = ReadIPsFromFeed(l)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), *requests)
+
+	// once the interval passes the feed is requested again
+	defer func() { feedNow = time.Now }()
+
+	feedNow = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	_, err = ReadIPsFromFeed(l)
+	require.NoError(t, err)
+	require.Equal(t, int32(3), *requests)
+}
+
+func TestReadIPsFromFeedFallback(t *testing.T) {
+	failing := new(int32)
+	srv, _, _ := newTestFeedServer(t, failing)
+
+	l := IPListPath{URL: srv.URL + "/feed.txt", CacheDir: t.TempDir()}
+
+	_, err := ReadIPsFromFeed(l)
+	require.NoError(t, err)
+
+	atomic.StoreInt32(failing, 1)
+
+	// the last good copy is used when the fetch fails
+	ipns, err := ReadIPsFromFeed(l)
+	require.NoError(t, err)
+	require.Len(t, ipns, 2)
+
+	// without a cached copy the failure is returned
+	_, err = ReadIPsFromFeed(IPListPath{URL: srv.URL + "/feed.txt", CacheDir: t.TempDir()})
+	require.Error(t, err)
+
+	// an unreachable feed falls back to the cache too
+	srv.Close()
+
+	ipns, err = ReadIPsFromFeed(l)
+	require.NoError(t, err)
+	require.Len(t, ipns, 2)
+}
+
+func TestReadIPsFromFeedInvalidResponse(t *testing.T) {
+	var content atomic.Value
+
+	content.Store(testFeedContent)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(content.Load().(string)))
+	}))
+	t.Cleanup(srv.Close)
+
+	// a response that cannot be parsed is not cached
+	content.Store("<html>maintenance</html>\n")
+
+	l := IPListPath{URL: srv.URL + "/feed.txt", CacheDir: t.TempDir()}
+
+	_, err := ReadIPsFromFeed(l)
+	require.Error(t, err)
+
+	dir, err := feedCacheDir(l)
+	require.NoError(t, err)
+
+	_, _, haveCache := loadCachedFeed(dir, l.URL)
+	require.False(t, haveCache)
+
+	content.Store(testFeedContent)
+
+	_, err = ReadIPsFromFeed(l)
+	require.NoError(t, err)
+
+	// and the last good copy is used instead
+	content.Store("<html>maintenance</html>\n")
+
+	ipns, err := ReadIPsFromFeed(l)
+	require.NoError(t, err)
+	require.Len(t, ipns, 2)
+
+	cachedData, _, haveCache := loadCachedFeed(dir, l.URL)
+	require.True(t, haveCache)
+	require.Equal(t, testFeedContent, string(cachedData))
+}
+
+func TestReadIPsFromFeedChecksum(t *testing.T) {
+	srv, _, _ := newTestFeedServer(t, nil)
+
+	_, err := ReadIPsFromFeed(IPListPath{URL: srv.URL + "/feed.txt", CacheDir: t.TempDir(), Checksum: "0123"})
+	require.Error(t, err)
+
+	ipns, err := ReadIPsFromFeed(IPListPath{URL: srv.URL + "/feed.txt", CacheDir: t.TempDir(), Checksum: checksum([]byte(testFeedContent))})
+	require.NoError(t, err)
+	require.Len(t, ipns, 2)
+}
+
+func TestLoadSourcedIPsFromFeedPath(t *testing.T) {
+	srv, _, _ := newTestFeedServer(t, nil)
+
+	t.Setenv(FeedCacheDirEnv, t.TempDir())
+
+	feedURL := srv.URL + "/feed.txt"
+
+	ipns, sources, err := LoadSourcedIPsFromPath(feedURL)
+	require.NoError(t, err)
+	require.Len(t, ipns, 2)
+	require.Equal(t, []string{feedURL}, sources.Get(ipns[0]))
+}
@@ -0,0 +1,271 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/alexeyco/simpletable"
+	"github.com/gookit/color"
+	"github.com/jonhadfield/carbo/helpers"
+)
+
+// managedPrefixes are the prefixes of custom rules generated by carbo, with longer prefixes first
+var managedPrefixes = []string{
+	helpers.AllowCountriesPrefix,
+	helpers.BlockCountriesPrefix,
+	helpers.LogCountriesPrefix,
+	helpers.DefaultDenyPrefix,
+	helpers.AllowNetsPrefix,
+	helpers.BlockNetsPrefix,
+	helpers.LogNetsPrefix,
+	helpers.RedirectNetsPrefix,
+	helpers.RateLimitPrefix,
+}
+
+// unmanagedPrefix is the name the summary gives to custom rules not generated by carbo
+const unmanagedPrefix = "other"
+
+// CustomRuleFilter selects the custom rules to show. A rule is shown if it matches every criteria specified, with
+// each list matching if any of its values do.
+type CustomRuleFilter struct {
+	// Names are rule names or globs, e.g. BlockNets*, matched ignoring case
+	Names []string
+	// Prefix matches rules with names starting with it
+	Prefix string
+	// Actions are rule actions, e.g. Block
+	Actions []string
+	// PriorityMin and PriorityMax limit the rules to those with priorities within the range, if non-zero
+	PriorityMin int
+	PriorityMax int
+	// EnabledState is Enabled or Disabled
+	EnabledState string
+	// MatchVariables match rules with a condition on one of them, e.g. RemoteAddr
+	MatchVariables []string
+}
+
+// ParsePriorityRange returns the lowest and highest priorities in a range such as 1000-1999, 5000- or -999. A single
+// priority returns a range containing just that priority.
+func ParsePriorityRange(value string) (min, max int, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+
+	if parts[0] != "" {
+		if min, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil || min < 0 {
+			return 0, 0, fmt.Errorf("invalid priority range: %s", value)
+		}
+	}
+
+	if parts[1] != "" {
+		if max, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil || max < 0 {
+			return 0, 0, fmt.Errorf("invalid priority range: %s", value)
+		}
+	}
+
+	if max > 0 && min > max {
+		return 0, 0, fmt.Errorf("invalid priority range: %s", value)
+	}
+
+	return min, max, nil
+}
+
+// NormaliseCustomRuleFilter checks the filter's values are valid and returns it with them in the case used by Azure
+func NormaliseCustomRuleFilter(filter CustomRuleFilter) (res CustomRuleFilter, err error) {
+	res = filter
+
+	for _, n := range filter.Names {
+		if _, err = path.Match(strings.ToLower(n), ""); err != nil {
+			return res, fmt.Errorf("invalid rule name pattern: %s", n)
+		}
+	}
+
+	res.Actions = nil
+
+	for _, a := range filter.Actions {
+		var na string
+
+		if na, err = helpers.NormaliseCustomRuleAction(a); err != nil {
+			return res, fmt.Errorf("invalid action: %w", err)
+		}
+
+		res.Actions = append(res.Actions, na)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(filter.EnabledState)) {
+	case "":
+		res.EnabledState = ""
+	case "enabled":
+		res.EnabledState = "Enabled"
+	case "disabled":
+		res.EnabledState = "Disabled"
+	default:
+		return res, fmt.Errorf("invalid enabled state: %s", filter.EnabledState)
+	}
+
+	return res, nil
+}
+
+// Matches returns true if the custom rule meets the filter's criteria
+func (filter CustomRuleFilter) Matches(cr frontdoor.CustomRule) bool {
+	name := stringValue(cr.Name)
+
+	if filter.Prefix != "" && !strings.HasPrefix(strings.ToLower(name), strings.ToLower(filter.Prefix)) {
+		return false
+	}
+
+	if len(filter.Names) > 0 {
+		var matched bool
+
+		for _, n := range filter.Names {
+			if ok, _ := path.Match(strings.ToLower(n), strings.ToLower(name)); ok {
+				matched = true
+
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if len(filter.Actions) > 0 && !helpers.StringInSlice(string(cr.Action), filter.Actions, true) {
+		return false
+	}
+
+	if filter.PriorityMin > 0 || filter.PriorityMax > 0 {
+		if cr.Priority == nil || int(*cr.Priority) < filter.PriorityMin {
+			return false
+		}
+
+		if filter.PriorityMax > 0 && int(*cr.Priority) > filter.PriorityMax {
+			return false
+		}
+	}
+
+	if filter.EnabledState != "" && !strings.EqualFold(string(cr.EnabledState), filter.EnabledState) {
+		return false
+	}
+
+	if len(filter.MatchVariables) > 0 {
+		var matched bool
+
+		for _, mc := range matchConditions(cr) {
+			if helpers.StringInSlice(string(mc.MatchVariable), filter.MatchVariables, true) {
+				matched = true
+
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FilterCustomRules returns the custom rules matching the filter
+func FilterCustomRules(crs []frontdoor.CustomRule, filter CustomRuleFilter) (res []frontdoor.CustomRule) {
+	for _, cr := range crs {
+		if filter.Matches(cr) {
+			res = append(res, cr)
+		}
+	}
+
+	return
+}
+
+// managedPrefix returns the prefix of a custom rule generated by carbo, or other if it was not
+func managedPrefix(name string) string {
+	for _, prefix := range managedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return prefix
+		}
+	}
+
+	return unmanagedPrefix
+}
+
+// CustomRulesSummary counts custom rules by their action, and by the prefix of those generated by carbo
+type CustomRulesSummary struct {
+	Total    int            `json:"total"`
+	Actions  map[string]int `json:"actions"`
+	Prefixes map[string]int `json:"prefixes"`
+}
+
+// SummariseCustomRules returns the number of custom rules for each action and carbo prefix
+func SummariseCustomRules(crs []frontdoor.CustomRule) CustomRulesSummary {
+	summary := CustomRulesSummary{
+		Total:    len(crs),
+		Actions:  make(map[string]int),
+		Prefixes: make(map[string]int),
+	}
+
+	for _, cr := range crs {
+		summary.Actions[string(cr.Action)]++
+		summary.Prefixes[managedPrefix(stringValue(cr.Name))]++
+	}
+
+	return summary
+}
+
+// sortedKeys returns the keys of the counts in order
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// showCountsTable outputs the counts in a table of two columns, in order of the keys
+func showCountsTable(heading string, counts map[string]int) {
+	keys := sortedKeys(counts)
+
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf(heading)},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rules")},
+		},
+	}
+
+	for _, k := range keys {
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: k},
+			{Align: simpletable.AlignRight, Text: strconv.Itoa(counts[k])},
+		})
+	}
+
+	table.SetStyle(simpletable.StyleRounded)
+
+	table.Println()
+}
+
+// OutputCustomRulesSummary outputs the number of custom rules for each action and carbo prefix
+func OutputCustomRulesSummary(summary CustomRulesSummary) {
+	color.Bold.Printf("Custom Rules ")
+	fmt.Println(summary.Total)
+
+	if summary.Total == 0 {
+		return
+	}
+
+	showCountsTable("Action", summary.Actions)
+	showCountsTable("Prefix", summary.Prefixes)
+}
@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/stretchr/testify/require"
+)
+
+func filterTestRules(t *testing.T) []frontdoor.CustomRule {
+	t.Helper()
+
+	block := createCustomRule("BlockNets5000", "Block", 5000, parseIPNets(t, "192.0.2.0/24").toString())
+	allow := createCustomRule("AllowNets3000", "Allow", 3000, parseIPNets(t, "198.51.100.0/24").toString())
+	countries := createGeoMatchCustomRule("BlockCountries5500", "Block", 5500, []string{"ZZ"})
+	manual := createCustomRule("ManualLog", "Log", 10, parseIPNets(t, "203.0.113.0/24").toString())
+	manual.EnabledState = "Disabled"
+
+	return []frontdoor.CustomRule{block, allow, countries, manual}
+}
+
+func filterRuleNames(crs []frontdoor.CustomRule) (names []string) {
+	for _, cr := range crs {
+		names = append(names, *cr.Name)
+	}
+
+	return
+}
+
+func TestParsePriorityRange(t *testing.T) {
+	for value, expected := range map[string][2]int{
+		"":          {0, 0},
+		"1000-1999": {1000, 1999},
+		"5000-":     {5000, 0},
+		"-999":      {0, 999},
+		"100":       {100, 100},
+	} {
+		min, max, err := ParsePriorityRange(value)
+		require.NoError(t, err)
+		require.Equal(t, expected, [2]int{min, max}, value)
+	}
+
+	for _, value := range []string{"a-b", "2000-1000", "x", "1-2-3"} {
+		_, _, err := ParsePriorityRange(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestFilterCustomRules(t *testing.T) {
+	crs := filterTestRules(t)
+
+	for _, tc := range []struct {
+		filter   CustomRuleFilter
+		expected []string
+	}{
+		{CustomRuleFilter{}, []string{"BlockNets5000", "AllowNets3000", "BlockCountries5500", "ManualLog"}},
+		{CustomRuleFilter{Names: []string{"manuallog"}}, []string{"ManualLog"}},
+		{CustomRuleFilter{Names: []string{"Block*"}}, []string{"BlockNets5000", "BlockCountries5500"}},
+		{CustomRuleFilter{Prefix: "AllowNets"}, []string{"AllowNets3000"}},
+		{CustomRuleFilter{Actions: []string{"block", "log"}}, []string{"BlockNets5000", "BlockCountries5500", "ManualLog"}},
+		{CustomRuleFilter{PriorityMin: 3000, PriorityMax: 5000}, []string{"BlockNets5000", "AllowNets3000"}},
+		{CustomRuleFilter{PriorityMin: 5001}, []string{"BlockCountries5500"}},
+		{CustomRuleFilter{EnabledState: "disabled"}, []string{"ManualLog"}},
+		{CustomRuleFilter{Names: []string{"Block*"}, PriorityMax: 5000}, []string{"BlockNets5000"}},
+	} {
+		filter, err := NormaliseCustomRuleFilter(tc.filter)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, filterRuleNames(FilterCustomRules(crs, filter)))
+	}
+
+	// every rule generated here matches on RemoteAddr
+	require.Len(t, FilterCustomRules(crs, CustomRuleFilter{MatchVariables: []string{"remoteaddr"}}), 4)
+	require.Empty(t, FilterCustomRules(crs, CustomRuleFilter{MatchVariables: []string{"RequestUri"}}))
+
+	for _, invalid := range []CustomRuleFilter{
+		{Names: []string{"Block["}},
+		{Actions: []string{"deny"}},
+		{Actions: []string{"AnomalyScoring"}},
+		{EnabledState: "on"},
+	} {
+		_, err := NormaliseCustomRuleFilter(invalid)
+		require.Error(t, err)
+	}
+}
+
+func TestSummariseCustomRules(t *testing.T) {
+	summary := SummariseCustomRules(filterTestRules(t))
+	require.Equal(t, 4, summary.Total)
+	require.Equal(t, map[string]int{"Block": 2, "Allow": 1, "Log": 1}, summary.Actions)
+	require.Equal(t, map[string]int{"BlockNets": 1, "AllowNets": 1, "BlockCountries": 1, unmanagedPrefix: 1}, summary.Prefixes)
+
+	OutputCustomRulesSummary(summary)
+}
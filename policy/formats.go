@@ -0,0 +1,427 @@
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// IPListFormatText is a list of addresses, networks or ranges, one per line, with optional "#" comments
+	IPListFormatText = "text"
+	// IPListFormatCSV is a csv document with addresses, networks or ranges in one column
+	IPListFormatCSV = "csv"
+	// IPListFormatJSON is a json document with addresses, networks or ranges found at a json path
+	IPListFormatJSON = "json"
+)
+
+// IPListPath is a path to a file, or directory of files, or a url of a feed, containing IPs, along with how they
+// should be read. Format is detected from the file extension and content when not specified.
+type IPListPath struct {
+	Path   string `yaml:"path"`
+	Format string `yaml:"format"`
+	// URL is fetched instead of reading a local path, and is cached for the Refresh interval
+	URL     string        `yaml:"url"`
+	Refresh time.Duration `yaml:"refresh"`
+	// Checksum is the expected sha256 of the feed's content
+	Checksum string `yaml:"checksum"`
+	// CacheDir overrides the directory feeds are cached in
+	CacheDir string `yaml:"cache-dir"`
+	// Column is the csv column containing the IPs, either its header name or its position starting from 1
+	Column string `yaml:"column"`
+	// JSONPath is the path to the IPs within a json document, using gjson syntax, e.g. prefixes.#.ip_prefix
+	JSONPath string `yaml:"json-path"`
+	// Services, Regions and Tags restrict the prefixes loaded from documents published by cloud providers
+	Services []string `yaml:"services"`
+	Regions  []string `yaml:"regions"`
+	Tags     []string `yaml:"tags"`
+	// MinConfidence excludes addresses from threat intelligence lists with a lower confidence score
+	MinConfidence int `yaml:"min-confidence"`
+	// Expires is the expiry of every entry in the list, either a timestamp, or a duration after the list was modified
+	Expires string `yaml:"expires"`
+	// Name identifies the list as the source of its IPs, instead of its path or url
+	Name string `yaml:"name"`
+	// Exclude removes the IPs loaded from those of the other paths of the action, rather than adding them
+	Exclude bool `yaml:"exclude"`
+}
+
+// UnmarshalYAML allows an IPListPath to be specified as either a plain path or a mapping
+func (l *IPListPath) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		l.Path = value.Value
+
+		return nil
+	}
+
+	type plain IPListPath
+
+	return value.Decode((*plain)(l))
+}
+
+// String returns the name, path or url
+func (l IPListPath) String() string {
+	if l.Name != "" {
+		return l.Name
+	}
+
+	if l.URL != "" {
+		return l.URL
+	}
+
+	return l.Path
+}
+
+// ipListLineError returns an error identifying the file and line number where it was encountered
+func ipListLineError(path string, line int, err error) error {
+	return fmt.Errorf("%s:%d: %w", path, line, err)
+}
+
+// detectIPListFormat returns the declared format, or one derived from the file extension or content
+func detectIPListFormat(l IPListPath, data []byte) (string, error) {
+	format := strings.ToLower(l.Format)
+
+	switch format {
+	case IPListFormatText, IPListFormatCSV, IPListFormatJSON, IPListFormatAzureServiceTags, IPListFormatAWS,
+		IPListFormatGCP, IPListFormatCloudflare, IPListFormatSpamhaus, IPListFormatFireHOL, IPListFormatAbuseIPDB,
+		IPListFormatEmergingThreats:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported ip list format: %s", l.Format)
+	}
+
+	switch {
+	case l.JSONPath != "":
+		return IPListFormatJSON, nil
+	case l.Column != "":
+		return IPListFormatCSV, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(l.Path)) {
+	case ".netset":
+		return IPListFormatFireHOL, nil
+	case ".json":
+		return IPListFormatJSON, nil
+	case ".csv":
+		return IPListFormatCSV, nil
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return IPListFormatJSON, nil
+	}
+
+	return IPListFormatText, nil
+}
+
+// parseIPListData reads the IPs from the content of an IP list in the format specified or detected. If expiries is
+// provided, the expiry of any entry that sets one is recorded in it.
+func parseIPListData(l IPListPath, data []byte, expiries IPNetExpiries) (ipns IPNets, err error) {
+	format, err := detectIPListFormat(l, data)
+	if err != nil {
+		return
+	}
+
+	if l.MinConfidence > 0 && format != IPListFormatAbuseIPDB {
+		return nil, fmt.Errorf("%s: min-confidence can only filter abuseipdb lists", l.Path)
+	}
+
+	if helpers.StringInSlice(format, providerIPListFormats, false) {
+		return parseProviderIPList(l, format, data)
+	}
+
+	if helpers.StringInSlice(format, threatIntelIPListFormats, false) {
+		return parseThreatIntelIPList(l, format, data)
+	}
+
+	if hasIPListFilters(l) {
+		return nil, fmt.Errorf("%s: services, regions and tags can only filter cloud provider ip lists", l.Path)
+	}
+
+	switch format {
+	case IPListFormatCSV:
+		return parseCSVIPList(l, bytes.NewReader(data))
+	case IPListFormatJSON:
+		return parseJSONIPList(l, data)
+	default:
+		return parseTextIPList(l.Path, bytes.NewReader(data), expiries)
+	}
+}
+
+// parseTextIPList reads one address, network or range per line, ignoring blank lines and "#" comments. An entry may
+// be followed by "expires=<timestamp>", which is recorded in expiries if provided.
+func parseTextIPList(path string, r io.Reader, expiries IPNetExpiries) (ipns IPNets, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanLines)
+
+	var lineNo int
+
+	for scanner.Scan() {
+		lineNo++
+
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var expiry time.Time
+
+		var hasExpiry bool
+
+		line, expiry, hasExpiry, err = parseExpiresField(line)
+		if err != nil {
+			return nil, ipListLineError(path, lineNo, err)
+		}
+
+		var n IPNets
+
+		n, err = ParseIPNetOrRange(line)
+		if err != nil {
+			return nil, ipListLineError(path, lineNo, err)
+		}
+
+		if hasExpiry && expiries != nil {
+			expiries.Set(n, expiry)
+		}
+
+		ipns = append(ipns, n...)
+	}
+
+	return ipns, scanner.Err()
+}
+
+// parseCSVIPList reads the IPs from a single column of a csv document. If the column is specified by name, the
+// first row is the header. If it is specified by position, or not at all, the first row is skipped if it does not
+// contain an IP.
+func parseCSVIPList(l IPListPath, r io.Reader) (ipns IPNets, err error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	column := 0
+	namedColumn := false
+
+	if l.Column != "" {
+		var pos int
+
+		pos, err = strconv.Atoi(l.Column)
+
+		switch {
+		case err != nil:
+			namedColumn = true
+			err = nil
+		case pos < 1:
+			return nil, fmt.Errorf("%s: invalid csv column: %s", l.Path, l.Column)
+		default:
+			column = pos - 1
+		}
+	}
+
+	var row int
+
+	for {
+		var record []string
+
+		record, err = reader.Read()
+		if err == io.EOF {
+			return ipns, nil
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		if err != nil {
+			return nil, ipListLineError(l.Path, line, err)
+		}
+
+		row++
+
+		if row == 1 && namedColumn {
+			column = -1
+
+			for x, h := range record {
+				if strings.EqualFold(strings.TrimSpace(h), l.Column) {
+					column = x
+				}
+			}
+
+			if column == -1 {
+				return nil, fmt.Errorf("%s: csv column '%s' not found", l.Path, l.Column)
+			}
+
+			continue
+		}
+
+		if column >= len(record) {
+			return nil, ipListLineError(l.Path, line, fmt.Errorf("missing csv column %d", column+1))
+		}
+
+		value := strings.TrimSpace(record[column])
+		if value == "" {
+			continue
+		}
+
+		var n IPNets
+
+		n, err = ParseIPNetOrRange(value)
+		if err != nil {
+			// without a named column, a first row that isn't an ip is treated as a header
+			if row == 1 && !namedColumn {
+				err = nil
+
+				continue
+			}
+
+			return nil, ipListLineError(l.Path, line, err)
+		}
+
+		ipns = append(ipns, n...)
+	}
+}
+
+// parseJSONIPList reads the IPs found at the json path, which may be a single value or an array
+func parseJSONIPList(l IPListPath, data []byte) (ipns IPNets, err error) {
+	if !gjson.ValidBytes(data) {
+		return nil, fmt.Errorf("%s: invalid json", l.Path)
+	}
+
+	result := gjson.ParseBytes(data)
+	if l.JSONPath != "" {
+		result = result.Get(l.JSONPath)
+	}
+
+	if !result.Exists() {
+		return nil, fmt.Errorf("%s: json path '%s' not found", l.Path, l.JSONPath)
+	}
+
+	var values []gjson.Result
+
+	var flatten func(r gjson.Result)
+
+	flatten = func(r gjson.Result) {
+		if r.IsArray() {
+			for _, v := range r.Array() {
+				flatten(v)
+			}
+
+			return
+		}
+
+		values = append(values, r)
+	}
+
+	flatten(result)
+
+	for x, v := range values {
+		if v.Type != gjson.String {
+			return nil, fmt.Errorf("%s: json value %d at path '%s' is not a string: %s", l.Path, x, l.JSONPath, v.Raw)
+		}
+
+		var n IPNets
+
+		n, err = ParseIPNetOrRange(v.String())
+		if err != nil {
+			return nil, fmt.Errorf("%s: json value %d at path '%s': %w", l.Path, x, l.JSONPath, err)
+		}
+
+		ipns = append(ipns, n...)
+	}
+
+	return
+}
+
+// ParseIPNetOrRange accepts an address, a network, or a range in the form "start-end", and returns the smallest
+// set of networks covering it
+func ParseIPNetOrRange(s string) (ipns IPNets, err error) {
+	s = strings.TrimSpace(s)
+
+	if !strings.Contains(s, "-") {
+		var ipn net.IPNet
+
+		ipn, err = ParseIPNet(s)
+		if err != nil {
+			return
+		}
+
+		return IPNets{ipn}, nil
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+
+	start := net.ParseIP(strings.TrimSpace(parts[0]))
+	end := net.ParseIP(strings.TrimSpace(parts[1]))
+
+	if start == nil || end == nil {
+		return nil, fmt.Errorf("invalid ip range: %s", s)
+	}
+
+	return IPRangeToIPNets(start, end)
+}
+
+// IPRangeToIPNets returns the smallest set of networks covering every address from start to end inclusive
+func IPRangeToIPNets(start, end net.IP) (ipns IPNets, err error) {
+	bits := 8 * net.IPv4len
+
+	if start.To4() != nil && end.To4() != nil {
+		start, end = start.To4(), end.To4()
+	} else if start.To4() == nil && end.To4() == nil {
+		start, end = start.To16(), end.To16()
+		bits = 8 * net.IPv6len
+	} else {
+		return nil, fmt.Errorf("ip range mixes address families: %s-%s", start, end)
+	}
+
+	first := new(big.Int).SetBytes(start)
+	last := new(big.Int).SetBytes(end)
+
+	if first.Cmp(last) > 0 {
+		return nil, fmt.Errorf("ip range start is after end: %s-%s", start, end)
+	}
+
+	one := big.NewInt(1)
+
+	for first.Cmp(last) <= 0 {
+		// the largest block aligned at the first address that does not extend beyond the last
+		size := int(first.TrailingZeroBits())
+		if first.Sign() == 0 || size > bits {
+			size = bits
+		}
+
+		for size > 0 {
+			blockEnd := new(big.Int).Lsh(one, uint(size))
+			blockEnd.Add(blockEnd, first).Sub(blockEnd, one)
+
+			if blockEnd.Cmp(last) <= 0 {
+				break
+			}
+
+			size--
+		}
+
+		ip := make(net.IP, len(start))
+		first.FillBytes(ip)
+
+		ipns = append(ipns, net.IPNet{IP: ip, Mask: net.CIDRMask(bits-size, bits)})
+
+		first.Add(first, new(big.Int).Lsh(one, uint(size)))
+	}
+
+	return
+}
@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestIPRangeToIPNets(t *testing.T) {
+	ipns, err := IPRangeToIPNets(net.ParseIP("10.0.0.0"), net.ParseIP("10.0.0.255"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.0/24"}, ipns.toString())
+
+	ipns, err = IPRangeToIPNets(net.ParseIP("192.168.1.10"), net.ParseIP("192.168.1.20"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.168.1.10/31", "192.168.1.12/30", "192.168.1.16/30", "192.168.1.20/32"}, ipns.toString())
+
+	ipns, err = IPRangeToIPNets(net.ParseIP("0.0.0.0"), net.ParseIP("255.255.255.255"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"0.0.0.0/0"}, ipns.toString())
+
+	ipns, err = IPRangeToIPNets(net.ParseIP("2001:db8::"), net.ParseIP("2001:db8::1:0"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"2001:db8::/112", "2001:db8::1:0/128"}, ipns.toString())
+
+	_, err = IPRangeToIPNets(net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"))
+	require.Error(t, err)
+
+	_, err = IPRangeToIPNets(net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1"))
+	require.Error(t, err)
+}
+
+func TestReadIPsFromFileRangesAndComments(t *testing.T) {
+	ipns, err := ReadIPsFromFile("testfiles/ipsets/ranges-list-one.ipset")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"10.0.0.0/24",
+		"192.168.1.10/31",
+		"192.168.1.12/31",
+		"172.16.0.0/24",
+		"2001:db8::/120",
+		"8.8.8.8/32",
+	}, ipns.toString())
+}
+
+func TestReadIPsFromFileErrorsIncludeLine(t *testing.T) {
+	_, err := ReadIPsFromFile("testfiles/ipsets/invalid-range-list-one.ipset")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "testfiles/ipsets/invalid-range-list-one.ipset:2:")
+
+	_, err = ReadIPsFromFile("testfiles/ipsets/missing.ipset")
+	require.Error(t, err)
+}
+
+func TestReadIPsFromListPathCSV(t *testing.T) {
+	// detected from extension, with the header skipped and the column specified by name
+	ipns, err := ReadIPsFromListPath(IPListPath{Path: "testfiles/ipsets/export-list-one.csv", Column: "address"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.0/24", "172.16.0.1/32", "192.168.0.0/30"}, ipns.toString())
+
+	// column specified by position
+	ipns, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/ipsets/export-list-one.csv", Column: "2"})
+	require.NoError(t, err)
+	require.Len(t, ipns, 3)
+
+	_, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/ipsets/export-list-one.csv", Column: "missing"})
+	require.Error(t, err)
+
+	// first column holds names rather than ips
+	_, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/ipsets/export-list-one.csv"})
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "testfiles/ipsets/export-list-one.csv:2:"))
+}
+
+func TestReadIPsFromListPathJSON(t *testing.T) {
+	ipns, err := ReadIPsFromListPath(IPListPath{Path: "testfiles/ipsets/prefixes-list-one.json", JSONPath: "prefixes.#.ip_prefix"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"3.5.140.0/22", "13.34.37.64/27"}, ipns.toString())
+
+	ipns, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/ipsets/prefixes-list-one.json", JSONPath: "ipv6_prefixes.#.ipv6_prefix"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"2600:1f14:fff:f800::/56"}, ipns.toString())
+
+	_, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/ipsets/prefixes-list-one.json", JSONPath: "missing"})
+	require.Error(t, err)
+
+	_, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/ipsets/prefixes-list-one.json", JSONPath: "syncToken", Format: "xml"})
+	require.Error(t, err)
+}
+
+func TestIPListPathUnmarshalYAML(t *testing.T) {
+	var a Action
+
+	require.NoError(t, yaml.Unmarshal([]byte(`
+action: block
+paths:
+  - testfiles/ipsets/block-list-one.ipset
+  - path: testfiles/ipsets/export-list-one.csv
+    column: address
+`), &a))
+	require.Len(t, a.Paths, 2)
+	require.Equal(t, IPListPath{Path: "testfiles/ipsets/block-list-one.ipset"}, a.Paths[0])
+	require.Equal(t, IPListPath{Path: "testfiles/ipsets/export-list-one.csv", Column: "address"}, a.Paths[1])
+}
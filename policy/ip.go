@@ -5,22 +5,69 @@ import (
 	"github.com/jonhadfield/carbo/helpers"
 	"github.com/sirupsen/logrus"
 	"net"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
 )
 
 type ApplyIPsInput struct {
-	RID      ResourceID
-	Action   string
-	Output   bool
-	DryRun   bool
-	Filepath string
-	Nets     IPNets
-	MaxRules int
+	RID             ResourceID
+	Action          string
+	Output          bool
+	DryRun          bool
+	Filepath        string
+	FileFormat      string
+	FileColumn      string
+	FileJSONPath    string
+	Nets            IPNets
+	MaxRules        int
+	AllowTruncation bool
+	DroppedPath     string
+	Sources         IPNetSources
+	FailOnOverlap   bool
+	Protected       IPNets
+	ProtectedPath   string
+	RejectProtected bool
+	Expires         string
+	Expiries        IPNetExpiries
+	ExpiryStatePath string
+	// OutputFormat is the format the networks and rules changed are output in: table, json, yaml or csv
+	OutputFormat string
+	// Shards are further policies that, along with RID, the networks are spread across
+	Shards []ResourceID
+	// Scope limits the requests the networks apply to, e.g. to specific paths or hosts
+	Scope RuleScope
+	// aggregated is set when Nets were read from existing rules, so may hold aggregates of networks with expiries
+	aggregated bool
+	// added are the networks being added to those read from existing rules, when aggregated
+	added IPNets
+	// expiryState is the updated expiry state, saved to ExpiryStatePath once the networks are applied
+	expiryState ExpiryState
 }
 
 type IPNets []net.IPNet
 
+// ParseIPNet accepts a CIDR, or a bare IPv4 or IPv6 address, and returns the network it represents.
+// bare addresses are treated as a single host, i.e. /32 for IPv4 and /128 for IPv6.
+func ParseIPNet(s string) (ipn net.IPNet, err error) {
+	s = strings.TrimSpace(s)
+
+	if !strings.Contains(s, "/") {
+		if strings.Contains(s, ":") {
+			s += "/128"
+		} else {
+			s += "/32"
+		}
+	}
+
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return
+	}
+
+	return *n, nil
+}
+
 // toString receives slice of net.IPNet and returns a slice of their string representations
 func (ipns IPNets) toString() []string {
 	var res []string
@@ -31,17 +78,18 @@ func (ipns IPNets) toString() []string {
 	return res
 }
 
-// deDupeIPNets accepts a slice of net.IPNet and returns a unique slice of their string representations
-func deDupeIPNets(ipns IPNets) (res []string, err error) {
+// deDupeIPNets accepts a slice of net.IPNet and returns a unique slice, retaining the order first seen
+func deDupeIPNets(ipns IPNets) (res IPNets, err error) {
 	// check overlaps
 	seen := make(map[string]bool)
-	for _, i := range ipns.toString() {
-		if _, ok := seen[i]; ok {
+
+	for _, i := range ipns {
+		if _, ok := seen[i.String()]; ok {
 			continue
 		}
 
 		res = append(res, i)
-		seen[i] = true
+		seen[i.String()] = true
 	}
 
 	return
@@ -49,33 +97,92 @@ func deDupeIPNets(ipns IPNets) (res []string, err error) {
 
 // createCustomRule will return a frontdoor CustomRule constructed from the provided input
 func createCustomRule(name, action string, priority int32, items []string) frontdoor.CustomRule {
+	return createScopedCustomRule(name, action, priority, items, RuleScope{})
+}
+
+// createScopedCustomRule will return a frontdoor CustomRule matching the networks, with the scope's conditions ANDed
+func createScopedCustomRule(name, action string, priority int32, items []string, scope RuleScope) frontdoor.CustomRule {
 	f := false
 
 	t := &[]frontdoor.TransformType{}
 
+	matchVariable := frontdoor.MatchVariable(scope.MatchVariable)
+	if matchVariable == "" {
+		matchVariable = "RemoteAddr"
+	}
+
+	mcs := append([]frontdoor.MatchCondition{{
+		MatchVariable:   matchVariable,
+		NegateCondition: &f,
+		Operator:        "IPMatch",
+		MatchValue:      &items,
+		Transforms:      t,
+	}}, scope.matchConditions()...)
+
 	return frontdoor.CustomRule{
-		Name:         &name,
-		Priority:     &priority,
-		EnabledState: "Enabled",
-		RuleType:     "MatchRule",
-		MatchConditions: &[]frontdoor.MatchCondition{{
-			MatchVariable:   "RemoteAddr",
-			NegateCondition: &f,
-			Operator:        "IPMatch",
-			MatchValue:      &items,
-			Transforms:      t,
-		}},
-		Action: frontdoor.ActionType(action),
+		Name:            &name,
+		Priority:        &priority,
+		EnabledState:    "Enabled",
+		RuleType:        "MatchRule",
+		MatchConditions: &mcs,
+		Action:          frontdoor.ActionType(action),
+	}
+}
+
+// mergeCustomRules replaces the existing custom rules having the prefix with those generated and returns the full
+// set of rules ordered by priority. An error is returned if a rule not managed by carbo already has a priority
+// that a generated rule requires.
+func mergeCustomRules(existing, generated []frontdoor.CustomRule, prefix string) (merged []frontdoor.CustomRule, err error) {
+	used := make(map[int32]string)
+
+	for _, cr := range existing {
+		if strings.HasPrefix(*cr.Name, prefix) {
+			continue
+		}
+
+		used[*cr.Priority] = *cr.Name
+
+		merged = append(merged, cr)
+	}
+
+	for _, cr := range generated {
+		if name, ok := used[*cr.Priority]; ok {
+			return nil, fmt.Errorf("custom rule '%s' already has priority %d required by '%s'", name, *cr.Priority, *cr.Name)
+		}
+
+		merged = append(merged, cr)
 	}
+
+	helpers.SortRules(merged)
+
+	return
 }
 
 // GenCustomRulesFromIPNets accepts a list of IPs, plus the action to be taken with them, and the maximum
-// number of rules to create and then returns a slice of CustomRules
-func GenCustomRulesFromIPNets(ipns IPNets, maxRules int, action string) (crs []frontdoor.CustomRule, err error) {
+// number of rules to create and then returns a slice of CustomRules.
+// Any networks that could not be placed without exceeding the maximum number of rules are returned as dropped.
+func GenCustomRulesFromIPNets(ipns IPNets, maxRules int, action string) (crs []frontdoor.CustomRule, dropped IPNets, err error) {
+	return GenStableCustomRulesFromIPNets(ipns, nil, maxRules, action)
+}
+
+// GenStableCustomRulesFromIPNets behaves as GenCustomRulesFromIPNets but accepts the rules currently holding the
+// networks for the action. Networks are assigned to the existing rule covering their address range so that adding
+// or removing a network only changes the rule it belongs to.
+func GenStableCustomRulesFromIPNets(ipns IPNets, existing []frontdoor.CustomRule, maxRules int, action string) (crs []frontdoor.CustomRule, dropped IPNets, err error) {
+	return GenScopedCustomRulesFromIPNets(ipns, existing, maxRules, action, RuleScope{})
+}
+
+// GenScopedCustomRulesFromIPNets behaves as GenStableCustomRulesFromIPNets but each rule only matches requests within
+// the scope, e.g. to specific paths or hosts, and matches the networks against the scope's match variable.
+func GenScopedCustomRulesFromIPNets(ipns IPNets, existing []frontdoor.CustomRule, maxRules int, action string, scope RuleScope) (crs []frontdoor.CustomRule, dropped IPNets, err error) {
 	var priorityStart int
 
 	var ruleNamePrefix string
 
+	if action, err = helpers.NormaliseCustomRuleAction(action); err != nil {
+		return nil, nil, fmt.Errorf("invalid action: %w", err)
+	}
+
 	switch action {
 	case "Block":
 		priorityStart = helpers.BlockNetsPriorityStart
@@ -86,53 +193,51 @@ func GenCustomRulesFromIPNets(ipns IPNets, maxRules int, action string) (crs []f
 	case "Log":
 		priorityStart = helpers.LogNetsPriorityStart
 		ruleNamePrefix = helpers.LogNetsPrefix
-	default:
-		return nil, fmt.Errorf("invalid action: %s", action)
+	case "Redirect":
+		priorityStart = helpers.RedirectNetsPriorityStart
+		ruleNamePrefix = helpers.RedirectNetsPrefix
 	}
 
-	deDupedNets, err := deDupeIPNets(ipns)
+	sorted := make(IPNets, 0, len(ipns))
+	for _, ipn := range ipns {
+		sorted = append(sorted, normaliseIPNet(ipn))
+	}
+
+	sortIPNets(sorted)
+
+	deDupedNets, err := deDupeIPNets(sorted)
 	if err != nil {
 		return
 	}
 
 	logrus.Debugf("total networks after deduplication: %d", len(deDupedNets))
 
-	strDeDupedNets := deDupedNets
+	chunks := chunkIPNetsByRuleRange(deDupedNets, existing, int32(priorityStart))
 
-	priorityCount := int32(priorityStart)
+	// a zero value for max rules means no limit
+	if maxRules > 0 && len(chunks) > maxRules {
+		logrus.Debugf("%d rules required to retain existing ranges exceeds limit of %d so repacking", len(chunks), maxRules)
 
-	var lastChunkEnd int
+		chunks = chunkIPNets(deDupedNets, int32(priorityStart))
 
-	var matchValues []string
+		if len(chunks) > maxRules {
+			for _, c := range chunks[maxRules:] {
+				dropped = append(dropped, c.nets...)
+			}
 
-	var customRulesGenerated int32
+			chunks = chunks[:maxRules]
 
-	for x := range strDeDupedNets {
-		if x > 0 && x%helpers.MaxIPMatchValues == 0 {
-			matchValues = strDeDupedNets[x-helpers.MaxIPMatchValues : x]
-			lastChunkEnd = x
-		} else if x+1 == len(strDeDupedNets) {
-			matchValues = strDeDupedNets[lastChunkEnd:]
+			logrus.Debugf("%d networks dropped after reaching limit of %d rules", len(dropped), maxRules)
 		}
+	}
 
-		if len(matchValues) > 0 {
-			ruleName := fmt.Sprintf("%s%d", ruleNamePrefix, priorityCount)
-
-			bic := createCustomRule(ruleName, action, priorityCount, matchValues)
-
-			priorityCount++
-
-			crs = append(crs, bic)
-
-			customRulesGenerated++
-			if customRulesGenerated > 0 && customRulesGenerated == int32(maxRules) {
-				return
-			}
+	for _, c := range chunks {
+		ruleName := fmt.Sprintf("%s%d", ruleNamePrefix, c.priority)
 
-			// reset matchValues
-			matchValues = nil
-		}
+		crs = append(crs, createScopedCustomRule(ruleName, action, c.priority, c.nets.toString(), scope))
 	}
 
+	helpers.SortRules(crs)
+
 	return
 }
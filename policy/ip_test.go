@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
 	"github.com/stretchr/testify/require"
 )
 
@@ -34,6 +35,10 @@ func TestPrefixFromAction(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, helpers.LogNetsPrefix, r)
 
+	r, err = helpers.PrefixFromAction("Redirect")
+	require.NoError(t, err)
+	require.Equal(t, helpers.RedirectNetsPrefix, r)
+
 	r, err = helpers.PrefixFromAction("Deny")
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "unexpected action")
@@ -49,30 +54,83 @@ func TestGenCustomRulesFromIPNets(t *testing.T) {
 	require.NoError(t, err)
 
 	// Block testing
-	crs, err := GenCustomRulesFromIPNets(ipns, 10, "Block")
+	crs, _, err := GenCustomRulesFromIPNets(ipns, 10, "Block")
 	require.NoError(t, err)
-	require.Len(t, crs, 10)
+	require.Len(t, crs, 1)
 
-	crs, err = GenCustomRulesFromIPNets(ipns, 5, "Block")
+	crs, _, err = GenCustomRulesFromIPNets(ipns, 5, "Block")
 	require.NoError(t, err)
-	require.Len(t, crs, 5)
+	require.Len(t, crs, 1)
 
 	// Allow testing
-	crs, err = GenCustomRulesFromIPNets(ipns, 10, "Allow")
+	crs, _, err = GenCustomRulesFromIPNets(ipns, 10, "Allow")
 	require.NoError(t, err)
-	require.Len(t, crs, 10)
+	require.Len(t, crs, 1)
 
-	crs, err = GenCustomRulesFromIPNets(ipns, 5, "Allow")
+	crs, _, err = GenCustomRulesFromIPNets(ipns, 5, "Allow")
 	require.NoError(t, err)
-	require.Len(t, crs, 5)
+	require.Len(t, crs, 1)
 
 	// Log testing
-	crs, err = GenCustomRulesFromIPNets(ipns, 10, "Log")
+	crs, _, err = GenCustomRulesFromIPNets(ipns, 10, "Log")
+	require.NoError(t, err)
+	require.Len(t, crs, 1)
+
+	crs, _, err = GenCustomRulesFromIPNets(ipns, 5, "Log")
+	require.NoError(t, err)
+	require.Len(t, crs, 1)
+
+	// Redirect testing
+	crs, _, err = GenCustomRulesFromIPNets(ipns, 10, "redirect")
+	require.NoError(t, err)
+	require.Len(t, crs, 1)
+	require.Equal(t, frontdoor.ActionTypeRedirect, crs[0].Action)
+	require.Equal(t, int32(helpers.RedirectNetsPriorityStart), *crs[0].Priority)
+
+	// anomaly scoring only applies to managed rules
+	_, _, err = GenCustomRulesFromIPNets(ipns, 10, "AnomalyScoring")
+	require.Error(t, err)
+
+	_, _, err = GenCustomRulesFromIPNets(ipns, 10, "Deny")
+	require.Error(t, err)
+}
+
+
+func TestMergeCustomRulesInPriorityOrder(t *testing.T) {
+	existing := []frontdoor.CustomRule{
+		createCustomRule("ManualBlock", "Block", 4000, []string{"1.1.1.1/32"}),
+		createCustomRule("BlockNets5001", "Block", 5001, []string{"2.2.2.2/32"}),
+		createCustomRule("ManualLog", "Log", 10, []string{"3.3.3.3/32"}),
+		createCustomRule("BlockNets5000", "Block", 5000, []string{"4.4.4.4/32"}),
+		createCustomRule("ManualAllow", "Allow", 2000, []string{"5.5.5.5/32"}),
+	}
+
+	crs, _, err := GenCustomRulesFromIPNets(parseIPNets(t, "6.6.6.6/32"), 1, "Block")
 	require.NoError(t, err)
-	require.Len(t, crs, 10)
 
-	crs, err = GenCustomRulesFromIPNets(ipns, 5, "Log")
+	merged, err := mergeCustomRules(existing, crs, helpers.BlockNetsPrefix)
 	require.NoError(t, err)
-	require.Len(t, crs, 5)
+	require.Len(t, merged, 4)
+
+	var names []string
+	for _, cr := range merged {
+		names = append(names, *cr.Name)
+	}
+
+	require.Equal(t, []string{"ManualLog", "ManualAllow", "ManualBlock", "BlockNets5000"}, names)
+	require.Equal(t, []string{"6.6.6.6/32"}, *(*merged[3].MatchConditions)[0].MatchValue)
 }
 
+func TestMergeCustomRulesRejectsPriorityCollision(t *testing.T) {
+	existing := []frontdoor.CustomRule{
+		createCustomRule("ManualBlock", "Block", 5000, []string{"1.1.1.1/32"}),
+	}
+
+	crs, _, err := GenCustomRulesFromIPNets(parseIPNets(t, "6.6.6.6/32"), 1, "Block")
+	require.NoError(t, err)
+
+	_, err = mergeCustomRules(existing, crs, helpers.BlockNetsPrefix)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ManualBlock")
+	require.Contains(t, err.Error(), "5000")
+}
@@ -7,78 +7,42 @@ import (
 	"github.com/jonhadfield/carbo/session"
 	"github.com/wI2L/jsondiff"
 	"github.com/ztrue/tracerr"
-	"os"
 	"reflect"
-	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 
-	"github.com/pkg/errors"
-
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
-	"github.com/alexeyco/simpletable"
 	"github.com/gookit/color"
 )
 
-// PrintPolicy outputs the raw json policy with the provided resource id.
-func PrintPolicy(id string) error {
+// PrintPolicy outputs the policy with the provided resource id in the format requested.
+// json and yaml output the policy as returned by Azure, which is the default, whereas table and csv output its
+// PolicyResult.
+func PrintPolicy(id, format string) error {
+	format, err := NormaliseOutputFormat(format, OutputFormatJSON)
+	if err != nil {
+		return err
+	}
+
 	s := session.Session{}
 
 	components := ParseResourceID(id)
 
-	fmt.Println(components)
-
 	p, err := GetRawPolicy(&s, components.SubscriptionID, components.ResourceGroup, components.Name)
 	if err != nil {
 		return err
 	}
 
-	var b []byte
-
-	b, err = json.MarshalIndent(p, "", "    ")
-	if err != nil {
-		return errors.Wrap(err, "failed to marshall custom rule")
+	if format == OutputFormatJSON || format == OutputFormatYAML {
+		return renderData(p, format)
 	}
 
-	fmt.Println(string(b))
-
-	return nil
+	return Render(NewPolicyResult(p, customRules(p), true), format)
 }
 
-// ShowFrontDoors displays a table listing front doors, their endpoints, and their associated policies.
-func ShowFrontDoors(afds FrontDoors) {
-	table := simpletable.New()
-	table.Header = &simpletable.Header{
-		Cells: []*simpletable.Cell{
-			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Front Door")},
-			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Endpoint")},
-			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Policy")},
-		},
-	}
-
-	for _, afd := range afds {
-		var r []*simpletable.Cell
-
-		for x1, endpoint := range afd.Endpoints {
-			afdName := ""
-			if x1 == 0 {
-				afdName = afd.Name
-			}
-
-			r = []*simpletable.Cell{
-				{Text: afdName},
-				{Text: endpoint.Name},
-				{Text: *endpoint.WafPolicy.Name},
-			}
-			table.Body.Cells = append(table.Body.Cells, r)
-		}
-	}
-
-	table.Println()
-}
-
-// formatCRAction accepts a waf policy's action type and returns a coloured text representation
+// formatCRAction returns the action in upper case, coloured by its effect. Actions unknown to carbo are returned
+// without colour.
 func formatCRAction(a frontdoor.ActionType) string {
 	action := strings.ToUpper(string(a))
 	switch action {
@@ -88,8 +52,14 @@ func formatCRAction(a frontdoor.ActionType) string {
 		return color.HiYellow.Sprint(action)
 	case "ALLOW":
 		return color.HiGreen.Sprint(action)
+	case "REDIRECT":
+		return color.HiCyan.Sprint(action)
+	case "ANOMALYSCORING":
+		return color.HiMagenta.Sprint(action)
+	case "":
+		return "-"
 	default:
-		panic(fmt.Sprintf("unexpected rule action: %s", string(a)))
+		return action
 	}
 }
 
@@ -234,8 +204,30 @@ func GeneratePolicyPatch(i GeneratePolicyPatchInput) (output GeneratePolicyPatch
 	return
 }
 
-func ShowPolicy(policyID string, showFull bool) error {
-	rid := ParseResourceID(policyID)
+// ShowPolicyInput specifies the policy to show and the custom rules to include
+type ShowPolicyInput struct {
+	PolicyID string
+	ShowFull bool
+	Filter   CustomRuleFilter
+	// Summary outputs the number of custom rules for each action and carbo prefix instead of the rules
+	Summary bool
+	// OutputFormat is table, json, yaml or csv, defaulting to table
+	OutputFormat string
+}
+
+// ShowPolicy outputs the policy's PolicyResult, or its CustomRulesSummary if a summary is requested
+func ShowPolicy(input ShowPolicyInput) error {
+	filter, err := NormaliseCustomRuleFilter(input.Filter)
+	if err != nil {
+		return err
+	}
+
+	format, err := NormaliseOutputFormat(input.OutputFormat, OutputFormatTable)
+	if err != nil {
+		return err
+	}
+
+	rid := ParseResourceID(input.PolicyID)
 
 	s := session.Session{}
 
@@ -244,9 +236,13 @@ func ShowPolicy(policyID string, showFull bool) error {
 		return err
 	}
 
-	OutputPolicy(p, showFull)
+	crs := FilterCustomRules(customRules(p), filter)
+
+	if input.Summary {
+		return Render(SummariseCustomRules(crs), format)
+	}
 
-	return nil
+	return Render(NewPolicyResult(p, crs, input.ShowFull), format)
 }
 
 type GeneratePolicyPatchOutput struct {
@@ -267,140 +263,19 @@ type GeneratePolicyPatchInput struct {
 	New      frontdoor.WebApplicationFirewallPolicy
 }
 
-func ListFrontDoors(subID string) error {
-	s := session.Session{}
-
-	frontDoors, err := GetFrontDoors(&s, subID)
+// ListFrontDoors outputs the front doors in the subscription in the format requested, defaulting to a table
+func ListFrontDoors(subID, format string) error {
+	format, err := NormaliseOutputFormat(format, OutputFormatTable)
 	if err != nil {
 		return err
 	}
 
-	if len(frontDoors) == 0 {
-		fmt.Println("no front doors found")
-
-		return nil
-	}
-
-	ShowFrontDoors(frontDoors)
-
-	return nil
-}
-
-// OutputPolicy accepts a waf policy and outputs it in the form of a table
-func OutputPolicy(policy frontdoor.WebApplicationFirewallPolicy, showFull bool) {
-	color.Bold.Printf("Name ")
-	fmt.Println(*policy.Name)
-	color.Bold.Printf("Provisioning State ")
-	fmt.Println(*policy.ProvisioningState)
-	color.Bold.Printf("Resource State ")
-	fmt.Println(policy.ResourceState)
-	fmt.Println()
-
-	table := simpletable.New()
-
-	if len(*policy.CustomRules.Rules) > 0 {
-		color.Bold.Println("Custom Rules")
-
-		var maxCRNameLen int
-
-		for _, cr := range *policy.CustomRules.Rules {
-			if len(*cr.Name) > maxCRNameLen {
-				maxCRNameLen = len(*cr.Name)
-			}
-
-			rldim := " "
-			if cr.RateLimitDurationInMinutes != nil {
-				rldim = strconv.Itoa(int(*cr.RateLimitDurationInMinutes))
-			}
-
-			rlt := " "
-
-			if cr.RateLimitThreshold != nil {
-				rldim = strconv.Itoa(int(*cr.RateLimitThreshold))
-			}
-
-			table.Header = &simpletable.Header{
-				Cells: []*simpletable.Cell{
-					{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rule Name")},
-					{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("State")},
-					{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Priority")},
-					{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rule Type")},
-					{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rate Limit Duration (mins)")},
-					{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rate Limit Threshold")},
-					{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Action")},
-				},
-			}
-			table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
-				{Text: *cr.Name + "\n" + strings.Repeat("-", 11)},
-				{Text: string(cr.EnabledState) + "\n" + strings.Repeat("-", 9)},
-				{Text: strconv.Itoa(int(*cr.Priority)) + "\n" + strings.Repeat("-", 10)},
-				{Text: string(cr.RuleType) + "\n" + strings.Repeat("-", 13)},
-				{Text: rldim + "\n" + strings.Repeat("-", 28)},
-				{Text: rlt + "\n" + strings.Repeat("-", 22)},
-				{Align: simpletable.AlignCenter, Text: formatCRAction(cr.Action) + "\n" + strings.Repeat("-", 8)},
-			})
-			table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
-				{Text: color.HiBlue.Sprintf("Match Variable")},
-				{Text: color.HiBlue.Sprintf("Selector")},
-				{Text: color.HiBlue.Sprintf("Negate")},
-				{Text: color.HiBlue.Sprintf("Operator")},
-				{Text: color.HiBlue.Sprintf("Transforms")},
-				{Text: color.HiBlue.Sprintf("Match Value")},
-				{Text: ""},
-			})
-
-			for _, mc := range *cr.MatchConditions {
-				// cast the transforms slice to string slice
-				var transformsOutput strings.Builder
-
-				for x, t := range *mc.Transforms {
-					if x+1 == len(*mc.Transforms) {
-						transformsOutput.WriteString(string(t))
-
-						continue
-					}
-
-					transformsOutput.WriteString(fmt.Sprintf("%s, ", string(t)))
-				}
-
-				table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
-					{Text: string(mc.MatchVariable)},
-					{Text: dashIfEmptyString(mc.Selector)},
-					{Text: strconv.FormatBool(*mc.NegateCondition)},
-					{Text: dashIfEmptyString(string(mc.Operator))},
-					{Text: dashIfEmptyString(transformsOutput.String())},
-					{Text: wrapMatchValues(*mc.MatchValue, showFull)},
-					{Text: ""},
-				})
-			}
-			// separator
-			table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
-				{Text: ""},
-				{Text: ""},
-				{Text: ""},
-				{Text: ""},
-				{Text: ""},
-				{Text: ""},
-				{Text: ""},
-			})
-		}
-	}
-
-	table.SetStyle(simpletable.StyleRounded)
-
-	table.Println()
+	s := session.Session{}
 
-	d, err := helpers.PolicyHasDefaultDeny(policy)
+	frontDoors, err := GetFrontDoors(&s, subID)
 	if err != nil {
-		if logrus.IsLevelEnabled(logrus.DebugLevel) {
-			color.Red.Println("[ERROR] Failed to check if Policy has default deny.", err)
-			os.Exit(1)
-		}
-
-		color.Red.Println("[ERROR] Failed to check if Policy has default deny. run with debug for error")
+		return err
 	}
 
-	if err == nil && !d {
-		color.Yellow.Println("[WARNING] Policy does not have default deny")
-	}
+	return Render(NewFrontDoorList(frontDoors), format)
 }
@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/gookit/color"
+	"github.com/jonhadfield/carbo/session"
+	"github.com/stretchr/testify/require"
+)
+
+// captureStdout returns what the function writes to stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+
+	stdout := os.Stdout
+	os.Stdout = w
+
+	color.SetOutput(w)
+
+	defer func() {
+		os.Stdout = stdout
+
+		color.ResetOutput()
+	}()
+
+	f()
+
+	require.NoError(t, w.Close())
+
+	out, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	return string(out)
+}
+
+func TestRenderManagedRuleSets(t *testing.T) {
+	s := session.Session{}
+
+	p, err := LoadPolicyFromSource(&s, "../testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+
+	out := captureStdout(t, func() {
+		renderManagedRuleSets(newManagedRuleSetResults(managedRuleSets(p)), false)
+	})
+
+	for _, expected := range []string{
+		"Microsoft_DefaultRuleSet 1.1",
+		"Microsoft_BotManagerRuleSet 1.0",
+		"SQLI",
+		"RequestCookieNames Equals lemon",
+		"942200",
+		"942340",
+		"RequestBodyPostArgNames Equals jsonData",
+	} {
+		require.Contains(t, out, expected)
+	}
+
+	// overrides beyond the output limit are truncated unless showing in full
+	var rules []frontdoor.ManagedRuleOverride
+
+	for x := 0; x < 12; x++ {
+		id := fmt.Sprintf("9%05d", x)
+		rules = append(rules, frontdoor.ManagedRuleOverride{RuleID: &id})
+	}
+
+	rsType, group := "Microsoft_DefaultRuleSet", "XSS"
+	rss := []frontdoor.ManagedRuleSet{{
+		RuleSetType:        &rsType,
+		RuleGroupOverrides: &[]frontdoor.ManagedRuleGroupOverride{{RuleGroupName: &group, Rules: &rules}},
+	}}
+
+	out = captureStdout(t, func() {
+		renderManagedRuleSets(newManagedRuleSetResults(rss), false)
+	})
+	require.Contains(t, out, "... 3 remaining")
+	require.Contains(t, out, "Disabled")
+	require.NotContains(t, out, "900011")
+
+	out = captureStdout(t, func() {
+		renderManagedRuleSets(newManagedRuleSetResults(rss), true)
+	})
+	require.Contains(t, out, "900011")
+}
+
+func TestFormatCRAction(t *testing.T) {
+	for _, a := range []frontdoor.ActionType{"Allow", "Block", "Log", "Redirect", "AnomalyScoring"} {
+		require.Contains(t, formatCRAction(a), string(a[0]))
+	}
+
+	// actions unknown to carbo are output as plain text rather than failing
+	require.Equal(t, "CHALLENGE", formatCRAction("Challenge"))
+	require.Equal(t, "-", formatCRAction(""))
+}
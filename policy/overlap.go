@@ -0,0 +1,227 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/alexeyco/simpletable"
+	"github.com/gookit/color"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/jonhadfield/carbo/session"
+)
+
+// IPNetSources maps a network to the sources it was loaded from, such as file paths or custom rule names
+type IPNetSources map[string][]string
+
+// Add records the source for each of the provided networks
+func (s IPNetSources) Add(ipns IPNets, source string) {
+	for _, ipn := range ipns {
+		n := normaliseIPNet(ipn)
+		key := n.String()
+
+		if !helpers.StringInSlice(source, s[key], false) {
+			s[key] = append(s[key], source)
+		}
+	}
+}
+
+// Get returns the sources recorded for the network
+func (s IPNetSources) Get(ipn net.IPNet) []string {
+	n := normaliseIPNet(ipn)
+
+	return s[n.String()]
+}
+
+// IPNetList is a set of networks, and where they were loaded from, that the action is applied to within the scope
+type IPNetList struct {
+	Action  string
+	Nets    IPNets
+	Sources IPNetSources
+	Scope   RuleScope
+}
+
+// IPNetOverlap describes a network in one action's list that is equal to, or within, a network in another's
+type IPNetOverlap struct {
+	Action       string
+	Net          string
+	Sources      []string
+	OtherAction  string
+	OtherNet     string
+	OtherSources []string
+}
+
+// FindIPNetOverlaps accepts lists of networks for different actions and returns every case where a network in
+// one list is equal to, contains, or is contained by, a network in a list for a different action whose scope could
+// match the same requests, so an unscoped list overlaps any scoped list. Only lists for terminating actions, i.e.
+// Allow, Block and Redirect, can conflict, so Log lists are never reported as overlapping.
+func FindIPNetOverlaps(lists []IPNetList) (overlaps []IPNetOverlap) {
+	type listedIPNet struct {
+		list int
+		net  net.IPNet
+	}
+
+	var all []listedIPNet
+
+	for x, l := range lists {
+		// requests continue to be evaluated once logged so log lists may overlap any other
+		if !helpers.IsTerminatingAction(l.Action) {
+			continue
+		}
+
+		for _, ipn := range l.Nets {
+			all = append(all, listedIPNet{list: x, net: normaliseIPNet(ipn)})
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return compareIPNets(all[i].net, all[j].net) < 0
+	})
+
+	// networks are either disjoint or nested, so once sorted, the stack holds every network containing the current
+	var stack []listedIPNet
+
+	seen := make(map[string]bool)
+
+	for _, item := range all {
+		for len(stack) > 0 && !ipNetContains(stack[len(stack)-1].net, item.net) {
+			stack = stack[:len(stack)-1]
+		}
+
+		for _, container := range stack {
+			a, b := lists[container.list], lists[item.list]
+			// lists with scopes that cannot both match apply to different requests
+			if strings.EqualFold(a.Action, b.Action) || !a.Scope.intersects(b.Scope) {
+				continue
+			}
+
+			key := fmt.Sprintf("%d|%s|%d|%s", container.list, container.net.String(), item.list, item.net.String())
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+
+			overlaps = append(overlaps, IPNetOverlap{
+				Action:       a.Action,
+				Net:          container.net.String(),
+				Sources:      a.Sources.Get(container.net),
+				OtherAction:  b.Action,
+				OtherNet:     item.net.String(),
+				OtherSources: b.Sources.Get(item.net),
+			})
+		}
+
+		stack = append(stack, item)
+	}
+
+	return
+}
+
+// IPNetListsFromPolicy returns a list of networks for each action and scope used by the policy's custom rules,
+// recording the names of the rules each network is found in
+func IPNetListsFromPolicy(p frontdoor.WebApplicationFirewallPolicy, excludePrefix string) (lists []IPNetList) {
+	if p.CustomRules == nil || p.CustomRules.Rules == nil {
+		return
+	}
+
+	byActionScope := make(map[string]int)
+
+	for _, cr := range *p.CustomRules.Rules {
+		if excludePrefix != "" && strings.HasPrefix(*cr.Name, excludePrefix) {
+			continue
+		}
+
+		ipns := ipNetsFromCustomRule(cr)
+		if len(ipns) == 0 {
+			continue
+		}
+
+		action := string(cr.Action)
+		scope := scopeFromCustomRule(cr)
+		key := action + "|" + scope.key()
+
+		x, ok := byActionScope[key]
+		if !ok {
+			x = len(lists)
+			byActionScope[key] = x
+
+			lists = append(lists, IPNetList{Action: action, Sources: make(IPNetSources), Scope: scope})
+		}
+
+		lists[x].Nets = append(lists[x].Nets, ipns...)
+		lists[x].Sources.Add(ipns, *cr.Name)
+	}
+
+	return
+}
+
+// ReportIPNetOverlaps logs each overlap between lists
+func ReportIPNetOverlaps(overlaps []IPNetOverlap) {
+	for _, o := range overlaps {
+		log.Printf("%s %s (%s) overlaps %s %s (%s)\n",
+			strings.ToLower(o.Action), o.Net, strings.Join(o.Sources, ", "),
+			strings.ToLower(o.OtherAction), o.OtherNet, strings.Join(o.OtherSources, ", "))
+	}
+}
+
+// ShowIPNetOverlaps displays a table listing overlapping networks and their sources
+func ShowIPNetOverlaps(overlaps []IPNetOverlap) {
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Action")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Network")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Source")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Overlapping Action")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Overlapping Network")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Overlapping Source")},
+		},
+	}
+
+	for _, o := range overlaps {
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: formatCRAction(frontdoor.ActionType(o.Action))},
+			{Text: o.Net},
+			{Text: dashIfEmptyString(strings.Join(o.Sources, "\n"))},
+			{Text: formatCRAction(frontdoor.ActionType(o.OtherAction))},
+			{Text: o.OtherNet},
+			{Text: dashIfEmptyString(strings.Join(o.OtherSources, "\n"))},
+		})
+	}
+
+	table.SetStyle(simpletable.StyleRounded)
+
+	table.Println()
+}
+
+// CheckPolicyOverlaps outputs any networks that appear in the custom rules of more than one action in the policy
+// with the provided resource id. An error is returned if failOnOverlap is set and overlaps are found.
+func CheckPolicyOverlaps(policyID string, failOnOverlap bool) error {
+	rid := ParseResourceID(policyID)
+
+	s := session.Session{}
+
+	p, err := GetRawPolicy(&s, rid.SubscriptionID, rid.ResourceGroup, rid.Name)
+	if err != nil {
+		return err
+	}
+
+	overlaps := FindIPNetOverlaps(IPNetListsFromPolicy(p, ""))
+	if len(overlaps) == 0 {
+		fmt.Println("no overlaps found")
+
+		return nil
+	}
+
+	ShowIPNetOverlaps(overlaps)
+
+	if failOnOverlap {
+		return fmt.Errorf("%d overlaps found", len(overlaps))
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindIPNetOverlaps(t *testing.T) {
+	allow := IPNetList{Action: "Allow", Nets: parseIPNets(t, "10.0.0.0/24", "192.168.0.1/32", "2001:db8::/32"), Sources: make(IPNetSources)}
+	allow.Sources.Add(allow.Nets, "allow.ipset")
+
+	block := IPNetList{Action: "Block", Nets: parseIPNets(t, "10.0.0.5/32", "172.16.0.0/12", "192.168.0.1/32", "2001:db8:1::1/128"), Sources: make(IPNetSources)}
+	block.Sources.Add(block.Nets, "block.ipset")
+
+	// networks in lists for the same action are not overlaps
+	redirect := IPNetList{Action: "Redirect", Nets: parseIPNets(t, "172.16.1.0/24")}
+	moreRedirects := IPNetList{Action: "Redirect", Nets: parseIPNets(t, "172.20.0.0/16")}
+
+	// requests continue to be evaluated once logged so log lists never conflict
+	logs := IPNetList{Action: "Log", Nets: parseIPNets(t, "10.0.0.0/8", "172.16.0.0/12")}
+
+	overlaps := FindIPNetOverlaps([]IPNetList{allow, block, redirect, moreRedirects, logs})
+	require.Len(t, overlaps, 5)
+
+	require.Equal(t, IPNetOverlap{
+		Action:       "Allow",
+		Net:          "10.0.0.0/24",
+		Sources:      []string{"allow.ipset"},
+		OtherAction:  "Block",
+		OtherNet:     "10.0.0.5/32",
+		OtherSources: []string{"block.ipset"},
+	}, overlaps[0])
+
+	require.Equal(t, "172.16.0.0/12", overlaps[1].Net)
+	require.Equal(t, "172.16.1.0/24", overlaps[1].OtherNet)
+	require.Equal(t, "172.16.0.0/12", overlaps[2].Net)
+	require.Equal(t, "172.20.0.0/16", overlaps[2].OtherNet)
+	require.Equal(t, "192.168.0.1/32", overlaps[3].Net)
+	require.Equal(t, "2001:db8:1::1/128", overlaps[4].OtherNet)
+
+	require.Empty(t, FindIPNetOverlaps([]IPNetList{allow}))
+}
+
+func TestIPNetListsFromPolicy(t *testing.T) {
+	rules := []frontdoor.CustomRule{
+		createCustomRule("AllowNets3000", "Allow", 3000, []string{"10.0.0.0/24"}),
+		createCustomRule("ManualBlock", "Block", 4000, []string{"10.0.0.1/32"}),
+		createCustomRule("BlockNets5000", "Block", 5000, []string{"10.0.0.2/32"}),
+	}
+
+	p := frontdoor.WebApplicationFirewallPolicy{
+		WebApplicationFirewallPolicyProperties: &frontdoor.WebApplicationFirewallPolicyProperties{
+			CustomRules: &frontdoor.CustomRuleList{Rules: &rules},
+		},
+	}
+
+	overlaps := FindIPNetOverlaps(IPNetListsFromPolicy(p, ""))
+	require.Len(t, overlaps, 2)
+	require.Equal(t, []string{"AllowNets3000"}, overlaps[0].Sources)
+	require.Equal(t, []string{"ManualBlock"}, overlaps[0].OtherSources)
+	require.Equal(t, []string{"BlockNets5000"}, overlaps[1].OtherSources)
+
+	// rules with the excluded prefix are ignored
+	overlaps = FindIPNetOverlaps(IPNetListsFromPolicy(p, "BlockNets"))
+	require.Len(t, overlaps, 1)
+
+	// an unscoped rule applies to every request so overlaps a scoped rule
+	scope, err := NormaliseRuleScope(RuleScope{Paths: []string{"/admin"}, Hosts: []string{"admin.example.com"}})
+	require.NoError(t, err)
+
+	scoped, _, err := GenScopedCustomRulesFromIPNets(parseIPNets(t, "10.0.0.0/24"), nil, 0, "Allow", scope)
+	require.NoError(t, err)
+
+	rules = []frontdoor.CustomRule{scoped[0], rules[1]}
+
+	lists := IPNetListsFromPolicy(p, "")
+	require.Len(t, lists, 2)
+	require.Equal(t, scope, lists[0].Scope)
+	require.Len(t, FindIPNetOverlaps(lists), 1)
+
+	// as do scopes that could match the same requests
+	for _, other := range []RuleScope{
+		{Paths: []string{"/Admin/users"}},
+		{Paths: []string{"/"}, Methods: []string{"POST"}},
+		{Hosts: []string{"www.example.com", "Admin.Example.com"}},
+	} {
+		lists[1].Scope = other
+		require.Len(t, FindIPNetOverlaps(lists), 1, other.String())
+	}
+
+	// whereas scopes that cannot both match apply to different requests so do not overlap
+	for _, other := range []RuleScope{
+		{Paths: []string{"/api"}},
+		{Hosts: []string{"www.example.com"}},
+		{MatchVariable: "SocketAddr"},
+	} {
+		lists[1].Scope = other
+		require.Empty(t, FindIPNetOverlaps(lists), other.String())
+	}
+}
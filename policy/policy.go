@@ -1,7 +1,6 @@
 package policy
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"github.com/jonhadfield/carbo/helpers"
@@ -11,7 +10,6 @@ import (
 	"io/fs"
 	"io/ioutil"
 	"log"
-	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,11 +18,17 @@ import (
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
 )
 
-func ListPolicies(subID, appVersion string, max int) error {
+// ListPolicies outputs the policies in the subscription in the format requested, defaulting to a table
+func ListPolicies(subID, appVersion string, max int, format string) error {
 	if max == 0 {
 		return fmt.Errorf("invalid maximum number of policies to return")
 	}
 
+	format, err := NormaliseOutputFormat(format, OutputFormatTable)
+	if err != nil {
+		return err
+	}
+
 	s := session.Session{}
 
 	o, err := GetAllPolicies(&s, GetWrappedPoliciesInput{
@@ -37,18 +41,7 @@ func ListPolicies(subID, appVersion string, max int) error {
 		return err
 	}
 
-	if len(o) == 0 {
-		fmt.Println("no policies found")
-
-		return nil
-	}
-
-	// print the policy ids
-	for _, p := range o {
-		fmt.Println(*p.ID)
-	}
-
-	return nil
+	return Render(NewPolicyList(o), format)
 }
 
 // MatchExistingPolicyByID returns the raw policy matched by the policy id of its origin, e.g. where the backup was from
@@ -97,11 +90,11 @@ func deleteCustomRules(s *session.Session, dcri DeleteCustomRulesInput) (err err
 	}
 
 	// remove all but those starting with supplied prefix
-	preLen := len(*p.CustomRules.Rules)
+	preLen := len(customRules(p))
 
 	var ecrs []frontdoor.CustomRule
 
-	for _, cr := range *p.CustomRules.Rules {
+	for _, cr := range customRules(p) {
 		if !strings.HasPrefix(*cr.Name, dcri.Prefix) {
 			ecrs = append(ecrs, cr)
 		}
@@ -135,9 +128,17 @@ type DeleteCustomRulesInput struct {
 	Debug    bool
 }
 
-// PrintPolicyCustomRule outputs the custom rule for a given resource.
+// PrintPolicyCustomRule outputs the custom rule for a given resource in the format requested.
 // the id is an extended resource id: <policy>|<custom rule name>.
-func PrintPolicyCustomRule(id string) error {
+// json and yaml output the rule as returned by Azure, which is the default, whereas table and csv output its
+// CustomRuleResult.
+// If a save path is provided the rule is written to it as json instead.
+func PrintPolicyCustomRule(id, format, savePath string) error {
+	format, err := NormaliseOutputFormat(format, OutputFormatJSON)
+	if err != nil {
+		return err
+	}
+
 	s := session.Session{}
 
 	cr, err := GetRawPolicyCustomRuleByID(&s, id)
@@ -145,16 +146,22 @@ func PrintPolicyCustomRule(id string) error {
 		return err
 	}
 
-	var b []byte
+	if savePath != "" {
+		var b []byte
 
-	b, err = json.MarshalIndent(cr, "", "    ")
-	if err != nil {
-		return errors.Wrap(err, "failed to marshall custom rule")
+		b, err = json.MarshalIndent(cr, "", "    ")
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal custom rule")
+		}
+
+		return errors.Wrapf(ioutil.WriteFile(savePath, b, 0o600), "failed to write custom rule to %s", savePath)
 	}
 
-	fmt.Println(string(b))
+	if format == OutputFormatJSON || format == OutputFormatYAML {
+		return renderData(cr, format)
+	}
 
-	return nil
+	return Render(NewCustomRuleResult(cr, true), format)
 }
 
 // GetRawPolicyCustomRuleByID returns a custom rule matching the resource id.
@@ -172,7 +179,7 @@ func GetRawPolicyCustomRuleByID(s *session.Session, id string) (pcr frontdoor.Cu
 		return pcr, err
 	}
 
-	for _, r := range *p.CustomRules.Rules {
+	for _, r := range customRules(p) {
 		if *r.Name == ruleName {
 			pcr = r
 
@@ -190,16 +197,77 @@ func GetRawPolicyCustomRuleByID(s *session.Session, id string) (pcr frontdoor.Cu
 // LoadIPsFromPath accepts a file path or directory and then generates a fully qualified path
 // in order to call a function to load the ips from each fully qualified file path
 func LoadIPsFromPath(path string) (ipNets IPNets, err error) {
+	ipNets, _, err = LoadSourcedIPsFromPath(path)
+
+	return
+}
+
+// LoadSourcedIPsFromPath behaves as LoadIPsFromPath but also returns the file each network was loaded from
+func LoadSourcedIPsFromPath(path string) (ipNets IPNets, sources IPNetSources, err error) {
+	return LoadSourcedIPsFromListPath(IPListPath{Path: path})
+}
+
+// LoadSourcedIPsFromListPath loads the ips from the feed, file, or each file in the directory, using the format declared
+// by the list path, or detected from each file, and returns them along with the name of the list, or the feed or file,
+// each network was loaded from
+func LoadSourcedIPsFromListPath(l IPListPath) (ipNets IPNets, sources IPNetSources, err error) {
+	ipNets, sources, _, err = LoadExpiringIPsFromListPath(l)
+
+	return
+}
+
+// LoadExpiringIPsFromListPath behaves as LoadSourcedIPsFromListPath but also returns the expiry of any networks that
+// have one, either set by an entry in a list or by the expiry of the list path. An expiry duration for the list path
+// is added to the time each file was last modified.
+func LoadExpiringIPsFromListPath(l IPListPath) (ipNets IPNets, sources IPNetSources, expiries IPNetExpiries, err error) {
+	sources = make(IPNetSources)
+	expiries = make(IPNetExpiries)
+
+	if l.URL == "" && isFeedURL(l.Path) {
+		l.URL = l.Path
+	}
+
+	if l.URL != "" {
+		ipNets, err = ReadIPsFromFeed(l)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		logrus.Debugf("loaded %d ips from feed %s\n", len(ipNets), l.URL)
+
+		sources.Add(ipNets, l.String())
+
+		if l.Expires != "" {
+			var expiry time.Time
+
+			if _, dErr := time.ParseDuration(l.Expires); dErr == nil {
+				return nil, nil, nil, fmt.Errorf("expiry of feed %s must be a timestamp: %s", l.URL, l.Expires)
+			}
+
+			if expiry, err = ParseExpiry(l.Expires, time.Time{}); err != nil {
+				return nil, nil, nil, err
+			}
+
+			expiries.Set(ipNets, expiry)
+		}
+
+		return
+	}
+
 	// if path is a folder, then loop through contents
-	info, err := os.Stat(path)
+	info, err := os.Stat(l.Path)
 	if os.IsNotExist(err) {
 		return
 	}
 
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "failed to read %s", l.Path)
+	}
+
 	if info.IsDir() {
 		var files []fs.FileInfo
 
-		files, err = ioutil.ReadDir(path)
+		files, err = ioutil.ReadDir(l.Path)
 		if err != nil {
 			return
 		}
@@ -208,16 +276,24 @@ func LoadIPsFromPath(path string) (ipNets IPNets, err error) {
 			if !file.IsDir() {
 				var n IPNets
 
-				p := filepath.Join(path, file.Name())
+				fl := l
+				fl.Path = filepath.Join(l.Path, file.Name())
 
-				n, err = ReadIPsFromFile(p)
+				n, err = readIPListPath(fl, file.ModTime(), expiries)
 				if err != nil {
 					return
 				}
 
-				logrus.Printf("loaded %d ips from file %s\n", len(n), p)
+				logrus.Printf("loaded %d ips from file %s\n", len(n), fl.Path)
 
 				ipNets = append(ipNets, n...)
+
+				source := fl.Path
+				if l.Name != "" {
+					source = l.Name
+				}
+
+				sources.Add(n, source)
 			}
 		}
 
@@ -226,45 +302,80 @@ func LoadIPsFromPath(path string) (ipNets IPNets, err error) {
 
 	var n IPNets
 
-	n, err = ReadIPsFromFile(path)
+	n, err = readIPListPath(l, info.ModTime(), expiries)
 	if err != nil {
 		return
 	}
 
-	logrus.Debugf("loaded %d ips from file %s\n", len(n), path)
+	logrus.Debugf("loaded %d ips from file %s\n", len(n), l.Path)
 
 	ipNets = append(ipNets, n...)
+	sources.Add(n, l.String())
 
 	return
 }
 
-// ReadIPsFromFile accepts a file path from which to load IPs (one per line) as strings and return a slice of
+// ReadIPsFromFile accepts a file path from which to load IPv4 and IPv6 addresses, networks or ranges and returns
+// them as a slice of networks. The format of the file is detected from its extension and content.
 func ReadIPsFromFile(fPath string) (ipnets IPNets, err error) {
-	file, err := os.Open(fPath)
+	return ReadIPsFromListPath(IPListPath{Path: fPath})
+}
+
+// ReadIPsFromListPath loads the IPs from a single file in the format declared by the list path, or detected from
+// the file, and returns them as a slice of networks
+func ReadIPsFromListPath(l IPListPath) (ipnets IPNets, err error) {
+	data, err := ioutil.ReadFile(l.Path)
 	if err != nil {
-		log.Fatalf("failed to open")
+		return nil, errors.Wrapf(err, "failed to open %s", l.Path)
 	}
 
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
+	return parseIPListData(l, data, nil)
+}
 
-	var ipnet *net.IPNet
+// readIPListPath behaves as ReadIPsFromListPath but records the expiry of each entry, and of the list path, in
+// expiries. An expiry duration for the list path is added to modified.
+func readIPListPath(l IPListPath, modified time.Time, expiries IPNetExpiries) (ipnets IPNets, err error) {
+	data, err := ioutil.ReadFile(l.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", l.Path)
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "#") {
-			if !strings.Contains(line, "/") {
-				line = line + "/32"
-			}
+	entryExpiries := make(IPNetExpiries)
 
-			_, ipnet, err = net.ParseCIDR(line)
-			if err != nil {
-				return
-			}
+	ipnets, err = parseIPListData(l, data, entryExpiries)
+	if err != nil {
+		return
+	}
 
-			ipnets = append(ipnets, *ipnet)
+	if l.Expires != "" {
+		var expiry time.Time
+
+		expiry, err = ParseExpiry(l.Expires, modified)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.Path, err)
 		}
+
+		expiries.Set(ipnets, expiry)
+	}
+
+	// entries that set their own expiry take precedence over that of the list
+	for k, v := range entryExpiries {
+		expiries[k] = v
 	}
 
 	return
 }
+
+// WriteIPsToFile writes the networks to the file path, one per line, in the format read by ReadIPsFromFile
+func WriteIPsToFile(fPath string, ipns IPNets) error {
+	b, err := FormatIPNets(ipns, IPListFormatText)
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(fPath, b, 0o600); err != nil {
+		return errors.Wrapf(err, "failed to write ips to %s", fPath)
+	}
+
+	return nil
+}
@@ -1,9 +1,7 @@
 package policy
 
 import (
-	"github.com/jonhadfield/carbo"
-	"github.com/jonhadfield/carbo/backup"
-	"reflect"
+	"path/filepath"
 	"testing"
 
 	_ "github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
@@ -12,7 +10,7 @@ import (
 )
 
 func TestMatchExistingPolicyByID(t *testing.T) {
-	wp, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
+	wp, err := LoadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
 	require.NoError(t, err)
 	targetPolicyID := "/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorWebApplicationFirewallPolicies/mypolicyone"
 	found, policy := MatchExistingPolicyByID(targetPolicyID, []WrappedPolicy{wp})
@@ -20,99 +18,15 @@ func TestMatchExistingPolicyByID(t *testing.T) {
 	require.NotNil(t, policy)
 }
 
-func TestGeneratePolicyToRestoreBackupOnly(t *testing.T) {
-	policyTwo, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-two.json")
-	require.NoError(t, err)
-
-	policyTwoStatic, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-two.json")
-	require.NoError(t, err)
-
-	// test that if only backup provided, that backup is returned
-	generatedPolicyOne := backup.GeneratePolicyToRestore(WrappedPolicy{}, policyTwo, backup.RestorePoliciesInput{})
-	require.NotNil(t, generatedPolicyOne)
-	require.True(t, reflect.DeepEqual(generatedPolicyOne.Policy, policyTwoStatic.Policy))
-}
-
-func TestGeneratePolicyToRestoreBackupWithoutOptions(t *testing.T) {
-	policyOne, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
-	require.NoError(t, err)
-	policyTwo, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-two.json")
-	require.NoError(t, err)
-
-	policyTwoStatic, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-two.json")
-	require.NoError(t, err)
-
-	// test that providing two policies without options returns Original with backup rules replacing Original's
-	generatedPolicyTwo := backup.GeneratePolicyToRestore(policyOne, policyTwo, backup.RestorePoliciesInput{})
-	require.NotNil(t, generatedPolicyTwo)
-	require.True(t, reflect.DeepEqual(generatedPolicyTwo.Policy, policyTwoStatic.Policy))
-}
-
-func TestGeneratePolicyToRestoreBackupCustomOnly(t *testing.T) {
-	policyOne, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
-	require.NoError(t, err)
-	policyTwo, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-two.json")
-	require.NoError(t, err)
-
-	policyOneStatic, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
-	require.NoError(t, err)
-	policyTwoStatic, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-two.json")
-	require.NoError(t, err)
-
-	// test that providing two policies (with both different custom rules and managed rules) with option to only replace
-	// custom rules with backup's custom rules
-	generatedPolicyThree := backup.GeneratePolicyToRestore(policyOne, policyTwo, backup.RestorePoliciesInput{
-		CustomRulesOnly: true,
-	})
-
-	require.NotNil(t, generatedPolicyThree)
-	// generated policy's custom rules should be identical to policy two's
-	require.True(t, reflect.DeepEqual(generatedPolicyThree.Policy.CustomRules, policyTwoStatic.Policy.CustomRules))
-	// generated policy's custom rules should be different from policy one's custom rules
-	require.False(t, reflect.DeepEqual(generatedPolicyThree.Policy.CustomRules, policyOneStatic.Policy.CustomRules))
-	// generated policy's managed rules should still be the same as policy one's, i.e. not replaced
-	require.True(t, reflect.DeepEqual(generatedPolicyThree.Policy.ManagedRules, policyOneStatic.Policy.ManagedRules))
-	// generated policy's managed rules should still be different from policy two's
-	require.False(t, reflect.DeepEqual(generatedPolicyThree.Policy.ManagedRules, policyTwoStatic.Policy.ManagedRules))
-}
-
-func TestGeneratePolicyToRestoreBackupManagedOnly(t *testing.T) {
-	policyOne, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
-	require.NoError(t, err)
-	policyTwo, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-two.json")
-	require.NoError(t, err)
-
-	policyOneStatic, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
-	require.NoError(t, err)
-	policyTwoStatic, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-two.json")
-	require.NoError(t, err)
-
-	// test that providing two policies (with both different custom rules and managed rules) with option to only replace
-	// custom rules with backup's custom rules
-	generatedPolicyThree := backup.GeneratePolicyToRestore(policyOne, policyTwo, backup.RestorePoliciesInput{
-		ManagedRulesOnly: true,
-	})
-
-	require.NotNil(t, generatedPolicyThree)
-	// generated policy's custom rules should be identical to policy one's
-	require.True(t, reflect.DeepEqual(generatedPolicyThree.Policy.CustomRules, policyOneStatic.Policy.CustomRules))
-	// generated policy's custom rules should be different from policy two's custom rules
-	require.False(t, reflect.DeepEqual(generatedPolicyThree.Policy.CustomRules, policyTwoStatic.Policy.CustomRules))
-	// generated policy's managed rules should be the same as policy two's, i.e. replaced
-	require.True(t, reflect.DeepEqual(generatedPolicyThree.Policy.ManagedRules, policyTwoStatic.Policy.ManagedRules))
-	// generated policy's managed rules should be different from policy one's
-	require.False(t, reflect.DeepEqual(generatedPolicyThree.Policy.ManagedRules, policyOneStatic.Policy.ManagedRules))
-}
-
 // TestGeneratePolicyPatch compares two policies and checks that the differences match the operations:
 // {"op":"remove","path":"/properties/customRules/rules/0/matchConditions/0/matchValue/1"}
 // {"op":"remove","path":"/properties/customRules/rules/1/matchConditions/0/matchValue/1"}
 // {"op":"replace","path":"/properties/managedRules/managedRuleSets/0/ruleGroupOverrides/0/rules/1/exclusions/0/selector","value":"example"}
 func TestGeneratePolicyPatch(t *testing.T) {
-	pOne, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
+	pOne, err := LoadWrappedPolicyFromFile("testfiles/wrapped-policy-one.json")
 	require.NoError(t, err)
 
-	pTwo, err := carbo.loadWrappedPolicyFromFile("testfiles/wrapped-policy-two.json")
+	pTwo, err := LoadWrappedPolicyFromFile("testfiles/wrapped-policy-two.json")
 	require.NoError(t, err)
 
 	patch, err := GeneratePolicyPatch(GeneratePolicyPatchInput{
@@ -128,3 +42,46 @@ func TestGeneratePolicyPatch(t *testing.T) {
 	require.Equal(t, 0, patch.CustomRuleReplacements)
 	require.Equal(t, 1, patch.ManagedRuleReplacements)
 }
+
+func TestReadIPsFromFileIPv6(t *testing.T) {
+	ipns, err := ReadIPsFromFile("testfiles/ipsets/ipv6-list-one.ipset")
+	require.NoError(t, err)
+	require.Len(t, ipns, 7)
+	require.Equal(t, "2001:db8::1/128", ipns[0].String())
+	require.Equal(t, "2001:db8::1/128", ipns[1].String())
+	require.Equal(t, "fe80::1234/128", ipns[6].String())
+
+	res, removed := AggregateIPNets(ipns)
+	require.Equal(t, 3, removed)
+	require.Equal(t, []string{"2001:db8::1/128", "2001:db8:1::/48", "2001:db8:2::/48", "fe80::1234/128"}, res.toString())
+}
+
+func TestReadIPsFromFileMixed(t *testing.T) {
+	ipns, err := ReadIPsFromFile("testfiles/ipsets/mixed-list-one.ipset")
+	require.NoError(t, err)
+	require.Len(t, ipns, 8)
+	require.Equal(t, "10.0.0.7/32", ipns[2].String())
+	require.Equal(t, "2001:db8::1/128", ipns[4].String())
+	require.Equal(t, "192.0.2.1/32", ipns[6].String())
+
+	res, removed := AggregateIPNets(ipns)
+	require.Equal(t, 3, removed)
+	require.Equal(t, []string{"10.0.0.0/24", "192.0.2.1/32", "2001:db8::/127", "2001:db8::2/128", "2606:4700::/32"}, res.toString())
+
+	crs, _, err := GenCustomRulesFromIPNets(ipns, 1, "Block")
+	require.NoError(t, err)
+	require.Len(t, crs, 1)
+	require.Len(t, *(*crs[0].MatchConditions)[0].MatchValue, 8)
+}
+
+func TestWriteIPsToFile(t *testing.T) {
+	ipns, err := ReadIPsFromFile("testfiles/ipsets/mixed-list-one.ipset")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "dropped.ipset")
+	require.NoError(t, WriteIPsToFile(path, ipns))
+
+	written, err := ReadIPsFromFile(path)
+	require.NoError(t, err)
+	require.Equal(t, ipns.toString(), written.toString())
+}
@@ -0,0 +1,180 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/jonhadfield/carbo/helpers"
+)
+
+// StrippedIPNet records a network, or part of a network, removed because it overlaps a protected network
+type StrippedIPNet struct {
+	Net       string
+	Removed   string
+	Protected string
+}
+
+// splitIPNet returns the two halves of a network, or false if it is a single address
+func splitIPNet(ipn net.IPNet) (lower, upper net.IPNet, ok bool) {
+	ones, bits := ipn.Mask.Size()
+	if ones == bits {
+		return
+	}
+
+	mask := net.CIDRMask(ones+1, bits)
+
+	lowerIP := make(net.IP, len(ipn.IP))
+	copy(lowerIP, ipn.IP)
+
+	upperIP := make(net.IP, len(ipn.IP))
+	copy(upperIP, ipn.IP)
+	upperIP[ones/8] |= 0x80 >> uint(ones%8)
+
+	return net.IPNet{IP: lowerIP, Mask: mask}, net.IPNet{IP: upperIP, Mask: mask}, true
+}
+
+// subtractIPNets returns the networks covering the parts of ipn not within any of the excluded networks
+func subtractIPNets(ipn net.IPNet, excluded IPNets) (res IPNets) {
+	var overlapping bool
+
+	for _, e := range excluded {
+		if ipNetContains(e, ipn) {
+			return nil
+		}
+
+		if ipNetContains(ipn, e) {
+			overlapping = true
+		}
+	}
+
+	if !overlapping {
+		return IPNets{ipn}
+	}
+
+	lower, upper, ok := splitIPNet(ipn)
+	if !ok {
+		return nil
+	}
+
+	res = append(res, subtractIPNets(lower, excluded)...)
+
+	return append(res, subtractIPNets(upper, excluded)...)
+}
+
+// ExcludeProtectedIPNets removes any part of the networks that overlaps a protected network. A network within a
+// protected network is removed entirely, whereas one containing a protected network is replaced by the networks
+// covering the remainder of its range. Each removal is returned as stripped.
+func ExcludeProtectedIPNets(ipns, protected IPNets) (kept IPNets, stripped []StrippedIPNet) {
+	normalisedProtected := make(IPNets, 0, len(protected))
+	for _, p := range protected {
+		normalisedProtected = append(normalisedProtected, normaliseIPNet(p))
+	}
+
+	for _, ipn := range ipns {
+		n := normaliseIPNet(ipn)
+
+		var overlapping IPNets
+
+		for _, p := range normalisedProtected {
+			switch {
+			case ipNetContains(p, n):
+				stripped = append(stripped, StrippedIPNet{Net: n.String(), Removed: n.String(), Protected: p.String()})
+				overlapping = append(overlapping, p)
+			case ipNetContains(n, p):
+				stripped = append(stripped, StrippedIPNet{Net: n.String(), Removed: p.String(), Protected: p.String()})
+				overlapping = append(overlapping, p)
+			}
+		}
+
+		if len(overlapping) == 0 {
+			kept = append(kept, n)
+
+			continue
+		}
+
+		kept = append(kept, subtractIPNets(n, overlapping)...)
+	}
+
+	return
+}
+
+// ReportStrippedIPNets logs each network, or part of a network, removed as it overlaps a protected network
+func ReportStrippedIPNets(stripped []StrippedIPNet, action string) {
+	for _, s := range stripped {
+		if s.Net == s.Removed {
+			log.Printf("stripped %s from %s list as it is within protected network %s\n", s.Net, strings.ToLower(action), s.Protected)
+
+			continue
+		}
+
+		log.Printf("stripped %s from %s in %s list as it is protected\n", s.Removed, s.Net, strings.ToLower(action))
+	}
+}
+
+// protectedActions are the actions that must never include protected networks
+var protectedActions = []string{"Block", "Log", "Redirect"}
+
+// applyProtectedIPNets strips protected networks from those to be applied for the action, retaining the sources of
+// any networks that are reduced. An error is returned instead if reject is set and protected networks are found.
+func applyProtectedIPNets(input *ApplyIPsInput) error {
+	if !helpers.StringInSlice(input.Action, protectedActions, true) {
+		return nil
+	}
+
+	if input.ProtectedPath != "" {
+		protected, err := LoadIPsFromPath(input.ProtectedPath)
+		if err != nil {
+			return err
+		}
+
+		input.Protected = append(input.Protected, protected...)
+	}
+
+	if len(input.Protected) == 0 {
+		return nil
+	}
+
+	kept, stripped := ExcludeProtectedIPNets(input.Nets, input.Protected)
+	if len(stripped) == 0 {
+		return nil
+	}
+
+	ReportStrippedIPNets(stripped, input.Action)
+
+	if input.RejectProtected {
+		return fmt.Errorf("%d networks in %s list overlap protected networks", len(stripped), strings.ToLower(input.Action))
+	}
+
+	retainStrippedSources(input.Sources, stripped, kept)
+
+	input.Nets = kept
+
+	return nil
+}
+
+// retainStrippedSources records the sources of each network replaced by its remainder against the kept networks
+// within it
+func retainStrippedSources(sources IPNetSources, stripped []StrippedIPNet, kept IPNets) {
+	for _, s := range stripped {
+		if s.Net == s.Removed {
+			continue
+		}
+
+		_, original, err := net.ParseCIDR(s.Net)
+		if err != nil {
+			continue
+		}
+
+		for _, k := range kept {
+			if !ipNetContains(*original, k) {
+				continue
+			}
+
+			for _, source := range sources.Get(*original) {
+				sources.Add(IPNets{k}, source)
+			}
+		}
+	}
+}
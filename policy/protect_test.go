@@ -0,0 +1,57 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExcludeProtectedIPNets(t *testing.T) {
+	protected := parseIPNets(t, "10.0.0.0/24", "192.168.1.128/25", "2001:db8::/64")
+
+	kept, stripped := ExcludeProtectedIPNets(parseIPNets(t, "10.0.0.5/32", "192.168.1.0/24", "172.16.0.1/32", "2001:db8::1/128"), protected)
+	require.Equal(t, []string{"192.168.1.0/25", "172.16.0.1/32"}, kept.toString())
+	require.Equal(t, []StrippedIPNet{
+		{Net: "10.0.0.5/32", Removed: "10.0.0.5/32", Protected: "10.0.0.0/24"},
+		{Net: "192.168.1.0/24", Removed: "192.168.1.128/25", Protected: "192.168.1.128/25"},
+		{Net: "2001:db8::1/128", Removed: "2001:db8::1/128", Protected: "2001:db8::/64"},
+	}, stripped)
+
+	// a protected network within a larger range leaves the smallest set of networks covering the remainder
+	kept, stripped = ExcludeProtectedIPNets(parseIPNets(t, "10.0.0.0/22"), parseIPNets(t, "10.0.1.0/24"))
+	require.Equal(t, []string{"10.0.0.0/24", "10.0.2.0/23"}, kept.toString())
+	require.Len(t, stripped, 1)
+}
+
+func TestApplyProtectedIPNets(t *testing.T) {
+	input := ApplyIPsInput{
+		Action:    "Block",
+		Nets:      parseIPNets(t, "10.0.0.0/23", "10.0.0.1/32"),
+		Sources:   make(IPNetSources),
+		Protected: parseIPNets(t, "10.0.0.0/24"),
+	}
+	input.Sources.Add(input.Nets, "feed.ipset")
+
+	require.NoError(t, applyProtectedIPNets(&input))
+	require.Equal(t, []string{"10.0.1.0/24"}, input.Nets.toString())
+	require.Equal(t, []string{"feed.ipset"}, input.Sources.Get(input.Nets[0]))
+
+	// allow lists are not restricted by protected networks
+	input = ApplyIPsInput{
+		Action:    "Allow",
+		Nets:      parseIPNets(t, "10.0.0.1/32"),
+		Protected: parseIPNets(t, "10.0.0.0/24"),
+	}
+	require.NoError(t, applyProtectedIPNets(&input))
+	require.Len(t, input.Nets, 1)
+
+	input = ApplyIPsInput{
+		Action:          "Log",
+		Nets:            parseIPNets(t, "10.0.0.1/32"),
+		Protected:       parseIPNets(t, "10.0.0.0/24"),
+		RejectProtected: true,
+	}
+	err := applyProtectedIPNets(&input)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "protected")
+}
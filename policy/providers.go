@@ -0,0 +1,230 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jonhadfield/carbo/helpers"
+)
+
+const (
+	// IPListFormatAzureServiceTags is the Azure IP Ranges and Service Tags json document
+	IPListFormatAzureServiceTags = "azure-service-tags"
+	// IPListFormatAWS is the AWS ip-ranges.json document
+	IPListFormatAWS = "aws"
+	// IPListFormatGCP is the Google Cloud cloud.json document
+	IPListFormatGCP = "gcp"
+	// IPListFormatCloudflare is either Cloudflare's plain text ips-v4 and ips-v6 lists, or the json returned by its
+	// ips api
+	IPListFormatCloudflare = "cloudflare"
+)
+
+// providerIPListFormats are the formats of documents published by cloud providers, that support filtering
+var providerIPListFormats = []string{IPListFormatAzureServiceTags, IPListFormatAWS, IPListFormatGCP, IPListFormatCloudflare}
+
+// providerPrefix is a published prefix along with the attributes it can be filtered by
+type providerPrefix struct {
+	prefix  string
+	service string
+	region  string
+	tag     string
+}
+
+type azureServiceTags struct {
+	Values []struct {
+		Name       string `json:"name"`
+		Properties struct {
+			Region          string   `json:"region"`
+			SystemService   string   `json:"systemService"`
+			AddressPrefixes []string `json:"addressPrefixes"`
+		} `json:"properties"`
+	} `json:"values"`
+}
+
+type awsIPRanges struct {
+	Prefixes []struct {
+		IPPrefix string `json:"ip_prefix"`
+		Region   string `json:"region"`
+		Service  string `json:"service"`
+	} `json:"prefixes"`
+	IPv6Prefixes []struct {
+		IPv6Prefix string `json:"ipv6_prefix"`
+		Region     string `json:"region"`
+		Service    string `json:"service"`
+	} `json:"ipv6_prefixes"`
+}
+
+type gcpCloud struct {
+	Prefixes []struct {
+		IPv4Prefix string `json:"ipv4Prefix"`
+		IPv6Prefix string `json:"ipv6Prefix"`
+		Service    string `json:"service"`
+		Scope      string `json:"scope"`
+	} `json:"prefixes"`
+}
+
+type cloudflareIPs struct {
+	Result struct {
+		IPv4CIDRs []string `json:"ipv4_cidrs"`
+		IPv6CIDRs []string `json:"ipv6_cidrs"`
+	} `json:"result"`
+}
+
+// hasIPListFilters returns true if the list path restricts the prefixes loaded by service, region or tag
+func hasIPListFilters(l IPListPath) bool {
+	return len(l.Services) > 0 || len(l.Regions) > 0 || len(l.Tags) > 0
+}
+
+// matchesIPListFilter returns true if no filter values are specified, or the value matches one of them
+func matchesIPListFilter(value string, filter []string) bool {
+	return len(filter) == 0 || helpers.StringInSlice(value, filter, true)
+}
+
+// parseProviderIPList reads the prefixes from a document published by a cloud provider and returns those matching
+// the services, regions and tags of the list path
+func parseProviderIPList(l IPListPath, format string, data []byte) (ipns IPNets, err error) {
+	var prefixes []providerPrefix
+
+	switch format {
+	case IPListFormatAzureServiceTags:
+		prefixes, err = azureServiceTagPrefixes(data)
+	case IPListFormatAWS:
+		prefixes, err = awsPrefixes(data)
+	case IPListFormatGCP:
+		prefixes, err = gcpPrefixes(data)
+	case IPListFormatCloudflare:
+		if hasIPListFilters(l) {
+			return nil, fmt.Errorf("%s: cloudflare ip lists cannot be filtered", l.Path)
+		}
+
+		// errors already identify the file
+		if prefixes, err = cloudflarePrefixes(l, data); err != nil {
+			return
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", l.Path, err)
+	}
+
+	if len(l.Tags) > 0 && format != IPListFormatAzureServiceTags {
+		return nil, fmt.Errorf("%s: tags can only be used to filter azure service tags", l.Path)
+	}
+
+	for _, p := range prefixes {
+		if !matchesIPListFilter(p.service, l.Services) || !matchesIPListFilter(p.region, l.Regions) ||
+			!matchesIPListFilter(p.tag, l.Tags) {
+			continue
+		}
+
+		var n IPNets
+
+		n, err = ParseIPNetOrRange(p.prefix)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.Path, err)
+		}
+
+		ipns = append(ipns, n...)
+	}
+
+	return
+}
+
+// azureServiceTagPrefixes returns the prefixes of each service tag, where the tag is its name, e.g. AzureFrontDoor.Backend
+func azureServiceTagPrefixes(data []byte) (prefixes []providerPrefix, err error) {
+	var doc azureServiceTags
+
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid azure service tags: %w", err)
+	}
+
+	for _, v := range doc.Values {
+		for _, p := range v.Properties.AddressPrefixes {
+			prefixes = append(prefixes, providerPrefix{
+				prefix:  p,
+				service: v.Properties.SystemService,
+				region:  v.Properties.Region,
+				tag:     v.Name,
+			})
+		}
+	}
+
+	return
+}
+
+// awsPrefixes returns the IPv4 and IPv6 prefixes from AWS ip-ranges.json
+func awsPrefixes(data []byte) (prefixes []providerPrefix, err error) {
+	var doc awsIPRanges
+
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid aws ip ranges: %w", err)
+	}
+
+	for _, p := range doc.Prefixes {
+		prefixes = append(prefixes, providerPrefix{prefix: p.IPPrefix, service: p.Service, region: p.Region})
+	}
+
+	for _, p := range doc.IPv6Prefixes {
+		prefixes = append(prefixes, providerPrefix{prefix: p.IPv6Prefix, service: p.Service, region: p.Region})
+	}
+
+	return
+}
+
+// gcpPrefixes returns the IPv4 and IPv6 prefixes from Google Cloud's cloud.json, where the region is the scope
+func gcpPrefixes(data []byte) (prefixes []providerPrefix, err error) {
+	var doc gcpCloud
+
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid gcp cloud ranges: %w", err)
+	}
+
+	for _, p := range doc.Prefixes {
+		prefix := p.IPv4Prefix
+		if prefix == "" {
+			prefix = p.IPv6Prefix
+		}
+
+		if prefix == "" {
+			continue
+		}
+
+		prefixes = append(prefixes, providerPrefix{prefix: prefix, service: p.Service, region: p.Scope})
+	}
+
+	return
+}
+
+// cloudflarePrefixes returns the prefixes from either the json returned by Cloudflare's ips api, or its plain text
+// lists
+func cloudflarePrefixes(l IPListPath, data []byte) (prefixes []providerPrefix, err error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		var ipns IPNets
+
+		ipns, err = parseTextIPList(l.Path, bytes.NewReader(data), nil)
+		if err != nil {
+			return
+		}
+
+		for _, ipn := range ipns {
+			prefixes = append(prefixes, providerPrefix{prefix: ipn.String()})
+		}
+
+		return
+	}
+
+	var doc cloudflareIPs
+
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: invalid cloudflare ips: %w", l.Path, err)
+	}
+
+	for _, p := range append(doc.Result.IPv4CIDRs, doc.Result.IPv6CIDRs...) {
+		prefixes = append(prefixes, providerPrefix{prefix: strings.TrimSpace(p)})
+	}
+
+	return
+}
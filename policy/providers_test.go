@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadIPsFromAzureServiceTags(t *testing.T) {
+	path := "testfiles/providers/azure-service-tags.json"
+
+	ipns, err := ReadIPsFromListPath(IPListPath{Path: path, Format: IPListFormatAzureServiceTags})
+	require.NoError(t, err)
+	require.Len(t, ipns, 6)
+
+	ipns, err = ReadIPsFromListPath(IPListPath{Path: path, Format: IPListFormatAzureServiceTags, Tags: []string{"azurefrontdoor.backend"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"13.73.248.8/29", "20.21.37.40/29", "2603:1030:21:1::/64"}, ipns.toString())
+
+	ipns, err = ReadIPsFromListPath(IPListPath{Path: path, Format: IPListFormatAzureServiceTags, Regions: []string{"westeurope"}, Services: []string{"AzureStorage"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"13.69.64.0/23"}, ipns.toString())
+}
+
+func TestReadIPsFromAWSIPRanges(t *testing.T) {
+	path := "testfiles/providers/aws-ip-ranges.json"
+
+	ipns, err := ReadIPsFromListPath(IPListPath{Path: path, Format: IPListFormatAWS, Services: []string{"CLOUDFRONT"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"13.32.0.0/15", "52.46.0.0/18", "2600:9000::/28"}, ipns.toString())
+
+	ipns, err = ReadIPsFromListPath(IPListPath{Path: path, Format: IPListFormatAWS, Regions: []string{"us-west-2"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"2600:1f14:fff:f800::/56"}, ipns.toString())
+
+	_, err = ReadIPsFromListPath(IPListPath{Path: path, Format: IPListFormatAWS, Tags: []string{"EC2"}})
+	require.Error(t, err)
+}
+
+func TestReadIPsFromGCPCloud(t *testing.T) {
+	ipns, err := ReadIPsFromListPath(IPListPath{Path: "testfiles/providers/gcp-cloud.json", Format: IPListFormatGCP, Regions: []string{"europe-west1"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"34.76.0.0/14", "2600:1900:4010::/44"}, ipns.toString())
+}
+
+func TestReadIPsFromCloudflare(t *testing.T) {
+	ipns, err := ReadIPsFromListPath(IPListPath{Path: "testfiles/providers/cloudflare-ips-v4", Format: IPListFormatCloudflare})
+	require.NoError(t, err)
+	require.Len(t, ipns, 3)
+
+	ipns, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/providers/cloudflare-ips.json", Format: IPListFormatCloudflare})
+	require.NoError(t, err)
+	require.Equal(t, []string{"173.245.48.0/20", "103.21.244.0/22", "2400:cb00::/32"}, ipns.toString())
+
+	_, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/providers/cloudflare-ips.json", Format: IPListFormatCloudflare, Regions: []string{"eu"}})
+	require.Error(t, err)
+}
+
+func TestReadIPsFilterRequiresProviderFormat(t *testing.T) {
+	_, err := ReadIPsFromListPath(IPListPath{Path: "testfiles/ipsets/allow-list-one.ipset", Services: []string{"EC2"}})
+	require.Error(t, err)
+}
+
+func TestLoadActionsFromPathWithProviders(t *testing.T) {
+	as, err := LoadActionsFromPath("testfiles/actions-providers.yaml")
+	require.NoError(t, err)
+	require.Len(t, as, 1)
+
+	fileNets, err := LoadIPsFromPath("testfiles/ipsets/allow-list-one.ipset")
+	require.NoError(t, err)
+
+	nets := as[0].Nets.toString()
+	require.Contains(t, nets, "13.32.0.0/15")
+	require.Contains(t, nets, "2600:9000::/28")
+	require.Contains(t, nets, "13.73.128.0/18")
+	require.NotContains(t, nets, "3.5.140.0/22")
+	// the storage prefix is excluded from the west europe range containing it
+	require.NotContains(t, nets, "13.69.0.0/17")
+	require.Contains(t, nets, "13.69.66.0/23")
+	require.Equal(t, []string{"testfiles/providers/azure-service-tags.json"}, as[0].Sources["13.69.66.0/23"])
+
+	require.Len(t, nets, len(fileNets)+3+3+7)
+}
@@ -0,0 +1,466 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/alexeyco/simpletable"
+	"github.com/gookit/color"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/jonhadfield/carbo/session"
+)
+
+// rateLimitDurations are the windows, in minutes, that Front Door counts requests over
+var rateLimitDurations = []int{1, 5}
+
+// rateLimitName matches the names that can be used in a custom rule name
+var rateLimitName = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// RateLimit limits the requests each client can make to a path and/or host within a duration, optionally only for
+// clients with addresses within the listed networks
+type RateLimit struct {
+	Name string `yaml:"name" json:"name"`
+	// Path limits requests with a path beginning with it, ignoring case, as for the paths of a RuleScope
+	Path string `yaml:"path" json:"path,omitempty"`
+	// Host limits requests with a Host header equal to it
+	Host      string `yaml:"host" json:"host,omitempty"`
+	Threshold int    `yaml:"threshold" json:"threshold"`
+	// Duration is the number of minutes requests are counted over, either 1 or 5
+	Duration int `yaml:"duration" json:"duration"`
+	// Action is taken once the threshold is exceeded, either block, log or redirect
+	Action string   `yaml:"action" json:"action"`
+	IPs    []string `yaml:"ips" json:"ips,omitempty"`
+}
+
+// RateLimitChange is a rate limit before and after it is changed
+type RateLimitChange struct {
+	Before RateLimit `json:"before"`
+	After  RateLimit `json:"after"`
+}
+
+// RateLimitChangeSummary describes the rate limits created, changed and deleted in a policy
+type RateLimitChangeSummary struct {
+	PolicyID string            `json:"policy_id"`
+	DryRun   bool              `json:"dry_run"`
+	Created  []RateLimit       `json:"created"`
+	Changed  []RateLimitChange `json:"changed"`
+	Deleted  []RateLimit       `json:"deleted"`
+}
+
+// ApplyRateLimitsInput specifies the rate limits to create or replace, by name, and those to remove. If Replace is set,
+// any rate limits managed by carbo that are not provided are removed.
+type ApplyRateLimitsInput struct {
+	RID        ResourceID
+	RateLimits []RateLimit
+	Remove     []string
+	Replace    bool
+	Output     bool
+	DryRun     bool
+	// OutputFormat is the format the rate limits changed are output in: table, json, yaml or csv
+	OutputFormat string
+}
+
+// NormaliseRateLimit checks the rate limit can be applied and returns it with defaults set: a duration of one minute
+// and an action of block
+func NormaliseRateLimit(rl RateLimit) (RateLimit, error) {
+	if !rateLimitName.MatchString(rl.Name) {
+		return rl, fmt.Errorf("rate limit name must only contain letters and numbers: '%s'", rl.Name)
+	}
+
+	rl.Path = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(rl.Path), "*"))
+	rl.Host = strings.ToLower(strings.TrimSpace(rl.Host))
+
+	if rl.Path == "" && rl.Host == "" {
+		return rl, fmt.Errorf("rate limit %s requires a path or host", rl.Name)
+	}
+
+	if rl.Path != "" && !strings.HasPrefix(rl.Path, "/") {
+		return rl, fmt.Errorf("rate limit %s path must begin with /: '%s'", rl.Name, rl.Path)
+	}
+
+	if rl.Threshold < 1 {
+		return rl, fmt.Errorf("rate limit %s requires a threshold of at least 1", rl.Name)
+	}
+
+	if rl.Duration == 0 {
+		rl.Duration = rateLimitDurations[0]
+	}
+
+	var validDuration bool
+
+	for _, d := range rateLimitDurations {
+		if rl.Duration == d {
+			validDuration = true
+		}
+	}
+
+	if !validDuration {
+		return rl, fmt.Errorf("rate limit %s has invalid duration %d: must be 1 or 5 minutes", rl.Name, rl.Duration)
+	}
+
+	switch strings.ToLower(rl.Action) {
+	case "", "block":
+		rl.Action = "Block"
+	case "log":
+		rl.Action = "Log"
+	case "redirect":
+		rl.Action = "Redirect"
+	default:
+		return rl, fmt.Errorf("rate limit %s has unsupported action '%s': must be block, log or redirect", rl.Name, rl.Action)
+	}
+
+	ips := make([]string, 0, len(rl.IPs))
+
+	for _, ip := range rl.IPs {
+		ipn, err := ParseIPNet(ip)
+		if err != nil {
+			return rl, fmt.Errorf("rate limit %s has invalid ip: %s", rl.Name, ip)
+		}
+
+		ips = append(ips, ipn.String())
+	}
+
+	rl.IPs = nil
+	if len(ips) > 0 {
+		rl.IPs = ips
+	}
+
+	return rl, nil
+}
+
+// createRateLimitCustomRule will return a frontdoor RateLimitRule constructed from the rate limit
+func createRateLimitCustomRule(rl RateLimit, priority int32) frontdoor.CustomRule {
+	f := false
+
+	name := helpers.RateLimitPrefix + rl.Name
+	threshold := int32(rl.Threshold)
+	duration := int32(rl.Duration)
+
+	var mcs []frontdoor.MatchCondition
+
+	if rl.Path != "" {
+		mcs = append(mcs, frontdoor.MatchCondition{
+			MatchVariable:   "RequestUri",
+			NegateCondition: &f,
+			Operator:        "RegEx",
+			MatchValue:      &[]string{scopePathRegex(rl.Path)},
+			Transforms:      &[]frontdoor.TransformType{"Lowercase"},
+		})
+	}
+
+	if rl.Host != "" {
+		selector := "Host"
+
+		mcs = append(mcs, frontdoor.MatchCondition{
+			MatchVariable:   "RequestHeader",
+			Selector:        &selector,
+			NegateCondition: &f,
+			Operator:        "Equal",
+			MatchValue:      &[]string{rl.Host},
+			Transforms:      &[]frontdoor.TransformType{"Lowercase"},
+		})
+	}
+
+	if len(rl.IPs) > 0 {
+		ips := append([]string{}, rl.IPs...)
+
+		mcs = append(mcs, frontdoor.MatchCondition{
+			MatchVariable:   "RemoteAddr",
+			NegateCondition: &f,
+			Operator:        "IPMatch",
+			MatchValue:      &ips,
+			Transforms:      &[]frontdoor.TransformType{},
+		})
+	}
+
+	return frontdoor.CustomRule{
+		Name:                       &name,
+		Priority:                   &priority,
+		EnabledState:               "Enabled",
+		RuleType:                   "RateLimitRule",
+		RateLimitDurationInMinutes: &duration,
+		RateLimitThreshold:         &threshold,
+		MatchConditions:            &mcs,
+		Action:                     frontdoor.ActionType(rl.Action),
+	}
+}
+
+// rateLimitFromCustomRule returns the rate limit represented by a custom rule generated by carbo
+func rateLimitFromCustomRule(cr frontdoor.CustomRule) (rl RateLimit) {
+	rl.Name = strings.TrimPrefix(*cr.Name, helpers.RateLimitPrefix)
+	rl.Action = string(cr.Action)
+
+	if cr.RateLimitThreshold != nil {
+		rl.Threshold = int(*cr.RateLimitThreshold)
+	}
+
+	if cr.RateLimitDurationInMinutes != nil {
+		rl.Duration = int(*cr.RateLimitDurationInMinutes)
+	}
+
+	if cr.MatchConditions == nil {
+		return
+	}
+
+	for _, mc := range *cr.MatchConditions {
+		if mc.MatchValue == nil || len(*mc.MatchValue) == 0 {
+			continue
+		}
+
+		switch {
+		case mc.MatchVariable == "RequestUri":
+			rl.Path = (*mc.MatchValue)[0]
+
+			// rules generated before paths were matched as prefixes contain the path itself
+			if p, ok := scopePathFromRegex(rl.Path); ok && mc.Operator == "RegEx" {
+				rl.Path = p
+			}
+		case mc.MatchVariable == "RequestHeader" && mc.Selector != nil && strings.EqualFold(*mc.Selector, "Host"):
+			rl.Host = (*mc.MatchValue)[0]
+		case mc.MatchVariable == "RemoteAddr" && mc.Operator == "IPMatch":
+			rl.IPs = append(rl.IPs, *mc.MatchValue...)
+		}
+	}
+
+	return
+}
+
+// customRuleRateLimitKey returns a value that differs between rules if their priority or rate limit differ
+func customRuleRateLimitKey(cr frontdoor.CustomRule) string {
+	var priority int32
+	if cr.Priority != nil {
+		priority = *cr.Priority
+	}
+
+	b, _ := json.Marshal(rateLimitFromCustomRule(cr))
+
+	return fmt.Sprintf("%d|%s", priority, b)
+}
+
+// GenRateLimitCustomRules returns the rate limit rules resulting from creating or replacing the rate limits, by name,
+// and removing those named in remove. Existing rules keep their priority, with new rules given the lowest unused
+// priority in the rate limit range. If replace is set, existing rules not provided are removed.
+func GenRateLimitCustomRules(rls []RateLimit, existing []frontdoor.CustomRule, remove []string, replace bool) (crs []frontdoor.CustomRule, err error) {
+	priorities := make(map[string]int32)
+	used := make(map[int32]bool)
+
+	rules := make(map[string]frontdoor.CustomRule)
+
+	for _, cr := range existing {
+		priorities[*cr.Name] = *cr.Priority
+		used[*cr.Priority] = true
+
+		if !replace {
+			rules[*cr.Name] = cr
+		}
+	}
+
+	for _, name := range remove {
+		ruleName := helpers.RateLimitPrefix + name
+		if _, ok := priorities[ruleName]; !ok {
+			return nil, fmt.Errorf("rate limit %s not found", name)
+		}
+
+		delete(rules, ruleName)
+	}
+
+	seen := make(map[string]bool)
+
+	nextPriority := int32(helpers.RateLimitPriorityStart)
+
+	for _, rl := range rls {
+		rl, err = NormaliseRateLimit(rl)
+		if err != nil {
+			return nil, err
+		}
+
+		ruleName := helpers.RateLimitPrefix + rl.Name
+
+		if seen[ruleName] {
+			return nil, fmt.Errorf("rate limit %s specified more than once", rl.Name)
+		}
+
+		seen[ruleName] = true
+
+		priority, ok := priorities[ruleName]
+		if !ok {
+			for used[nextPriority] {
+				nextPriority++
+			}
+
+			priority = nextPriority
+			used[priority] = true
+		}
+
+		rules[ruleName] = createRateLimitCustomRule(rl, priority)
+	}
+
+	for _, cr := range rules {
+		crs = append(crs, cr)
+	}
+
+	helpers.SortRules(crs)
+
+	return
+}
+
+// SummariseRateLimitChanges compares the rate limit rules before and after they are regenerated
+func SummariseRateLimitChanges(existing, generated []frontdoor.CustomRule) (summary RateLimitChangeSummary) {
+	// empty rather than null when output as json
+	summary.Created, summary.Changed, summary.Deleted = []RateLimit{}, []RateLimitChange{}, []RateLimit{}
+
+	created, changed, deleted := diffCustomRules(existing, generated, customRuleRateLimitKey)
+
+	rules := make(map[string]frontdoor.CustomRule)
+	for _, cr := range generated {
+		rules[*cr.Name] = cr
+	}
+
+	before := make(map[string]frontdoor.CustomRule)
+	for _, cr := range existing {
+		before[*cr.Name] = cr
+	}
+
+	for _, name := range created {
+		summary.Created = append(summary.Created, rateLimitFromCustomRule(rules[name]))
+	}
+
+	for _, name := range changed {
+		summary.Changed = append(summary.Changed, RateLimitChange{
+			Before: rateLimitFromCustomRule(before[name]),
+			After:  rateLimitFromCustomRule(rules[name]),
+		})
+	}
+
+	for _, name := range deleted {
+		summary.Deleted = append(summary.Deleted, rateLimitFromCustomRule(before[name]))
+	}
+
+	return
+}
+
+// rateLimitCells returns the table cells describing a rate limit
+func rateLimitCells(change string, rl RateLimit) []*simpletable.Cell {
+	return []*simpletable.Cell{
+		{Text: change},
+		{Text: rl.Name},
+		{Text: rl.Path},
+		{Text: rl.Host},
+		{Align: simpletable.AlignRight, Text: strconv.Itoa(rl.Threshold)},
+		{Align: simpletable.AlignRight, Text: strconv.Itoa(rl.Duration)},
+		{Text: rl.Action},
+		{Text: strings.Join(rl.IPs, ", ")},
+	}
+}
+
+// ShowRateLimitChangeSummary outputs the summary in the requested format: table, json, yaml or csv
+func ShowRateLimitChangeSummary(summary RateLimitChangeSummary, format string) error {
+	return Render(summary, format)
+}
+
+// RenderTable outputs the number of rate limits created, changed and deleted, followed by a table of them
+func (summary RateLimitChangeSummary) RenderTable() {
+	verb := "are"
+	if summary.DryRun {
+		verb = "would be"
+	}
+
+	fmt.Printf("%d rate limits %s created, %d changed and %d deleted\n",
+		len(summary.Created), verb, len(summary.Changed), len(summary.Deleted))
+
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Change")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Name")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Path")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Host")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Threshold")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Minutes")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Action")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("IPs")},
+		},
+	}
+
+	for _, rl := range summary.Created {
+		table.Body.Cells = append(table.Body.Cells, rateLimitCells(color.HiGreen.Sprint("created"), rl))
+	}
+
+	for _, c := range summary.Changed {
+		table.Body.Cells = append(table.Body.Cells,
+			rateLimitCells(color.HiYellow.Sprint("changed from"), c.Before),
+			rateLimitCells(color.HiYellow.Sprint("changed to"), c.After))
+	}
+
+	for _, rl := range summary.Deleted {
+		table.Body.Cells = append(table.Body.Cells, rateLimitCells(color.HiRed.Sprint("deleted"), rl))
+	}
+
+	if len(table.Body.Cells) > 0 {
+		table.SetStyle(simpletable.StyleRounded)
+
+		table.Println()
+	}
+}
+
+// rateLimitRecord returns the csv row describing a rate limit
+func rateLimitRecord(change string, rl RateLimit) []string {
+	return []string{
+		change, rl.Name, rl.Path, rl.Host, strconv.Itoa(rl.Threshold), strconv.Itoa(rl.Duration), rl.Action,
+		strings.Join(rl.IPs, " "),
+	}
+}
+
+// CSVRecords returns a row per rate limit created and deleted, and two per rate limit changed, with columns change,
+// name, path, host, threshold, duration, action and ips. Multiple ips are separated by spaces.
+func (summary RateLimitChangeSummary) CSVRecords() [][]string {
+	records := [][]string{{"change", "name", "path", "host", "threshold", "duration", "action", "ips"}}
+
+	for _, rl := range summary.Created {
+		records = append(records, rateLimitRecord("created", rl))
+	}
+
+	for _, c := range summary.Changed {
+		records = append(records, rateLimitRecord("changed from", c.Before), rateLimitRecord("changed to", c.After))
+	}
+
+	for _, rl := range summary.Deleted {
+		records = append(records, rateLimitRecord("deleted", rl))
+	}
+
+	return records
+}
+
+// ApplyRateLimits creates, replaces and removes the rate limit rules managed by carbo in a policy
+func ApplyRateLimits(input ApplyRateLimitsInput) error {
+	s := session.Session{}
+
+	return applyRateLimits(&s, input)
+}
+
+func applyRateLimits(s *session.Session, input ApplyRateLimitsInput) (err error) {
+	if len(input.RateLimits) == 0 && len(input.Remove) == 0 && !input.Replace {
+		return fmt.Errorf("no rate limits to set or remove")
+	}
+
+	return applyManagedRules(s, managedRulesInput{
+		RID:    input.RID,
+		Prefix: helpers.RateLimitPrefix,
+		Output: input.Output,
+		DryRun: input.DryRun,
+		generate: func(existing []frontdoor.CustomRule) ([]frontdoor.CustomRule, error) {
+			return GenRateLimitCustomRules(input.RateLimits, existing, input.Remove, input.Replace)
+		},
+		show: func(existing, generated []frontdoor.CustomRule) error {
+			summary := SummariseRateLimitChanges(existing, generated)
+			summary.PolicyID = input.RID.Raw
+			summary.DryRun = input.DryRun
+
+			return ShowRateLimitChangeSummary(summary, input.OutputFormat)
+		},
+	})
+}
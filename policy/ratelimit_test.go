@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormaliseRateLimit(t *testing.T) {
+	rl, err := NormaliseRateLimit(RateLimit{Name: "login", Host: " API.example.com", Threshold: 10, IPs: []string{"192.0.2.1"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, rl.Duration)
+	require.Equal(t, "Block", rl.Action)
+	require.Equal(t, "api.example.com", rl.Host)
+	require.Equal(t, []string{"192.0.2.1/32"}, rl.IPs)
+
+	rl, err = NormaliseRateLimit(RateLimit{Name: "login", Path: "/Login*", Threshold: 10, Action: "redirect"})
+	require.NoError(t, err)
+	require.Equal(t, "Redirect", rl.Action)
+	require.Equal(t, "/login", rl.Path)
+
+	for _, invalid := range []RateLimit{
+		{Name: "log-in", Path: "/login", Threshold: 10},
+		{Name: "login", Threshold: 10},
+		{Name: "login", Path: "/login"},
+		{Name: "login", Path: "/login", Threshold: 10, Duration: 2},
+		{Name: "login", Path: "/login", Threshold: 10, Action: "allow"},
+		{Name: "login", Path: "/login", Threshold: 10, IPs: []string{"192.0.2.300"}},
+		{Name: "login", Path: "login", Threshold: 10},
+	} {
+		_, err = NormaliseRateLimit(invalid)
+		require.Error(t, err)
+	}
+}
+
+func TestGenRateLimitCustomRules(t *testing.T) {
+	existing, err := GenRateLimitCustomRules([]RateLimit{
+		{Name: "login", Path: "/api/login", Threshold: 100},
+		{Name: "search", Host: "api.example.com", Threshold: 1000, Duration: 5, IPs: []string{"198.51.100.0/24"}},
+	}, nil, nil, false)
+	require.NoError(t, err)
+	require.Len(t, existing, 2)
+	require.Equal(t, "RateLimitlogin", *existing[0].Name)
+	require.Equal(t, int32(helpers.RateLimitPriorityStart), *existing[0].Priority)
+	require.Equal(t, "RateLimitRule", string(existing[0].RuleType))
+	require.Equal(t, int32(100), *existing[0].RateLimitThreshold)
+	require.Len(t, *existing[1].MatchConditions, 2)
+
+	// paths are matched as prefixes, as for the paths of a rule scope
+	mc := (*existing[0].MatchConditions)[0]
+	require.Equal(t, "RegEx", string(mc.Operator))
+	require.Equal(t, []frontdoor.TransformType{"Lowercase"}, *mc.Transforms)
+	require.Equal(t, []string{scopePathRegex("/api/login")}, *mc.MatchValue)
+	require.Equal(t, "/api/login", rateLimitFromCustomRule(existing[0]).Path)
+
+	rl := rateLimitFromCustomRule(existing[1])
+	require.Equal(t, "search", rl.Name)
+	require.Equal(t, "api.example.com", rl.Host)
+	require.Equal(t, 5, rl.Duration)
+	require.Equal(t, []string{"198.51.100.0/24"}, rl.IPs)
+
+	// replacing one rate limit and adding another keeps existing priorities
+	generated, err := GenRateLimitCustomRules([]RateLimit{
+		{Name: "search", Host: "api.example.com", Threshold: 500},
+		{Name: "upload", Path: "/upload", Threshold: 5},
+	}, existing, []string{"login"}, false)
+	require.NoError(t, err)
+	require.Len(t, generated, 2)
+	require.Equal(t, "RateLimitsearch", *generated[0].Name)
+	require.Equal(t, int32(helpers.RateLimitPriorityStart+1), *generated[0].Priority)
+	require.Equal(t, "RateLimitupload", *generated[1].Name)
+	require.Equal(t, int32(helpers.RateLimitPriorityStart+2), *generated[1].Priority)
+
+	summary := SummariseRateLimitChanges(existing, generated)
+	require.Len(t, summary.Created, 1)
+	require.Equal(t, "upload", summary.Created[0].Name)
+	require.Len(t, summary.Changed, 1)
+	require.Equal(t, 1000, summary.Changed[0].Before.Threshold)
+	require.Equal(t, 500, summary.Changed[0].After.Threshold)
+	require.Len(t, summary.Deleted, 1)
+	require.Equal(t, "login", summary.Deleted[0].Name)
+
+	// replacing removes rate limits not provided
+	generated, err = GenRateLimitCustomRules([]RateLimit{{Name: "upload", Path: "/upload", Threshold: 5}}, existing, nil, true)
+	require.NoError(t, err)
+	require.Len(t, generated, 1)
+
+	_, err = GenRateLimitCustomRules(nil, existing, []string{"missing"}, false)
+	require.Error(t, err)
+
+	_, err = GenRateLimitCustomRules([]RateLimit{
+		{Name: "upload", Path: "/upload", Threshold: 5},
+		{Name: "upload", Path: "/upload", Threshold: 10},
+	}, nil, nil, false)
+	require.Error(t, err)
+}
+
+func TestLoadActionsFromPathWithRateLimits(t *testing.T) {
+	as, err := LoadActionsFromPath("testfiles/actions-rate-limits.yaml")
+	require.NoError(t, err)
+	require.Len(t, as, 1)
+	require.Len(t, as[0].RateLimits, 2)
+	require.Equal(t, "Block", as[0].RateLimits[0].Action)
+	require.Equal(t, 1, as[0].RateLimits[0].Duration)
+	require.Equal(t, "api.example.com", as[0].RateLimits[1].Host)
+	require.Equal(t, []string{"192.0.2.1/32", "198.51.100.0/24"}, as[0].RateLimits[1].IPs)
+}
@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// OutputFormatTable renders results as tables for reading in a terminal
+	OutputFormatTable = "table"
+	// OutputFormatJSON renders results as indented json
+	OutputFormatJSON = "json"
+	// OutputFormatYAML renders results as yaml, with the same fields as json
+	OutputFormatYAML = "yaml"
+	// OutputFormatCSV renders results as csv with a header row
+	OutputFormatCSV = "csv"
+)
+
+// OutputFormats are the formats results can be rendered in
+var OutputFormats = []string{OutputFormatTable, OutputFormatJSON, OutputFormatYAML, OutputFormatCSV}
+
+// Renderable is implemented by the results of read commands. Results are marshalled as they are for json and yaml,
+// so their json tags define the schema, whereas tables and csv are rendered by the result.
+type Renderable interface {
+	// RenderTable outputs the result as one or more tables
+	RenderTable()
+	// CSVRecords returns the result as rows, the first being the header
+	CSVRecords() [][]string
+}
+
+// NormaliseOutputFormat returns the format in lower case, or the default if none is specified, or an error if the
+// format is not supported
+func NormaliseOutputFormat(format, defaultFormat string) (string, error) {
+	f := strings.ToLower(strings.TrimSpace(format))
+	if f == "" {
+		f = defaultFormat
+	}
+
+	for _, of := range OutputFormats {
+		if f == of {
+			return f, nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported output format: %s", format)
+}
+
+// Render outputs the result in the format, defaulting to a table
+func Render(result Renderable, format string) error {
+	f, err := NormaliseOutputFormat(format, OutputFormatTable)
+	if err != nil {
+		return err
+	}
+
+	switch f {
+	case OutputFormatTable:
+		result.RenderTable()
+
+		return nil
+	case OutputFormatCSV:
+		return writeCSV(result.CSVRecords())
+	default:
+		return renderData(result, f)
+	}
+}
+
+// renderData outputs any value as json or yaml. Values are converted to yaml via json so that both formats share
+// the fields and names given by the json tags, including those of the Azure SDK's types.
+func renderData(v interface{}, format string) error {
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal output")
+	}
+
+	switch format {
+	case OutputFormatJSON:
+		fmt.Println(string(b))
+
+		return nil
+	case OutputFormatYAML:
+		var generic interface{}
+
+		if err = json.Unmarshal(b, &generic); err != nil {
+			return errors.Wrap(err, "failed to marshal output")
+		}
+
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+
+		if err = enc.Encode(generic); err != nil {
+			return errors.Wrap(err, "failed to marshal output")
+		}
+
+		return enc.Close()
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// writeCSV outputs the records as csv
+func writeCSV(records [][]string) error {
+	w := csv.NewWriter(os.Stdout)
+
+	if err := w.WriteAll(records); err != nil {
+		return errors.Wrap(err, "failed to write csv")
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jonhadfield/carbo/session"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNormaliseOutputFormat(t *testing.T) {
+	f, err := NormaliseOutputFormat("", OutputFormatTable)
+	require.NoError(t, err)
+	require.Equal(t, OutputFormatTable, f)
+
+	f, err = NormaliseOutputFormat(" YAML ", OutputFormatTable)
+	require.NoError(t, err)
+	require.Equal(t, OutputFormatYAML, f)
+
+	f, err = NormaliseOutputFormat("", OutputFormatJSON)
+	require.NoError(t, err)
+	require.Equal(t, OutputFormatJSON, f)
+
+	_, err = NormaliseOutputFormat("xml", OutputFormatTable)
+	require.Error(t, err)
+}
+
+func TestRender(t *testing.T) {
+	s := session.Session{}
+
+	p, err := LoadPolicyFromSource(&s, "../testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+
+	pr := NewPolicyResult(p, customRules(p), false)
+
+	var out string
+
+	out = captureStdout(t, func() {
+		require.NoError(t, Render(pr, ""))
+	})
+	require.Contains(t, out, "Custom Rules")
+	require.Contains(t, out, "BlockListOne")
+
+	out = captureStdout(t, func() {
+		require.NoError(t, Render(pr, OutputFormatJSON))
+	})
+
+	var fromJSON PolicyResult
+
+	require.NoError(t, json.Unmarshal([]byte(out), &fromJSON))
+	require.Equal(t, pr.CustomRules, fromJSON.CustomRules)
+	require.Equal(t, pr.ManagedRuleSets, fromJSON.ManagedRuleSets)
+
+	// yaml has the same fields as json
+	out = captureStdout(t, func() {
+		require.NoError(t, Render(pr, OutputFormatYAML))
+	})
+
+	var fromYAML map[string]interface{}
+
+	require.NoError(t, yaml.Unmarshal([]byte(out), &fromYAML))
+	require.Equal(t, "Prevention", fromYAML["mode"])
+	require.Len(t, fromYAML["custom_rules"], 2)
+
+	out = captureStdout(t, func() {
+		require.NoError(t, Render(pr, OutputFormatCSV))
+	})
+
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, "rule_name", records[0][0])
+	require.Equal(t, []string{"BlockListOne", "5", "Enabled", "MatchRule", "0", "0", "Block"}, records[1][:7])
+
+	require.Error(t, Render(pr, "xml"))
+}
@@ -0,0 +1,644 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/resources"
+	"github.com/alexeyco/simpletable"
+	"github.com/gookit/color"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/sirupsen/logrus"
+)
+
+// PolicyListItem identifies a policy found by list policies
+type PolicyListItem struct {
+	Name           string `json:"name"`
+	ResourceGroup  string `json:"resource_group"`
+	SubscriptionID string `json:"subscription_id"`
+	ID             string `json:"id"`
+}
+
+// PolicyList is the result of list policies
+type PolicyList []PolicyListItem
+
+// NewPolicyList returns the policies' names and locations
+func NewPolicyList(gres []resources.GenericResourceExpanded) PolicyList {
+	pl := make(PolicyList, 0, len(gres))
+
+	for _, gre := range gres {
+		id := stringValue(gre.ID)
+		rid := ParseResourceID(id)
+
+		pl = append(pl, PolicyListItem{
+			Name:           rid.Name,
+			ResourceGroup:  rid.ResourceGroup,
+			SubscriptionID: rid.SubscriptionID,
+			ID:             id,
+		})
+	}
+
+	return pl
+}
+
+// RenderTable outputs the policies' names, resource groups and ids
+func (pl PolicyList) RenderTable() {
+	if len(pl) == 0 {
+		fmt.Println("no policies found")
+
+		return
+	}
+
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Name")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Resource Group")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("ID")},
+		},
+	}
+
+	for _, p := range pl {
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: p.Name},
+			{Text: p.ResourceGroup},
+			{Text: p.ID},
+		})
+	}
+
+	table.SetStyle(simpletable.StyleRounded)
+
+	table.Println()
+}
+
+// CSVRecords returns a row per policy with columns name, resource_group, subscription_id and id
+func (pl PolicyList) CSVRecords() [][]string {
+	records := [][]string{{"name", "resource_group", "subscription_id", "id"}}
+
+	for _, p := range pl {
+		records = append(records, []string{p.Name, p.ResourceGroup, p.SubscriptionID, p.ID})
+	}
+
+	return records
+}
+
+// FrontDoorEndpointResult is a front door endpoint and the name of the policy protecting it
+type FrontDoorEndpointResult struct {
+	Name   string `json:"name"`
+	Policy string `json:"policy"`
+}
+
+// FrontDoorResult is a front door and its endpoints
+type FrontDoorResult struct {
+	Name      string                    `json:"name"`
+	Endpoints []FrontDoorEndpointResult `json:"endpoints"`
+}
+
+// FrontDoorList is the result of list frontdoors
+type FrontDoorList []FrontDoorResult
+
+// NewFrontDoorList returns the front doors with their endpoints and policies
+func NewFrontDoorList(afds FrontDoors) FrontDoorList {
+	fl := make(FrontDoorList, 0, len(afds))
+
+	for _, afd := range afds {
+		fdr := FrontDoorResult{Name: afd.Name, Endpoints: []FrontDoorEndpointResult{}}
+
+		for _, endpoint := range afd.Endpoints {
+			fdr.Endpoints = append(fdr.Endpoints, FrontDoorEndpointResult{
+				Name:   endpoint.Name,
+				Policy: stringValue(endpoint.WafPolicy.Name),
+			})
+		}
+
+		fl = append(fl, fdr)
+	}
+
+	return fl
+}
+
+// RenderTable outputs a table listing front doors, their endpoints, and their associated policies
+func (fl FrontDoorList) RenderTable() {
+	if len(fl) == 0 {
+		fmt.Println("no front doors found")
+
+		return
+	}
+
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Front Door")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Endpoint")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Policy")},
+		},
+	}
+
+	for _, fd := range fl {
+		for x, endpoint := range fd.Endpoints {
+			name := ""
+			if x == 0 {
+				name = fd.Name
+			}
+
+			table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+				{Text: name},
+				{Text: endpoint.Name},
+				{Text: endpoint.Policy},
+			})
+		}
+	}
+
+	table.Println()
+}
+
+// CSVRecords returns a row per endpoint with columns front_door, endpoint and policy
+func (fl FrontDoorList) CSVRecords() [][]string {
+	records := [][]string{{"front_door", "endpoint", "policy"}}
+
+	for _, fd := range fl {
+		for _, endpoint := range fd.Endpoints {
+			records = append(records, []string{fd.Name, endpoint.Name, endpoint.Policy})
+		}
+	}
+
+	return records
+}
+
+// MatchConditionResult is a condition a request must meet for a custom rule to match
+type MatchConditionResult struct {
+	MatchVariable string   `json:"match_variable"`
+	Selector      string   `json:"selector,omitempty"`
+	Negate        bool     `json:"negate"`
+	Operator      string   `json:"operator"`
+	Transforms    []string `json:"transforms"`
+	MatchValues   []string `json:"match_values"`
+}
+
+// CustomRuleResult is a custom rule and its conditions
+type CustomRuleResult struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	State    string `json:"state"`
+	RuleType string `json:"rule_type"`
+	// RateLimitDuration and RateLimitThreshold are only set for rate limit rules
+	RateLimitDuration  int                    `json:"rate_limit_duration,omitempty"`
+	RateLimitThreshold int                    `json:"rate_limit_threshold,omitempty"`
+	Action             string                 `json:"action"`
+	MatchConditions    []MatchConditionResult `json:"match_conditions"`
+	showFull           bool
+}
+
+// NewCustomRuleResult returns the custom rule, with tables showing all of its match values if showFull is set
+func NewCustomRuleResult(cr frontdoor.CustomRule, showFull bool) CustomRuleResult {
+	crr := CustomRuleResult{
+		Name:            stringValue(cr.Name),
+		State:           string(cr.EnabledState),
+		RuleType:        string(cr.RuleType),
+		Action:          string(cr.Action),
+		MatchConditions: []MatchConditionResult{},
+		showFull:        showFull,
+	}
+
+	if cr.Priority != nil {
+		crr.Priority = int(*cr.Priority)
+	}
+
+	if cr.RuleType == frontdoor.RuleTypeRateLimitRule {
+		if cr.RateLimitDurationInMinutes != nil {
+			crr.RateLimitDuration = int(*cr.RateLimitDurationInMinutes)
+		}
+
+		if cr.RateLimitThreshold != nil {
+			crr.RateLimitThreshold = int(*cr.RateLimitThreshold)
+		}
+	}
+
+	for _, mc := range matchConditions(cr) {
+		mcr := MatchConditionResult{
+			MatchVariable: string(mc.MatchVariable),
+			Selector:      stringValue(mc.Selector),
+			Negate:        mc.NegateCondition != nil && *mc.NegateCondition,
+			Operator:      string(mc.Operator),
+			Transforms:    []string{},
+			MatchValues:   append([]string{}, matchValues(mc)...),
+		}
+
+		if mc.Transforms != nil {
+			for _, t := range *mc.Transforms {
+				mcr.Transforms = append(mcr.Transforms, string(t))
+			}
+		}
+
+		crr.MatchConditions = append(crr.MatchConditions, mcr)
+	}
+
+	return crr
+}
+
+// customRulesTable returns a table with each custom rule followed by its match conditions
+func customRulesTable(crs []CustomRuleResult, showFull bool) *simpletable.Table {
+	table := simpletable.New()
+
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rule Name")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("State")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Priority")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rule Type")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rate Limit Duration (mins)")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rate Limit Threshold")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Action")},
+		},
+	}
+
+	for _, cr := range crs {
+		rldim := " "
+		if cr.RateLimitDuration != 0 {
+			rldim = strconv.Itoa(cr.RateLimitDuration)
+		}
+
+		rlt := " "
+		if cr.RateLimitThreshold != 0 {
+			rlt = strconv.Itoa(cr.RateLimitThreshold)
+		}
+
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: cr.Name + "\n" + strings.Repeat("-", 11)},
+			{Text: cr.State + "\n" + strings.Repeat("-", 9)},
+			{Text: strconv.Itoa(cr.Priority) + "\n" + strings.Repeat("-", 10)},
+			{Text: cr.RuleType + "\n" + strings.Repeat("-", 13)},
+			{Text: rldim + "\n" + strings.Repeat("-", 28)},
+			{Text: rlt + "\n" + strings.Repeat("-", 22)},
+			{Align: simpletable.AlignCenter, Text: formatCRAction(frontdoor.ActionType(cr.Action)) + "\n" + strings.Repeat("-", 8)},
+		})
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: color.HiBlue.Sprintf("Match Variable")},
+			{Text: color.HiBlue.Sprintf("Selector")},
+			{Text: color.HiBlue.Sprintf("Negate")},
+			{Text: color.HiBlue.Sprintf("Operator")},
+			{Text: color.HiBlue.Sprintf("Transforms")},
+			{Text: color.HiBlue.Sprintf("Match Value")},
+			{Text: ""},
+		})
+
+		for _, mc := range cr.MatchConditions {
+			table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+				{Text: mc.MatchVariable},
+				{Text: dashIfEmptyString(mc.Selector)},
+				{Text: strconv.FormatBool(mc.Negate)},
+				{Text: dashIfEmptyString(mc.Operator)},
+				{Text: dashIfEmptyString(strings.Join(mc.Transforms, ", "))},
+				{Text: wrapMatchValues(mc.MatchValues, showFull)},
+				{Text: ""},
+			})
+		}
+
+		// separator
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: ""},
+			{Text: ""},
+			{Text: ""},
+			{Text: ""},
+			{Text: ""},
+			{Text: ""},
+			{Text: ""},
+		})
+	}
+
+	table.SetStyle(simpletable.StyleRounded)
+
+	return table
+}
+
+// customRulesCSVRecords returns a row per match condition of each custom rule, with the rule's values repeated
+func customRulesCSVRecords(crs []CustomRuleResult) [][]string {
+	records := [][]string{{
+		"rule_name", "priority", "state", "rule_type", "rate_limit_duration", "rate_limit_threshold", "action",
+		"match_variable", "selector", "negate", "operator", "transforms", "match_values",
+	}}
+
+	for _, cr := range crs {
+		rule := []string{
+			cr.Name,
+			strconv.Itoa(cr.Priority),
+			cr.State,
+			cr.RuleType,
+			strconv.Itoa(cr.RateLimitDuration),
+			strconv.Itoa(cr.RateLimitThreshold),
+			cr.Action,
+		}
+
+		for _, mc := range cr.MatchConditions {
+			records = append(records, append(append([]string{}, rule...),
+				mc.MatchVariable,
+				mc.Selector,
+				strconv.FormatBool(mc.Negate),
+				mc.Operator,
+				strings.Join(mc.Transforms, " "),
+				strings.Join(mc.MatchValues, " "),
+			))
+		}
+	}
+
+	return records
+}
+
+// RenderTable outputs the custom rule and its match conditions
+func (crr CustomRuleResult) RenderTable() {
+	customRulesTable([]CustomRuleResult{crr}, crr.showFull).Println()
+}
+
+// CSVRecords returns a row per match condition, with columns for the rule and the condition. Multiple transforms
+// and match values are separated by spaces.
+func (crr CustomRuleResult) CSVRecords() [][]string {
+	return customRulesCSVRecords([]CustomRuleResult{crr})
+}
+
+// ManagedRuleOverrideResult is an override of a managed rule's state or action
+type ManagedRuleOverrideResult struct {
+	RuleID     string   `json:"rule_id"`
+	State      string   `json:"state"`
+	Action     string   `json:"action,omitempty"`
+	Exclusions []string `json:"exclusions"`
+}
+
+// ManagedRuleGroupResult is an override of a managed rule group. If it has no rule overrides every rule in the
+// group is disabled.
+type ManagedRuleGroupResult struct {
+	Name       string                      `json:"name"`
+	Exclusions []string                    `json:"exclusions"`
+	Rules      []ManagedRuleOverrideResult `json:"rules"`
+}
+
+// ManagedRuleSetResult is a managed rule set with its overrides. Exclusions are described as
+// "<match variable> <operator> <selector>".
+type ManagedRuleSetResult struct {
+	Type       string                   `json:"type"`
+	Version    string                   `json:"version"`
+	Action     string                   `json:"action,omitempty"`
+	Exclusions []string                 `json:"exclusions"`
+	RuleGroups []ManagedRuleGroupResult `json:"rule_groups"`
+}
+
+// exclusionsOrEmpty returns the descriptions of the exclusions, or an empty slice if there are none
+func exclusionsOrEmpty(es *[]frontdoor.ManagedRuleExclusion) []string {
+	if s := exclusionStrings(es); s != nil {
+		return s
+	}
+
+	return []string{}
+}
+
+// formatManagedRuleState returns the enabled state of a managed rule override, defaulting to Disabled as Azure does
+func formatManagedRuleState(state frontdoor.ManagedRuleEnabledState) string {
+	if state == "" {
+		return string(frontdoor.ManagedRuleEnabledStateDisabled)
+	}
+
+	return string(state)
+}
+
+// newManagedRuleSetResults returns the managed rule sets with their rule group and rule overrides
+func newManagedRuleSetResults(rss []frontdoor.ManagedRuleSet) []ManagedRuleSetResult {
+	results := make([]ManagedRuleSetResult, 0, len(rss))
+
+	for _, rs := range rss {
+		rsr := ManagedRuleSetResult{
+			Type:       stringValue(rs.RuleSetType),
+			Version:    stringValue(rs.RuleSetVersion),
+			Action:     string(rs.RuleSetAction),
+			Exclusions: exclusionsOrEmpty(rs.Exclusions),
+			RuleGroups: []ManagedRuleGroupResult{},
+		}
+
+		if rs.RuleGroupOverrides != nil {
+			for _, rgo := range *rs.RuleGroupOverrides {
+				rgr := ManagedRuleGroupResult{
+					Name:       stringValue(rgo.RuleGroupName),
+					Exclusions: exclusionsOrEmpty(rgo.Exclusions),
+					Rules:      []ManagedRuleOverrideResult{},
+				}
+
+				if rgo.Rules != nil {
+					for _, r := range *rgo.Rules {
+						rgr.Rules = append(rgr.Rules, ManagedRuleOverrideResult{
+							RuleID:     stringValue(r.RuleID),
+							State:      formatManagedRuleState(r.EnabledState),
+							Action:     string(r.Action),
+							Exclusions: exclusionsOrEmpty(r.Exclusions),
+						})
+					}
+				}
+
+				rsr.RuleGroups = append(rsr.RuleGroups, rgr)
+			}
+		}
+
+		results = append(results, rsr)
+	}
+
+	return results
+}
+
+// renderManagedRuleSets outputs each managed rule set with its rule group and rule overrides, and their exclusions
+func renderManagedRuleSets(rss []ManagedRuleSetResult, showFull bool) {
+	for _, rs := range rss {
+		color.Bold.Printf("Managed Rule Set ")
+		fmt.Printf("%s %s\n", dashIfEmptyString(rs.Type), dashIfEmptyString(rs.Version))
+
+		if rs.Action != "" {
+			color.Bold.Printf("Action ")
+			fmt.Println(formatCRAction(frontdoor.ActionType(rs.Action)))
+		}
+
+		if len(rs.Exclusions) > 0 {
+			color.Bold.Printf("Exclusions ")
+			fmt.Println(wrapMatchValues(rs.Exclusions, showFull))
+		}
+
+		if len(rs.RuleGroups) == 0 {
+			fmt.Println()
+
+			continue
+		}
+
+		table := simpletable.New()
+		table.Header = &simpletable.Header{
+			Cells: []*simpletable.Cell{
+				{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rule Group")},
+				{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rule")},
+				{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("State")},
+				{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Action")},
+				{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Exclusions")},
+			},
+		}
+
+		for _, rg := range rs.RuleGroups {
+			group := dashIfEmptyString(rg.Name)
+
+			// a group override without rules disables every rule in the group
+			if len(rg.Rules) == 0 {
+				table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+					{Text: group},
+					{Text: "all"},
+					{Text: string(frontdoor.ManagedRuleEnabledStateDisabled)},
+					{Text: "-"},
+					{Text: dashIfEmptyString(wrapMatchValues(rg.Exclusions, showFull))},
+				})
+
+				continue
+			}
+
+			if len(rg.Exclusions) > 0 {
+				table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+					{Text: group},
+					{Text: "all"},
+					{Text: "-"},
+					{Text: "-"},
+					{Text: wrapMatchValues(rg.Exclusions, showFull)},
+				})
+			}
+
+			for x, r := range rg.Rules {
+				if x == helpers.MaxMatchValuesOutput && !showFull {
+					table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+						{Text: group},
+						{Text: fmt.Sprintf("... %d remaining", len(rg.Rules)-x)},
+						{Text: ""},
+						{Text: ""},
+						{Text: ""},
+					})
+
+					break
+				}
+
+				table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+					{Text: group},
+					{Text: dashIfEmptyString(r.RuleID)},
+					{Text: r.State},
+					{Align: simpletable.AlignCenter, Text: formatCRAction(frontdoor.ActionType(r.Action))},
+					{Text: dashIfEmptyString(wrapMatchValues(r.Exclusions, showFull))},
+				})
+			}
+		}
+
+		table.SetStyle(simpletable.StyleRounded)
+
+		table.Println()
+	}
+}
+
+// PolicyResult is a policy's settings, custom rules and managed rule sets
+type PolicyResult struct {
+	Name              string `json:"name"`
+	ProvisioningState string `json:"provisioning_state"`
+	ResourceState     string `json:"resource_state"`
+	EnabledState      string `json:"enabled_state"`
+	Mode              string `json:"mode"`
+	// DefaultDeny is true if a custom rule blocks every address not otherwise allowed
+	DefaultDeny bool `json:"default_deny"`
+	// TotalCustomRules is the number of custom rules in the policy, which may be more than those included
+	TotalCustomRules int                    `json:"total_custom_rules"`
+	CustomRules      []CustomRuleResult     `json:"custom_rules"`
+	ManagedRuleSets  []ManagedRuleSetResult `json:"managed_rule_sets"`
+	showFull         bool
+	defaultDenyErr   error
+}
+
+// NewPolicyResult returns the policy with only the custom rules provided, such as those matching a filter, with
+// tables showing all match values and overrides if showFull is set
+func NewPolicyResult(p frontdoor.WebApplicationFirewallPolicy, crs []frontdoor.CustomRule, showFull bool) PolicyResult {
+	pr := PolicyResult{
+		Name:             stringValue(p.Name),
+		TotalCustomRules: len(customRules(p)),
+		CustomRules:      []CustomRuleResult{},
+		ManagedRuleSets:  newManagedRuleSetResults(managedRuleSets(p)),
+		showFull:         showFull,
+	}
+
+	if p.WebApplicationFirewallPolicyProperties != nil {
+		pr.ProvisioningState = stringValue(p.ProvisioningState)
+		pr.ResourceState = string(p.ResourceState)
+
+		if p.PolicySettings != nil {
+			pr.EnabledState = string(p.PolicySettings.EnabledState)
+			pr.Mode = string(p.PolicySettings.Mode)
+		}
+	}
+
+	for _, cr := range crs {
+		pr.CustomRules = append(pr.CustomRules, NewCustomRuleResult(cr, showFull))
+	}
+
+	pr.DefaultDeny, pr.defaultDenyErr = helpers.PolicyHasDefaultDeny(p)
+
+	return pr
+}
+
+// RenderTable outputs the policy's details, a table of its custom rules, a table for each managed rule set, and a
+// warning if it has no default deny
+func (pr PolicyResult) RenderTable() {
+	color.Bold.Printf("Name ")
+	fmt.Println(pr.Name)
+	color.Bold.Printf("Provisioning State ")
+	fmt.Println(pr.ProvisioningState)
+	color.Bold.Printf("Resource State ")
+	fmt.Println(pr.ResourceState)
+	fmt.Println()
+
+	if len(pr.CustomRules) < pr.TotalCustomRules {
+		fmt.Printf("showing %d of %d custom rules\n\n", len(pr.CustomRules), pr.TotalCustomRules)
+	}
+
+	if len(pr.CustomRules) > 0 {
+		color.Bold.Println("Custom Rules")
+		customRulesTable(pr.CustomRules, pr.showFull).Println()
+	}
+
+	renderManagedRuleSets(pr.ManagedRuleSets, pr.showFull)
+
+	if pr.defaultDenyErr != nil {
+		if logrus.IsLevelEnabled(logrus.DebugLevel) {
+			color.Red.Println("[ERROR] Failed to check if Policy has default deny.", pr.defaultDenyErr)
+			os.Exit(1)
+		}
+
+		color.Red.Println("[ERROR] Failed to check if Policy has default deny. run with debug for error")
+	}
+
+	if pr.defaultDenyErr == nil && !pr.DefaultDeny {
+		color.Yellow.Println("[WARNING] Policy does not have default deny")
+	}
+}
+
+// CSVRecords returns a row per match condition of each custom rule included, as for a single custom rule. Managed
+// rule sets are only output as tables, json or yaml.
+func (pr PolicyResult) CSVRecords() [][]string {
+	return customRulesCSVRecords(pr.CustomRules)
+}
+
+// CSVRecords returns a row per action and carbo prefix with columns group, name and rules, where group is either
+// action or prefix
+func (summary CustomRulesSummary) CSVRecords() [][]string {
+	records := [][]string{{"group", "name", "rules"}}
+
+	for _, group := range []struct {
+		name   string
+		counts map[string]int
+	}{{"action", summary.Actions}, {"prefix", summary.Prefixes}} {
+		for _, k := range sortedKeys(group.counts) {
+			records = append(records, []string{group.name, k, strconv.Itoa(group.counts[k])})
+		}
+	}
+
+	return records
+}
+
+// RenderTable outputs the number of custom rules for each action and carbo prefix
+func (summary CustomRulesSummary) RenderTable() {
+	OutputCustomRulesSummary(summary)
+}
@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/resources"
+	"github.com/jonhadfield/carbo/session"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPolicyResult(t *testing.T) {
+	s := session.Session{}
+
+	p, err := LoadPolicyFromSource(&s, "../testfiles/wrapped-policy-one.json")
+	require.NoError(t, err)
+
+	crs := FilterCustomRules(customRules(p), CustomRuleFilter{Names: []string{"BlockListTwo"}})
+
+	pr := NewPolicyResult(p, crs, false)
+	require.Equal(t, "Enabled", pr.EnabledState)
+	require.Equal(t, "Prevention", pr.Mode)
+	require.False(t, pr.DefaultDeny)
+	require.Equal(t, 2, pr.TotalCustomRules)
+	require.Len(t, pr.CustomRules, 1)
+
+	cr := pr.CustomRules[0]
+	require.Equal(t, "BlockListTwo", cr.Name)
+	require.Equal(t, 6, cr.Priority)
+	require.Equal(t, "Block", cr.Action)
+	require.Len(t, cr.MatchConditions, 1)
+	require.Equal(t, "RemoteAddr", cr.MatchConditions[0].MatchVariable)
+	require.Equal(t, "IPMatch", cr.MatchConditions[0].Operator)
+	require.Len(t, cr.MatchConditions[0].MatchValues, 2)
+	require.NotNil(t, cr.MatchConditions[0].Transforms)
+
+	require.Len(t, pr.ManagedRuleSets, 2)
+	require.Equal(t, "Microsoft_DefaultRuleSet", pr.ManagedRuleSets[0].Type)
+	require.Len(t, pr.ManagedRuleSets[0].RuleGroups, 1)
+	require.Empty(t, pr.ManagedRuleSets[1].RuleGroups)
+	require.NotNil(t, pr.ManagedRuleSets[1].RuleGroups)
+}
+
+func TestNewPolicyList(t *testing.T) {
+	id := "/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorwebapplicationfirewallpolicies/mypolicy"
+
+	pl := NewPolicyList([]resources.GenericResourceExpanded{{ID: &id}})
+	require.Len(t, pl, 1)
+	require.Equal(t, "mypolicy", pl[0].Name)
+	require.Equal(t, "flying", pl[0].ResourceGroup)
+	require.Equal(t, "0a914e76-4921-4c19-b460-a2d36003525a", pl[0].SubscriptionID)
+	require.Equal(t, id, pl[0].ID)
+
+	require.Equal(t, [][]string{
+		{"name", "resource_group", "subscription_id", "id"},
+		{"mypolicy", "flying", "0a914e76-4921-4c19-b460-a2d36003525a", id},
+	}, pl.CSVRecords())
+
+	require.Empty(t, NewPolicyList(nil))
+	require.NotNil(t, NewPolicyList(nil))
+}
+
+func TestNewFrontDoorList(t *testing.T) {
+	policyName := "mypolicy"
+
+	fl := NewFrontDoorList(FrontDoors{
+		{
+			Name: "fd-one",
+			Endpoints: []FrontDoorEndpoint{
+				{Name: "www", WafPolicy: frontdoor.WebApplicationFirewallPolicy{Name: &policyName}},
+				{Name: "api"},
+			},
+		},
+	})
+
+	require.Len(t, fl, 1)
+	require.Equal(t, []FrontDoorEndpointResult{{Name: "www", Policy: policyName}, {Name: "api"}}, fl[0].Endpoints)
+	require.Equal(t, [][]string{
+		{"front_door", "endpoint", "policy"},
+		{"fd-one", "www", policyName},
+		{"fd-one", "api", ""},
+	}, fl.CSVRecords())
+}
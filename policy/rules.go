@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/jonhadfield/carbo/session"
+)
+
+// managedRulesInput specifies how to regenerate the custom rules carbo manages with a prefix in a policy
+type managedRulesInput struct {
+	RID    ResourceID
+	Prefix string
+	Output bool
+	DryRun bool
+	// check, if provided, validates the policy before its rules are generated
+	check func(p frontdoor.WebApplicationFirewallPolicy) error
+	// generate returns the rules to replace those existing with the prefix
+	generate func(existing []frontdoor.CustomRule) ([]frontdoor.CustomRule, error)
+	// show outputs the changes from the existing to the generated rules, once applied or, if a dry run, instead
+	show func(existing, generated []frontdoor.CustomRule) error
+	// applied, if provided, is called once the policy is updated, or found not to need updating, and not if the
+	// changes are only shown or output
+	applied func() error
+}
+
+// applyManagedRules replaces the policy's custom rules having the prefix with those generated, and then either
+// outputs the resulting policy, shows the changes, or pushes the policy and shows the changes
+func applyManagedRules(s *session.Session, input managedRulesInput) (err error) {
+	p, err := GetRawPolicy(s, input.RID.SubscriptionID, input.RID.ResourceGroup, input.RID.Name)
+	if err != nil {
+		return
+	}
+
+	if p.Name == nil {
+		return fmt.Errorf("specified Policy not found")
+	}
+
+	if p.CustomRules == nil {
+		p.CustomRules = &frontdoor.CustomRuleList{}
+	}
+
+	if p.CustomRules.Rules == nil {
+		p.CustomRules.Rules = &[]frontdoor.CustomRule{}
+	}
+
+	if input.check != nil {
+		if err = input.check(p); err != nil {
+			return
+		}
+	}
+
+	// sort the existing rules so that comparison only reflects changes in content
+	helpers.SortRules(*p.CustomRules.Rules)
+
+	origPolicyJSON, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return
+	}
+
+	var existing []frontdoor.CustomRule
+
+	for _, cr := range *p.CustomRules.Rules {
+		if strings.HasPrefix(*cr.Name, input.Prefix) {
+			existing = append(existing, cr)
+		}
+	}
+
+	crs, err := input.generate(existing)
+	if err != nil {
+		return
+	}
+
+	merged, err := mergeCustomRules(*p.CustomRules.Rules, crs, input.Prefix)
+	if err != nil {
+		return
+	}
+
+	*p.CustomRules.Rules = merged
+
+	if len(*p.CustomRules.Rules) > helpers.MaxCustomRules {
+		return fmt.Errorf("operation exceededs custom rules limit of %d", helpers.MaxCustomRules)
+	}
+
+	gppO, err := GeneratePolicyPatch(GeneratePolicyPatchInput{Original: origPolicyJSON, New: p})
+	if err != nil {
+		return err
+	}
+
+	if gppO.CustomRuleChanges == 0 {
+		log.Println("nothing to do")
+
+		if input.DryRun || input.Output || input.applied == nil {
+			return nil
+		}
+
+		return input.applied()
+	}
+
+	if input.DryRun {
+		return input.show(existing, crs)
+	}
+
+	if input.Output {
+		o, _ := json.MarshalIndent(p, "", "    ")
+		fmt.Println(string(o))
+
+		return nil
+	}
+
+	log.Printf("updating Policy %s\n", *p.Name)
+
+	if err = PushPolicy(s, PushPolicyInput{
+		Name:          *p.Name,
+		Subscription:  input.RID.SubscriptionID,
+		ResourceGroup: input.RID.ResourceGroup,
+		Policy:        p,
+	}); err != nil {
+		return err
+	}
+
+	if input.applied != nil {
+		if err = input.applied(); err != nil {
+			return err
+		}
+	}
+
+	return input.show(existing, crs)
+}
@@ -0,0 +1,297 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+)
+
+// httpMethods are the request methods a scope can be limited to
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "PATCH", "TRACE", "CONNECT"}
+
+// scopePathPattern begins the pattern a scope path is matched with so that it matches the start of the path
+// whether or not the request uri includes the scheme and host
+const scopePathPattern = `^(https?://[^/]+)?`
+
+// RuleScope limits the requests an action's networks apply to. Each set of values is ANDed with the networks, and
+// with each other, so a request must match one of the paths, one of the hosts and one of the methods, if specified.
+type RuleScope struct {
+	// Paths match requests with a path beginning with one of them, ignoring case, so /admin matches /admin/users
+	// and /Admin but not /api/admin. Each must begin with / and a trailing * is ignored.
+	Paths []string `yaml:"paths"`
+	// Hosts match requests with a Host header equal to one of them
+	Hosts []string `yaml:"hosts"`
+	// Methods match requests with one of the methods, e.g. POST
+	Methods []string `yaml:"methods"`
+	// MatchVariable is the address the networks are matched against: RemoteAddr (default), the client address
+	// including any X-Forwarded-For header, or SocketAddr, the address of the connection to Front Door
+	MatchVariable string `yaml:"match-variable"`
+}
+
+// NormaliseRuleScope checks the scope can be applied and returns it with paths and hosts in lower case, methods in
+// upper case, and the match variable set
+func NormaliseRuleScope(scope RuleScope) (res RuleScope, err error) {
+	for _, p := range scope.Paths {
+		np := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(p), "*"))
+		if !strings.HasPrefix(np, "/") {
+			return res, fmt.Errorf("invalid scope path: '%s'", p)
+		}
+
+		res.Paths = append(res.Paths, np)
+	}
+
+	for _, h := range scope.Hosts {
+		nh := strings.ToLower(strings.TrimSpace(h))
+		if nh == "" || strings.ContainsAny(nh, "/ ") {
+			return res, fmt.Errorf("invalid scope host: '%s'", h)
+		}
+
+		res.Hosts = append(res.Hosts, nh)
+	}
+
+	for _, m := range scope.Methods {
+		nm := strings.ToUpper(strings.TrimSpace(m))
+
+		var valid bool
+
+		for _, hm := range httpMethods {
+			if nm == hm {
+				valid = true
+			}
+		}
+
+		if !valid {
+			return res, fmt.Errorf("invalid scope method: '%s'", m)
+		}
+
+		res.Methods = append(res.Methods, nm)
+	}
+
+	switch strings.ToLower(scope.MatchVariable) {
+	case "", "remoteaddr":
+		res.MatchVariable = "RemoteAddr"
+	case "socketaddr":
+		res.MatchVariable = "SocketAddr"
+	default:
+		return res, fmt.Errorf("unsupported match variable '%s': must be RemoteAddr or SocketAddr", scope.MatchVariable)
+	}
+
+	return
+}
+
+// matchConditions returns the conditions, other than the networks, that a request must match
+func (scope RuleScope) matchConditions() (mcs []frontdoor.MatchCondition) {
+	f := false
+
+	if len(scope.Paths) > 0 {
+		var patterns []string
+
+		for _, p := range scope.Paths {
+			patterns = append(patterns, scopePathRegex(p))
+		}
+
+		mcs = append(mcs, frontdoor.MatchCondition{
+			MatchVariable:   "RequestUri",
+			NegateCondition: &f,
+			Operator:        "RegEx",
+			MatchValue:      &patterns,
+			Transforms:      &[]frontdoor.TransformType{"Lowercase"},
+		})
+	}
+
+	if len(scope.Hosts) > 0 {
+		selector := "Host"
+		hosts := append([]string{}, scope.Hosts...)
+
+		mcs = append(mcs, frontdoor.MatchCondition{
+			MatchVariable:   "RequestHeader",
+			Selector:        &selector,
+			NegateCondition: &f,
+			Operator:        "Equal",
+			MatchValue:      &hosts,
+			Transforms:      &[]frontdoor.TransformType{"Lowercase"},
+		})
+	}
+
+	if len(scope.Methods) > 0 {
+		methods := append([]string{}, scope.Methods...)
+
+		mcs = append(mcs, frontdoor.MatchCondition{
+			MatchVariable:   "RequestMethod",
+			NegateCondition: &f,
+			Operator:        "Equal",
+			MatchValue:      &methods,
+			Transforms:      &[]frontdoor.TransformType{},
+		})
+	}
+
+	return
+}
+
+// scopeFromCustomRule returns the scope of a custom rule generated by carbo for an action's networks
+func scopeFromCustomRule(cr frontdoor.CustomRule) (scope RuleScope) {
+	scope.MatchVariable = "RemoteAddr"
+
+	if cr.MatchConditions == nil {
+		return
+	}
+
+	for _, mc := range *cr.MatchConditions {
+		if mc.MatchValue == nil {
+			continue
+		}
+
+		switch {
+		case mc.Operator == "IPMatch":
+			scope.MatchVariable = string(mc.MatchVariable)
+		case mc.MatchVariable == "RequestUri":
+			for _, v := range *mc.MatchValue {
+				// rules generated before paths were matched as prefixes contain the path itself
+				if p, ok := scopePathFromRegex(v); ok && mc.Operator == "RegEx" {
+					v = p
+				}
+
+				scope.Paths = append(scope.Paths, v)
+			}
+		case mc.MatchVariable == "RequestHeader" && mc.Selector != nil && strings.EqualFold(*mc.Selector, "Host"):
+			scope.Hosts = append(scope.Hosts, *mc.MatchValue...)
+		case mc.MatchVariable == "RequestMethod":
+			scope.Methods = append(scope.Methods, *mc.MatchValue...)
+		}
+	}
+
+	return
+}
+
+// scopePathRegex returns the pattern matching a lower case request uri whose path begins with the path
+func scopePathRegex(path string) string {
+	return scopePathPattern + regexp.QuoteMeta(path)
+}
+
+// scopePathFromRegex returns the path matched by a pattern generated by scopePathRegex, or false if the pattern was
+// not generated by it
+func scopePathFromRegex(pattern string) (string, bool) {
+	if !strings.HasPrefix(pattern, scopePathPattern) {
+		return "", false
+	}
+
+	var (
+		b       strings.Builder
+		escaped bool
+	)
+
+	for _, r := range strings.TrimPrefix(pattern, scopePathPattern) {
+		if r == '\\' && !escaped {
+			escaped = true
+
+			continue
+		}
+
+		escaped = false
+
+		b.WriteRune(r)
+	}
+
+	return b.String(), true
+}
+
+// key returns a representation of the scope that is equal for scopes matching the same requests
+func (scope RuleScope) key() string {
+	n, err := NormaliseRuleScope(scope)
+	if err != nil {
+		n = RuleScope{
+			Paths:         append([]string{}, scope.Paths...),
+			Hosts:         append([]string{}, scope.Hosts...),
+			Methods:       append([]string{}, scope.Methods...),
+			MatchVariable: scope.MatchVariable,
+		}
+	}
+
+	for _, vs := range [][]string{n.Paths, n.Hosts, n.Methods} {
+		sort.Strings(vs)
+	}
+
+	return n.String()
+}
+
+// intersects returns whether a request could match both scopes. Scopes cannot both match if their networks are
+// matched against different addresses, or if both are limited to paths, hosts or methods with none in common.
+func (scope RuleScope) intersects(other RuleScope) bool {
+	a, err := NormaliseRuleScope(scope)
+	if err != nil {
+		a = scope
+	}
+
+	b, err := NormaliseRuleScope(other)
+	if err != nil {
+		b = other
+	}
+
+	if a.MatchVariable != b.MatchVariable {
+		return false
+	}
+
+	// a path is a prefix so two paths can match the same request if either begins with the other
+	pathsMatch := func(x, y string) bool {
+		return strings.HasPrefix(x, y) || strings.HasPrefix(y, x)
+	}
+
+	equal := func(x, y string) bool {
+		return x == y
+	}
+
+	for _, d := range []struct {
+		a, b  []string
+		match func(x, y string) bool
+	}{
+		{a.Paths, b.Paths, pathsMatch},
+		{a.Hosts, b.Hosts, equal},
+		{a.Methods, b.Methods, equal},
+	} {
+		if len(d.a) == 0 || len(d.b) == 0 {
+			continue
+		}
+
+		var common bool
+
+		for _, x := range d.a {
+			for _, y := range d.b {
+				if d.match(x, y) {
+					common = true
+				}
+			}
+		}
+
+		if !common {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String returns a description of the scope for output
+func (scope RuleScope) String() string {
+	var parts []string
+
+	if len(scope.Paths) > 0 {
+		parts = append(parts, "paths: "+strings.Join(scope.Paths, ", "))
+	}
+
+	if len(scope.Hosts) > 0 {
+		parts = append(parts, "hosts: "+strings.Join(scope.Hosts, ", "))
+	}
+
+	if len(scope.Methods) > 0 {
+		parts = append(parts, "methods: "+strings.Join(scope.Methods, ", "))
+	}
+
+	if scope.MatchVariable != "" && scope.MatchVariable != "RemoteAddr" {
+		parts = append(parts, "match variable: "+scope.MatchVariable)
+	}
+
+	return strings.Join(parts, "; ")
+}
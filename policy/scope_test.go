@@ -0,0 +1,112 @@
+package policy
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormaliseRuleScope(t *testing.T) {
+	scope, err := NormaliseRuleScope(RuleScope{
+		Paths:   []string{"/API/Partner/*"},
+		Hosts:   []string{"Admin.Example.com"},
+		Methods: []string{"post"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/api/partner/"}, scope.Paths)
+	require.Equal(t, []string{"admin.example.com"}, scope.Hosts)
+	require.Equal(t, []string{"POST"}, scope.Methods)
+	require.Equal(t, "RemoteAddr", scope.MatchVariable)
+
+	for _, invalid := range []RuleScope{
+		{Paths: []string{"*"}},
+		{Paths: []string{"admin"}},
+		{Hosts: []string{"example.com/admin"}},
+		{Methods: []string{"FETCH"}},
+		{MatchVariable: "RequestHeader"},
+	} {
+		_, err = NormaliseRuleScope(invalid)
+		require.Error(t, err)
+	}
+}
+
+func TestGenScopedCustomRulesFromIPNets(t *testing.T) {
+	scope, err := NormaliseRuleScope(RuleScope{
+		Paths:         []string{"/admin"},
+		Hosts:         []string{"admin.example.com"},
+		Methods:       []string{"GET", "POST"},
+		MatchVariable: "SocketAddr",
+	})
+	require.NoError(t, err)
+
+	crs, _, err := GenScopedCustomRulesFromIPNets(parseIPNets(t, "192.0.2.0/24", "198.51.100.0/24"), nil, 0, "Block", scope)
+	require.NoError(t, err)
+	require.Len(t, crs, 1)
+
+	mcs := *crs[0].MatchConditions
+	require.Len(t, mcs, 4)
+	require.Equal(t, "SocketAddr", string(mcs[0].MatchVariable))
+	require.Equal(t, "IPMatch", string(mcs[0].Operator))
+	require.Equal(t, "RequestUri", string(mcs[1].MatchVariable))
+	require.Equal(t, "RequestHeader", string(mcs[2].MatchVariable))
+	require.Equal(t, "Host", *mcs[2].Selector)
+	require.Equal(t, "RequestMethod", string(mcs[3].MatchVariable))
+
+	// networks matched against SocketAddr are read back from the rule
+	require.Equal(t, []string{"192.0.2.0/24", "198.51.100.0/24"}, ipNetsFromCustomRule(crs[0]).toString())
+	require.Equal(t, scope, scopeFromCustomRule(crs[0]))
+
+	// changing only the scope changes the rule
+	unscoped, _, err := GenStableCustomRulesFromIPNets(parseIPNets(t, "192.0.2.0/24", "198.51.100.0/24"), nil, 0, "Block")
+	require.NoError(t, err)
+	require.Len(t, *unscoped[0].MatchConditions, 1)
+
+	summary := SummariseIPChanges(unscoped, crs)
+	require.Equal(t, []string{*crs[0].Name}, summary.RulesChanged)
+	require.Empty(t, summary.NetworksAdded)
+}
+
+func TestScopePathMatching(t *testing.T) {
+	scope, err := NormaliseRuleScope(RuleScope{Paths: []string{"/Admin.php*", "/api/(v1)/"}})
+	require.NoError(t, err)
+
+	mc := scope.matchConditions()[0]
+	require.Equal(t, "RegEx", string(mc.Operator))
+	require.Equal(t, []frontdoor.TransformType{"Lowercase"}, *mc.Transforms)
+
+	matches := func(uri string) bool {
+		for _, v := range *mc.MatchValue {
+			// the request uri is lower cased by the transform before matching
+			if regexp.MustCompile(v).MatchString(strings.ToLower(uri)) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	// paths match the start of the request's path, with or without the scheme and host, ignoring case
+	for _, uri := range []string{"/admin.php", "/ADMIN.php?x=1", "https://www.example.com/admin.php/users", "/api/(v1)/users"} {
+		require.True(t, matches(uri), uri)
+	}
+
+	for _, uri := range []string{"/", "/api/admin.php", "/adminxphp", "https://www.example.com/x/admin.php", "/api/v1/users"} {
+		require.False(t, matches(uri), uri)
+	}
+
+	// the paths are read back from the rule
+	require.Equal(t, scope.Paths, scopeFromCustomRule(frontdoor.CustomRule{MatchConditions: &[]frontdoor.MatchCondition{mc}}).Paths)
+}
+
+func TestLoadActionsFromPathWithScope(t *testing.T) {
+	as, err := LoadActionsFromPath("testfiles/actions-scope.yaml")
+	require.NoError(t, err)
+	require.Len(t, as, 1)
+	require.Equal(t, []string{"/api/partner/"}, as[0].Scope.Paths)
+	require.Equal(t, []string{"api.example.com"}, as[0].Scope.Hosts)
+	require.Equal(t, []string{"GET", "POST"}, as[0].Scope.Methods)
+	require.Equal(t, "SocketAddr", as[0].Scope.MatchVariable)
+}
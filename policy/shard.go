@@ -0,0 +1,349 @@
+package policy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexeyco/simpletable"
+	"github.com/gookit/color"
+	"github.com/jonhadfield/carbo/helpers"
+	"github.com/jonhadfield/carbo/session"
+	"github.com/pkg/errors"
+)
+
+// IPNetShard records the shard a network is assigned to and, if it was already held by one of the shards, the shard
+// that previously held it
+type IPNetShard struct {
+	Net      string `json:"network"`
+	Shard    int    `json:"shard"`
+	Policy   string `json:"policy"`
+	Previous int    `json:"previous_shard,omitempty"`
+}
+
+// shardWeight returns the weight of a network for a shard. Each network is assigned to the shard giving the highest
+// weight so that adding or removing a shard only moves the networks assigned to, or taken by, that shard.
+func shardWeight(shard ResourceID, key string) uint64 {
+	h := fnv.New64a()
+
+	_, _ = h.Write([]byte(strings.ToLower(shard.Raw)))
+	_, _ = h.Write([]byte("|"))
+	_, _ = h.Write([]byte(key))
+
+	return h.Sum64()
+}
+
+// AssignIPNetShards spreads the networks across the shards without exceeding each shard's capacity of networks.
+// Each network is placed in its highest weighted shard with capacity remaining, so the same networks and shards
+// always produce the same assignment whatever order either is provided in. Networks that do not fit in any shard
+// are returned as dropped.
+func AssignIPNetShards(ipns IPNets, shards []ResourceID, capacities []int) (assigned []IPNets, dropped IPNets) {
+	assigned = make([]IPNets, len(shards))
+
+	sorted, _ := deDupeIPNets(normaliseIPNets(ipns))
+	sortIPNets(sorted)
+
+	order := make([]int, len(shards))
+
+	for _, ipn := range sorted {
+		key := ipn.String()
+
+		weights := make([]uint64, len(shards))
+		for x := range shards {
+			order[x] = x
+			weights[x] = shardWeight(shards[x], key)
+		}
+
+		sort.SliceStable(order, func(i, j int) bool {
+			return weights[order[i]] > weights[order[j]]
+		})
+
+		placed := false
+
+		for _, x := range order {
+			if len(assigned[x]) < capacities[x] {
+				assigned[x] = append(assigned[x], ipn)
+				placed = true
+
+				break
+			}
+		}
+
+		if !placed {
+			dropped = append(dropped, ipn)
+		}
+	}
+
+	return
+}
+
+// shardRuleBudget returns the number of rules the action may create in a policy, being the lower of the maximum
+// rules requested and those remaining once the policy's other custom rules are accounted for
+func shardRuleBudget(maxRules, otherRules int) int {
+	budget := helpers.MaxCustomRules - otherRules
+
+	if maxRules > 0 && maxRules < budget {
+		budget = maxRules
+	}
+
+	if budget < 0 {
+		return 0
+	}
+
+	return budget
+}
+
+// IPNetShards are the shards networks are assigned to
+type IPNetShards []IPNetShard
+
+// ShowIPNetShards outputs the shard each network is assigned to in the requested format: table, json, yaml or csv
+func ShowIPNetShards(shards []IPNetShard, format string) error {
+	return Render(IPNetShards(shards), format)
+}
+
+// RenderTable outputs a table of the networks with their shards, and whether they are new or have moved
+func (shards IPNetShards) RenderTable() {
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Network")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Shard")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Policy")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Previous")},
+		},
+	}
+
+	for _, sh := range shards {
+		previous := color.HiGreen.Sprint("new")
+
+		switch {
+		case sh.Previous == sh.Shard:
+			previous = strconv.Itoa(sh.Previous)
+		case sh.Previous != 0:
+			previous = color.HiYellow.Sprintf("moved from %d", sh.Previous)
+		}
+
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: sh.Net},
+			{Align: simpletable.AlignRight, Text: strconv.Itoa(sh.Shard)},
+			{Text: sh.Policy},
+			{Text: previous},
+		})
+	}
+
+	table.SetStyle(simpletable.StyleRounded)
+
+	table.Println()
+}
+
+// CSVRecords returns a row per network with columns network, shard, policy and previous_shard, the last being empty
+// for new networks
+func (shards IPNetShards) CSVRecords() [][]string {
+	records := [][]string{{"network", "shard", "policy", "previous_shard"}}
+
+	for _, sh := range shards {
+		previous := ""
+		if sh.Previous != 0 {
+			previous = strconv.Itoa(sh.Previous)
+		}
+
+		records = append(records, []string{sh.Net, strconv.Itoa(sh.Shard), sh.Policy, previous})
+	}
+
+	return records
+}
+
+// applyShardedIPChanges spreads the action's networks across the input's policy and its shards, and then updates
+// each policy with the networks assigned to it. Networks are rebalanced on every run, with each policy limited to the
+// maximum rules for the action and the custom rules it has available. Policies are updated one at a time, each after
+// the shards receiving networks it held, so that if an update fails a moved network is left in both shards rather
+// than neither. This cannot be ensured for shards exchanging networks with each other, so the shards updated before
+// any failure are logged and the list should be applied again.
+func applyShardedIPChanges(s *session.Session, input ApplyIPsInput) (err error) {
+	prefix, err := helpers.PrefixFromAction(input.Action)
+	if err != nil {
+		return
+	}
+
+	lowercaseAction := strings.ToLower(input.Action)
+
+	shards := append([]ResourceID{input.RID}, input.Shards...)
+
+	seen := make(map[string]bool, len(shards))
+
+	for _, rid := range shards {
+		if rid.Name == "" {
+			return fmt.Errorf("invalid shard policy id: %s", rid.Raw)
+		}
+
+		if seen[strings.ToLower(rid.Raw)] {
+			return fmt.Errorf("policy %s specified as a shard more than once", rid.Name)
+		}
+
+		seen[strings.ToLower(rid.Raw)] = true
+	}
+
+	// expiries are recorded against every shard so they are applied whichever the list is next applied to
+	if _, err = prepareIPNets(&input); err != nil {
+		return
+	}
+
+	budgets := make([]int, len(shards))
+	capacities := make([]int, len(shards))
+	previous := make(map[string]int)
+
+	for x, rid := range shards {
+		p, gErr := GetRawPolicy(s, rid.SubscriptionID, rid.ResourceGroup, rid.Name)
+		if gErr != nil {
+			return gErr
+		}
+
+		if p.Name == nil {
+			return fmt.Errorf("specified Policy not found: %s", rid.Raw)
+		}
+
+		var otherRules int
+
+		if p.CustomRules != nil && p.CustomRules.Rules != nil {
+			for _, cr := range *p.CustomRules.Rules {
+				if !strings.HasPrefix(*cr.Name, prefix) {
+					otherRules++
+				}
+			}
+		}
+
+		budgets[x] = shardRuleBudget(input.MaxRules, otherRules)
+		capacities[x] = budgets[x] * helpers.MaxIPMatchValues
+
+		existing, _ := IPNetsFromPolicy(p, prefix)
+		for _, ipn := range existing {
+			if _, ok := previous[ipn.String()]; !ok {
+				previous[ipn.String()] = x + 1
+			}
+		}
+	}
+
+	assigned, dropped := AssignIPNetShards(input.Nets, shards, capacities)
+
+	if len(dropped) > 0 {
+		if err = reportDroppedIPNets(dropped, lowercaseAction, input.DroppedPath); err != nil {
+			return
+		}
+
+		if !input.AllowTruncation {
+			return fmt.Errorf("%d networks exceed the %s list limit of %d networks across %d shards and truncation is not allowed", len(dropped), lowercaseAction, sumInts(capacities), len(shards))
+		}
+	}
+
+	var placements []IPNetShard
+
+	for x, ipns := range assigned {
+		log.Printf("shard %d, Policy %s: %d networks using up to %d rules\n", x+1, shards[x].Name, len(ipns), budgets[x])
+
+		for _, ipn := range ipns {
+			placements = append(placements, IPNetShard{
+				Net:      ipn.String(),
+				Shard:    x + 1,
+				Policy:   shards[x].Name,
+				Previous: previous[ipn.String()],
+			})
+		}
+	}
+
+	if err = ShowIPNetShards(placements, input.OutputFormat); err != nil {
+		return
+	}
+
+	var updated []string
+
+	for _, x := range shardUpdateOrder(placements, len(shards)) {
+		rid := shards[x]
+
+		shardInput := input
+		shardInput.RID = rid
+		shardInput.Shards = nil
+		shardInput.Nets = assigned[x]
+		shardInput.MaxRules = budgets[x]
+		// the expiry state is saved once every shard is updated
+		shardInput.expiryState = nil
+
+		// shards left without networks have their rules for the action removed
+		if err = applyPolicyIPChanges(s, shardInput, assigned[x]); err != nil {
+			if len(updated) > 0 {
+				log.Printf("shards already updated: %s\n", strings.Join(updated, ", "))
+			}
+
+			return errors.Wrapf(err, "failed to apply shard %d to Policy %s", x+1, rid.Name)
+		}
+
+		updated = append(updated, fmt.Sprintf("%d (%s)", x+1, rid.Name))
+
+		log.Printf("applied shard %d to Policy %s\n", x+1, rid.Name)
+	}
+
+	return saveIPNetExpiries(input)
+}
+
+// shardUpdateOrder returns the indexes of the shards in the order they are updated, with each shard after those
+// receiving networks it previously held, where shards do not exchange networks with each other
+func shardUpdateOrder(placements []IPNetShard, shards int) (order []int) {
+	// receivers holds, for each shard, the shards receiving networks it previously held
+	receivers := make([]map[int]bool, shards)
+	for x := range receivers {
+		receivers[x] = make(map[int]bool)
+	}
+
+	for _, p := range placements {
+		if p.Previous != 0 && p.Previous != p.Shard {
+			receivers[p.Previous-1][p.Shard-1] = true
+		}
+	}
+
+	done := make([]bool, shards)
+
+	for len(order) < shards {
+		next := -1
+
+		for x := 0; x < shards && next < 0; x++ {
+			if done[x] {
+				continue
+			}
+
+			ready := true
+
+			for r := range receivers[x] {
+				if !done[r] {
+					ready = false
+				}
+			}
+
+			if ready {
+				next = x
+			}
+		}
+
+		// shards exchanging networks cannot each be updated after the other, so the first remaining is taken
+		for x := 0; x < shards && next < 0; x++ {
+			if !done[x] {
+				next = x
+			}
+		}
+
+		done[next] = true
+		order = append(order, next)
+	}
+
+	return
+}
+
+// sumInts returns the total of the values
+func sumInts(values []int) (total int) {
+	for _, v := range values {
+		total += v
+	}
+
+	return
+}
@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func shardIDs(n int) (rids []ResourceID) {
+	for x := 1; x <= n; x++ {
+		rids = append(rids, ParseResourceID(fmt.Sprintf("/subscriptions/0a914e76-4921-4c19-b460-a2d36003525a/resourceGroups/flying/providers/Microsoft.Network/frontdoorWebApplicationFirewallPolicies/shard%d", x)))
+	}
+
+	return
+}
+
+func shardsByNet(assigned []IPNets) map[string]int {
+	res := make(map[string]int)
+
+	for x, ipns := range assigned {
+		for _, ipn := range ipns {
+			res[ipn.String()] = x
+		}
+	}
+
+	return res
+}
+
+func TestAssignIPNetShards(t *testing.T) {
+	ipns := hostIPNets(3000)
+
+	assigned, dropped := AssignIPNetShards(ipns, shardIDs(3), []int{2000, 2000, 2000})
+	require.Empty(t, dropped)
+	require.Len(t, assigned, 3)
+	require.Equal(t, 3000, len(assigned[0])+len(assigned[1])+len(assigned[2]))
+
+	for _, a := range assigned {
+		require.NotEmpty(t, a)
+	}
+
+	// the same networks, in any order, are assigned to the same shards
+	reversed := make(IPNets, 0, len(ipns))
+	for x := len(ipns) - 1; x >= 0; x-- {
+		reversed = append(reversed, ipns[x])
+	}
+
+	again, _ := AssignIPNetShards(reversed, shardIDs(3), []int{2000, 2000, 2000})
+	require.Equal(t, assigned, again)
+
+	// adding a shard only moves networks to the new shard
+	before := shardsByNet(assigned)
+
+	added, dropped := AssignIPNetShards(ipns, shardIDs(4), []int{2000, 2000, 2000, 2000})
+	require.Empty(t, dropped)
+
+	for n, x := range shardsByNet(added) {
+		if x != 3 {
+			require.Equal(t, before[n], x)
+		}
+	}
+}
+
+func TestAssignIPNetShardsCapacity(t *testing.T) {
+	assigned, dropped := AssignIPNetShards(hostIPNets(1000), shardIDs(2), []int{600, 300})
+	require.Len(t, assigned[0], 600)
+	require.Len(t, assigned[1], 300)
+	require.Len(t, dropped, 100)
+
+	// a shard without capacity receives no networks
+	assigned, dropped = AssignIPNetShards(hostIPNets(10), shardIDs(2), []int{0, 600})
+	require.Empty(t, assigned[0])
+	require.Len(t, assigned[1], 10)
+	require.Empty(t, dropped)
+}
+
+func TestShardRuleBudget(t *testing.T) {
+	require.Equal(t, 40, shardRuleBudget(40, 10))
+	require.Equal(t, 30, shardRuleBudget(40, 60))
+	require.Equal(t, 80, shardRuleBudget(0, 10))
+	require.Equal(t, 0, shardRuleBudget(40, 95))
+}
+
+func TestLoadActionsFromPathWithShards(t *testing.T) {
+	as, err := LoadActionsFromPath("testfiles/actions-shards.yaml")
+	require.NoError(t, err)
+	require.Len(t, as, 1)
+
+	shards := ParseResourceIDs(as[0].Shards)
+	require.Len(t, shards, 2)
+	require.Equal(t, "banana", shards[0].Name)
+	require.Equal(t, "cherry", shards[1].Name)
+}
+
+func TestShardUpdateOrder(t *testing.T) {
+	// shards are updated after those receiving networks they held
+	order := shardUpdateOrder([]IPNetShard{
+		{Net: "10.0.0.0/32", Shard: 3, Previous: 1},
+		{Net: "10.0.0.2/32", Shard: 2, Previous: 3},
+		{Net: "10.0.0.4/32", Shard: 1, Previous: 1},
+		{Net: "10.0.0.6/32", Shard: 4},
+	}, 4)
+	require.Equal(t, []int{1, 2, 0, 3}, order)
+
+	// shards exchanging networks are updated in order
+	order = shardUpdateOrder([]IPNetShard{
+		{Net: "10.0.0.0/32", Shard: 2, Previous: 1},
+		{Net: "10.0.0.2/32", Shard: 1, Previous: 2},
+	}, 2)
+	require.Equal(t, []int{0, 1}, order)
+}
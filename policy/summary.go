@@ -0,0 +1,202 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/frontdoor/mgmt/frontdoor"
+	"github.com/alexeyco/simpletable"
+	"github.com/gookit/color"
+)
+
+// IPChangeSummary describes the networks added to, and removed from, an action's list, and the custom rules that
+// are created, changed or deleted to hold them
+type IPChangeSummary struct {
+	PolicyID        string   `json:"policy_id"`
+	Action          string   `json:"action"`
+	DryRun          bool     `json:"dry_run"`
+	NetworksAdded   []string `json:"networks_added"`
+	NetworksRemoved []string `json:"networks_removed"`
+	RulesCreated    []string `json:"rules_created"`
+	RulesChanged    []string `json:"rules_changed"`
+	RulesDeleted    []string `json:"rules_deleted"`
+}
+
+// customRuleNetsKey returns a value that differs between rules if their priority, networks or scope differ
+func customRuleNetsKey(cr frontdoor.CustomRule) string {
+	var priority int32
+	if cr.Priority != nil {
+		priority = *cr.Priority
+	}
+
+	nets := ipNetsFromCustomRule(cr).toString()
+	sort.Strings(nets)
+
+	return fmt.Sprintf("%d|%s|%s|%s", priority, cr.Action, strings.Join(nets, ","), scopeFromCustomRule(cr))
+}
+
+// diffCustomRules returns the names of rules in generated but not existing as created, those in both with a
+// different key as changed, and those in existing but not generated as deleted
+func diffCustomRules(existing, generated []frontdoor.CustomRule, key func(frontdoor.CustomRule) string) (created, changed, deleted []string) {
+	// empty rather than null when output as json
+	created, changed, deleted = []string{}, []string{}, []string{}
+
+	before := make(map[string]frontdoor.CustomRule, len(existing))
+	for _, cr := range existing {
+		before[*cr.Name] = cr
+	}
+
+	after := make(map[string]bool, len(generated))
+
+	for _, cr := range generated {
+		after[*cr.Name] = true
+
+		e, ok := before[*cr.Name]
+
+		switch {
+		case !ok:
+			created = append(created, *cr.Name)
+		case key(e) != key(cr):
+			changed = append(changed, *cr.Name)
+		}
+	}
+
+	for _, cr := range existing {
+		if !after[*cr.Name] {
+			deleted = append(deleted, *cr.Name)
+		}
+	}
+
+	sort.Strings(created)
+	sort.Strings(changed)
+	sort.Strings(deleted)
+
+	return
+}
+
+// SummariseIPChanges compares the rules holding an action's networks before and after they are regenerated
+func SummariseIPChanges(existing, generated []frontdoor.CustomRule) (summary IPChangeSummary) {
+	summary.NetworksAdded, summary.NetworksRemoved = []string{}, []string{}
+	summary.RulesCreated, summary.RulesChanged, summary.RulesDeleted = diffCustomRules(existing, generated, customRuleNetsKey)
+
+	var beforeNets, afterNets IPNets
+
+	for _, cr := range existing {
+		beforeNets = append(beforeNets, ipNetsFromCustomRule(cr)...)
+	}
+
+	for _, cr := range generated {
+		afterNets = append(afterNets, ipNetsFromCustomRule(cr)...)
+	}
+
+	for _, c := range diffIPNets(beforeNets, afterNets) {
+		if c.Added {
+			summary.NetworksAdded = append(summary.NetworksAdded, c.Net)
+
+			continue
+		}
+
+		summary.NetworksRemoved = append(summary.NetworksRemoved, c.Net)
+	}
+
+	return
+}
+
+// changes returns the networks added and removed as a single list
+func (s IPChangeSummary) changes() (changes []IPNetChange) {
+	for _, n := range s.NetworksAdded {
+		changes = append(changes, IPNetChange{Net: n, Added: true})
+	}
+
+	for _, n := range s.NetworksRemoved {
+		changes = append(changes, IPNetChange{Net: n})
+	}
+
+	return
+}
+
+// ShowIPChangeSummary outputs the summary in the requested format: table, json, yaml or csv
+func ShowIPChangeSummary(summary IPChangeSummary, format string) error {
+	return Render(summary, format)
+}
+
+// RenderTable outputs the number of networks added and removed, followed by tables of the networks and rules changed
+func (summary IPChangeSummary) RenderTable() {
+	verb := "are"
+	if summary.DryRun {
+		verb = "would be"
+	}
+
+	fmt.Printf("%d networks %s added to, and %d removed from, %s list\n",
+		len(summary.NetworksAdded), verb, len(summary.NetworksRemoved), strings.ToLower(summary.Action))
+
+	if changes := summary.changes(); len(changes) > 0 {
+		ShowIPNetChanges(changes, summary.Action)
+	}
+
+	showRuleChanges(summary.RulesCreated, summary.RulesChanged, summary.RulesDeleted)
+}
+
+// CSVRecords returns a row per network and rule changed with columns type, change and name, where type is either
+// network or rule
+func (summary IPChangeSummary) CSVRecords() [][]string {
+	records := [][]string{{"type", "change", "name"}}
+
+	for _, n := range summary.NetworksAdded {
+		records = append(records, []string{"network", "added", n})
+	}
+
+	for _, n := range summary.NetworksRemoved {
+		records = append(records, []string{"network", "removed", n})
+	}
+
+	return append(records, ruleChangeRecords(summary.RulesCreated, summary.RulesChanged, summary.RulesDeleted)...)
+}
+
+// ruleChangeRecords returns a csv row for each custom rule created, changed and deleted
+func ruleChangeRecords(created, changed, deleted []string) (records [][]string) {
+	for _, r := range []struct {
+		change string
+		names  []string
+	}{{"created", created}, {"changed", changed}, {"deleted", deleted}} {
+		for _, name := range r.names {
+			records = append(records, []string{"rule", r.change, name})
+		}
+	}
+
+	return
+}
+
+// showRuleChanges displays a table listing the custom rules created, changed and deleted, if there are any
+func showRuleChanges(created, changed, deleted []string) {
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Change")},
+			{Align: simpletable.AlignCenter, Text: color.Bold.Sprintf("Rule")},
+		},
+	}
+
+	for _, r := range []struct {
+		change string
+		names  []string
+	}{
+		{change: color.HiGreen.Sprint("created"), names: created},
+		{change: color.HiYellow.Sprint("changed"), names: changed},
+		{change: color.HiRed.Sprint("deleted"), names: deleted},
+	} {
+		for _, name := range r.names {
+			table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+				{Text: r.change},
+				{Text: name},
+			})
+		}
+	}
+
+	if len(table.Body.Cells) > 0 {
+		table.SetStyle(simpletable.StyleRounded)
+
+		table.Println()
+	}
+}
@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummariseIPChanges(t *testing.T) {
+	existing, _, err := GenCustomRulesFromIPNets(hostIPNets(1000), 0, "Block")
+	require.NoError(t, err)
+	require.Len(t, existing, 2)
+
+	// replacing the first network and removing every network held by the second rule
+	ipns := append(parseIPNets(t, "9.9.9.9/32"), hostIPNets(600)[1:]...)
+
+	generated, _, err := GenStableCustomRulesFromIPNets(ipns, existing, 0, "Block")
+	require.NoError(t, err)
+
+	summary := SummariseIPChanges(existing, generated)
+	require.Equal(t, []string{"9.9.9.9/32"}, summary.NetworksAdded)
+	require.Len(t, summary.NetworksRemoved, 401)
+	require.Equal(t, "10.0.0.0/32", summary.NetworksRemoved[0])
+	require.Equal(t, []string{*generated[0].Name}, summary.RulesChanged)
+	require.Equal(t, []string{*existing[1].Name}, summary.RulesDeleted)
+	require.Empty(t, summary.RulesCreated)
+
+	// no changes
+	summary = SummariseIPChanges(existing, existing)
+	require.Empty(t, summary.NetworksAdded)
+	require.Empty(t, summary.NetworksRemoved)
+	require.Empty(t, summary.RulesChanged)
+
+	b, err := json.Marshal(summary)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"networks_added":[]`)
+	require.Contains(t, string(b), `"rules_deleted":[]`)
+}
+
+func TestSummariseIPChangesNewRules(t *testing.T) {
+	generated, _, err := GenCustomRulesFromIPNets(parseIPNets(t, "192.0.2.0/24"), 0, "Log")
+	require.NoError(t, err)
+
+	summary := SummariseIPChanges(nil, generated)
+	require.Equal(t, []string{"192.0.2.0/24"}, summary.NetworksAdded)
+	require.Equal(t, []string{*generated[0].Name}, summary.RulesCreated)
+
+	require.Error(t, ShowIPChangeSummary(summary, "xml"))
+
+	require.Equal(t, [][]string{
+		{"type", "change", "name"},
+		{"network", "added", "192.0.2.0/24"},
+		{"rule", "created", *generated[0].Name},
+	}, summary.CSVRecords())
+}
@@ -0,0 +1,229 @@
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jonhadfield/carbo/helpers"
+)
+
+const (
+	// IPListFormatSpamhaus is the Spamhaus DROP and EDROP lists, in either their text form, with "; SBL" suffixes and
+	// comments, or their json lines form
+	IPListFormatSpamhaus = "spamhaus"
+	// IPListFormatFireHOL is a FireHOL netset or ipset, with "#" comments and metadata headers
+	IPListFormatFireHOL = "firehol"
+	// IPListFormatAbuseIPDB is an AbuseIPDB blacklist export, as csv, json or plain text
+	IPListFormatAbuseIPDB = "abuseipdb"
+	// IPListFormatEmergingThreats is an Emerging Threats style list of addresses and networks with "#" comments
+	IPListFormatEmergingThreats = "emerging-threats"
+)
+
+// threatIntelIPListFormats are the formats of well-known threat intelligence lists
+var threatIntelIPListFormats = []string{
+	IPListFormatSpamhaus, IPListFormatFireHOL, IPListFormatAbuseIPDB, IPListFormatEmergingThreats,
+}
+
+// abuseIPDBAddressColumns and abuseIPDBConfidenceColumns are the csv headers AbuseIPDB exports use for each value
+var (
+	abuseIPDBAddressColumns    = []string{"ipaddress", "ip", "ip address"}
+	abuseIPDBConfidenceColumns = []string{"abuseconfidencescore", "confidence", "abuse confidence score", "score"}
+)
+
+type abuseIPDBBlacklist struct {
+	Data []struct {
+		IPAddress            string `json:"ipAddress"`
+		AbuseConfidenceScore int    `json:"abuseConfidenceScore"`
+	} `json:"data"`
+}
+
+type spamhausEntry struct {
+	CIDR  string `json:"cidr"`
+	SBLID string `json:"sblid"`
+	Type  string `json:"type"`
+}
+
+// parseThreatIntelIPList reads the IPs from a threat intelligence list in the specified format
+func parseThreatIntelIPList(l IPListPath, format string, data []byte) (ipns IPNets, err error) {
+	switch format {
+	case IPListFormatSpamhaus:
+		return parseSpamhausIPList(l.Path, data)
+	case IPListFormatAbuseIPDB:
+		return parseAbuseIPDBIPList(l, data)
+	default:
+		// FireHOL and Emerging Threats lists only differ from plain lists by their comments and headers
+		return parseTextIPList(l.Path, bytes.NewReader(data), nil)
+	}
+}
+
+// parseSpamhausIPList reads the networks from a Spamhaus DROP or EDROP list, ignoring ";" comments and SBL
+// references in the text form, and metadata records in the json lines form
+func parseSpamhausIPList(path string, data []byte) (ipns IPNets, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Split(bufio.ScanLines)
+
+	var lineNo int
+
+	for scanner.Scan() {
+		lineNo++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "{") {
+			var entry spamhausEntry
+
+			if err = json.Unmarshal([]byte(line), &entry); err != nil {
+				return nil, ipListLineError(path, lineNo, err)
+			}
+
+			if entry.CIDR == "" {
+				continue
+			}
+
+			line = entry.CIDR
+		} else if i := strings.Index(line, ";"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+
+		var n IPNets
+
+		n, err = ParseIPNetOrRange(line)
+		if err != nil {
+			return nil, ipListLineError(path, lineNo, err)
+		}
+
+		ipns = append(ipns, n...)
+	}
+
+	return ipns, scanner.Err()
+}
+
+// parseAbuseIPDBIPList reads the addresses from an AbuseIPDB blacklist, excluding those with a confidence score
+// below the list path's minimum. Plain text exports have no scores, so cannot be filtered.
+func parseAbuseIPDBIPList(l IPListPath, data []byte) (ipns IPNets, err error) {
+	trimmed := bytes.TrimSpace(data)
+
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var doc abuseIPDBBlacklist
+
+		if err = json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("%s: invalid abuseipdb blacklist: %w", l.Path, err)
+		}
+
+		for x, d := range doc.Data {
+			if d.AbuseConfidenceScore < l.MinConfidence {
+				continue
+			}
+
+			var n IPNets
+
+			n, err = ParseIPNetOrRange(d.IPAddress)
+			if err != nil {
+				return nil, fmt.Errorf("%s: entry %d: %w", l.Path, x, err)
+			}
+
+			ipns = append(ipns, n...)
+		}
+
+		return
+	}
+
+	firstLine := strings.ToLower(strings.SplitN(string(trimmed), "\n", 2)[0])
+	if !strings.Contains(firstLine, ",") {
+		if l.MinConfidence > 0 {
+			return nil, fmt.Errorf("%s: min-confidence requires a csv or json abuseipdb export", l.Path)
+		}
+
+		return parseTextIPList(l.Path, bytes.NewReader(data), nil)
+	}
+
+	return parseAbuseIPDBCSV(l, bytes.NewReader(data))
+}
+
+// parseAbuseIPDBCSV reads the addresses from an AbuseIPDB csv export, using its header to find the address and
+// confidence columns
+func parseAbuseIPDBCSV(l IPListPath, r io.Reader) (ipns IPNets, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", l.Path, err)
+	}
+
+	addressColumn, confidenceColumn := -1, -1
+
+	for x, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+
+		switch {
+		case helpers.StringInSlice(h, abuseIPDBAddressColumns, false):
+			addressColumn = x
+		case helpers.StringInSlice(h, abuseIPDBConfidenceColumns, false):
+			confidenceColumn = x
+		}
+	}
+
+	if addressColumn == -1 {
+		return nil, fmt.Errorf("%s: abuseipdb csv has no ip address column", l.Path)
+	}
+
+	if confidenceColumn == -1 && l.MinConfidence > 0 {
+		return nil, fmt.Errorf("%s: abuseipdb csv has no confidence score column", l.Path)
+	}
+
+	for {
+		var record []string
+
+		record, err = reader.Read()
+		if err == io.EOF {
+			return ipns, nil
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		if err != nil {
+			return nil, ipListLineError(l.Path, line, err)
+		}
+
+		if addressColumn >= len(record) {
+			return nil, ipListLineError(l.Path, line, fmt.Errorf("missing csv column %d", addressColumn+1))
+		}
+
+		if l.MinConfidence > 0 {
+			var score int
+
+			if confidenceColumn >= len(record) {
+				return nil, ipListLineError(l.Path, line, fmt.Errorf("missing csv column %d", confidenceColumn+1))
+			}
+
+			score, err = strconv.Atoi(strings.TrimSpace(record[confidenceColumn]))
+			if err != nil {
+				return nil, ipListLineError(l.Path, line, fmt.Errorf("invalid confidence score: %s", record[confidenceColumn]))
+			}
+
+			if score < l.MinConfidence {
+				continue
+			}
+		}
+
+		var n IPNets
+
+		n, err = ParseIPNetOrRange(record[addressColumn])
+		if err != nil {
+			return nil, ipListLineError(l.Path, line, err)
+		}
+
+		ipns = append(ipns, n...)
+	}
+}
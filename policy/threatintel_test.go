@@ -0,0 +1,64 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadIPsFromSpamhaus(t *testing.T) {
+	ipns, err := ReadIPsFromListPath(IPListPath{Path: "testfiles/threatintel/drop.txt", Format: IPListFormatSpamhaus})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1.10.16.0/20", "1.19.0.0/16", "2.56.192.0/22"}, ipns.toString())
+
+	ipns, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/threatintel/drop_v4.json", Format: IPListFormatSpamhaus})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1.10.16.0/20", "1.19.0.0/16"}, ipns.toString())
+
+	// without the format, the sbl references are rejected
+	_, err = ReadIPsFromFile("testfiles/threatintel/drop.txt")
+	require.Error(t, err)
+}
+
+func TestReadIPsFromFireHOLAndEmergingThreats(t *testing.T) {
+	// netsets are detected from their extension
+	ipns, err := ReadIPsFromFile("testfiles/threatintel/firehol_level1.netset")
+	require.NoError(t, err)
+	require.Equal(t, []string{"0.0.0.0/8", "1.10.16.0/20", "5.134.128.0/19"}, ipns.toString())
+
+	ipns, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/threatintel/emerging-Block-IPs.txt", Format: IPListFormatEmergingThreats})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1.10.16.0/20", "45.95.147.0/24", "103.109.247.10/32"}, ipns.toString())
+}
+
+func TestReadIPsFromAbuseIPDB(t *testing.T) {
+	ipns, err := ReadIPsFromListPath(IPListPath{Path: "testfiles/threatintel/abuseipdb.csv", Format: IPListFormatAbuseIPDB})
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.0.2.10/32", "192.0.2.11/32", "2001:db8::10/128"}, ipns.toString())
+
+	ipns, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/threatintel/abuseipdb.csv", Format: IPListFormatAbuseIPDB, MinConfidence: 75})
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.0.2.10/32", "192.0.2.11/32"}, ipns.toString())
+
+	ipns, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/threatintel/abuseipdb.json", Format: IPListFormatAbuseIPDB, MinConfidence: 90})
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.0.2.10/32"}, ipns.toString())
+
+	_, err = ReadIPsFromListPath(IPListPath{Path: "testfiles/threatintel/drop.txt", Format: IPListFormatSpamhaus, MinConfidence: 90})
+	require.Error(t, err)
+}
+
+func TestLoadSourcedIPsRecordsFeedName(t *testing.T) {
+	ipns, sources, err := LoadSourcedIPsFromListPath(IPListPath{
+		Path:   "testfiles/threatintel/drop.txt",
+		Format: IPListFormatSpamhaus,
+		Name:   "spamhaus-drop",
+	})
+	require.NoError(t, err)
+	require.Len(t, ipns, 3)
+	require.Equal(t, []string{"spamhaus-drop"}, sources.Get(ipns[0]))
+
+	_, sources, err = LoadSourcedIPsFromListPath(IPListPath{Path: "testfiles/threatintel/firehol_level1.netset"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"testfiles/threatintel/firehol_level1.netset"}, sources["1.10.16.0/20"])
+}
@@ -2,6 +2,7 @@ package carbo
 
 import (
 	"fmt"
+	"github.com/jonhadfield/carbo/helpers"
 	"github.com/jonhadfield/carbo/policy"
 	"log"
 	"strings"
@@ -13,72 +14,96 @@ import (
 // check no limits exceeded for block, allow, log
 
 type RunActionsInput struct {
-	Path   string
-	DryRun bool
-	Debug  bool
+	Path            string
+	DryRun          bool
+	AllowTruncation bool
+	FailOnOverlap   bool
+	ProtectedPath   string
+	RejectProtected bool
+	ExpiryStatePath string
+	OutputFormat    string
+	Debug           bool
 }
 
-func runActions(as []policy.Action, stopOnFailure, dryRun bool) (err error) {
+func runActions(as []policy.Action, i RunActionsInput, protected policy.IPNets) (err error) {
 	for _, a := range as {
 		switch strings.ToLower(a.ActionType) {
-		case "log":
+		case "log", "allow", "block", "redirect":
 			rid := policy.ParseResourceID(a.Policy)
 
-			log.Printf("running LOG action for Policy: %s\n", rid.Name)
-			log.Printf("loaded %d addresses from paths: %s\n", len(a.Nets), strings.Join(a.Paths, ","))
+			var action string
+
+			action, err = helpers.NormaliseCustomRuleAction(a.ActionType)
+			if err != nil {
+				return
+			}
+
+			log.Printf("running %s action for Policy: %s\n", strings.ToUpper(action), rid.Name)
+			log.Printf("loaded %d addresses from paths: %s\n", len(a.Nets), actionPaths(a))
 
 			err = policy.ApplyIPChanges(policy.ApplyIPsInput{
-				RID:      rid,
-				Output:   false,
-				Action:   "Log",
-				Filepath: "",
-				DryRun:   dryRun,
-				Nets:     a.Nets,
-				MaxRules: a.MaxRules,
+				RID:             rid,
+				Output:          false,
+				Action:          action,
+				Filepath:        "",
+				DryRun:          i.DryRun,
+				Nets:            a.Nets,
+				MaxRules:        a.MaxRules,
+				AllowTruncation: a.AllowTruncation,
+				DroppedPath:     a.DroppedPath,
+				Sources:         a.Sources,
+				FailOnOverlap:   i.FailOnOverlap,
+				Protected:       protected,
+				RejectProtected: i.RejectProtected,
+				Expiries:        a.Expiries,
+				ExpiryStatePath: i.ExpiryStatePath,
+				OutputFormat:    i.OutputFormat,
+				Shards:          policy.ParseResourceIDs(a.Shards),
+				Scope:           a.Scope,
 			})
 
 			if err != nil {
 				return
 			}
-		case "allow":
+		case "countries":
 			rid := policy.ParseResourceID(a.Policy)
 
-			log.Printf("running ALLOW action for Policy: %s\n", rid.Name)
-			log.Printf("loaded %d addresses from paths: %s\n", len(a.Nets), strings.Join(a.Paths, ","))
+			// country actions block unless another action is specified
+			countryAction := "Block"
+			if a.CountryAction != "" {
+				countryAction = strings.ToUpper(a.CountryAction[:1]) + strings.ToLower(a.CountryAction[1:])
+			}
+
+			log.Printf("running COUNTRIES action to %s %d countries for Policy: %s\n", strings.ToLower(countryAction), len(a.Countries), rid.Name)
 
-			err = policy.ApplyIPChanges(policy.ApplyIPsInput{
-				RID:      rid,
-				Output:   false,
-				Action:   "Allow",
-				Filepath: "",
-				DryRun:   dryRun,
-				Nets:     a.Nets,
-				MaxRules: a.MaxRules,
+			err = policy.ApplyCountryChanges(policy.ApplyCountriesInput{
+				RID:          rid,
+				Action:       countryAction,
+				Countries:    a.Countries,
+				DryRun:       i.DryRun,
+				OutputFormat: i.OutputFormat,
 			})
 
 			if err != nil {
 				return
 			}
-		case "block":
+		case "rate-limits":
 			rid := policy.ParseResourceID(a.Policy)
 
-			log.Printf("running BLOCK action for Policy: %s\n", rid.Name)
-			log.Printf("loaded %d addresses from paths: %s\n", len(a.Nets), strings.Join(a.Paths, ","))
+			log.Printf("running RATE-LIMITS action with %d rate limits for Policy: %s\n", len(a.RateLimits), rid.Name)
 
-			err = policy.ApplyIPChanges(policy.ApplyIPsInput{
-				RID:      rid,
-				Output:   false,
-				Action:   "Block",
-				Filepath: "",
-				DryRun:   dryRun,
-				Nets:     a.Nets,
-				MaxRules: a.MaxRules,
+			// the action defines every rate limit so any others are removed
+			err = policy.ApplyRateLimits(policy.ApplyRateLimitsInput{
+				RID:          rid,
+				RateLimits:   a.RateLimits,
+				Replace:      true,
+				DryRun:       i.DryRun,
+				OutputFormat: i.OutputFormat,
 			})
 
 			if err != nil {
 				return
 			}
-
 		default:
 			return fmt.Errorf("action type '%s' is not supported", a.ActionType)
 		}
@@ -93,5 +118,115 @@ func RunActions(i RunActionsInput) error {
 		return err
 	}
 
-	return runActions(actions, true, i.DryRun)
+	// allowing truncation for the run applies to every action
+	if i.AllowTruncation {
+		for x := range actions {
+			actions[x].AllowTruncation = true
+		}
+	}
+
+	if err = checkActionsOverlaps(actions, i.FailOnOverlap); err != nil {
+		return err
+	}
+
+	var protected policy.IPNets
+
+	if i.ProtectedPath != "" {
+		protected, err = policy.LoadIPsFromPath(i.ProtectedPath)
+		if err != nil {
+			return err
+		}
+
+		if err = checkActionsProtected(actions, protected, i.RejectProtected); err != nil {
+			return err
+		}
+	}
+
+	return runActions(actions, i, protected)
+}
+
+// checkActionsProtected checks block, log and redirect actions for protected networks before any are applied so
+// that, when rejecting, no policy is updated if any action would include them
+func checkActionsProtected(as []policy.Action, protected policy.IPNets, reject bool) error {
+	if !reject {
+		return nil
+	}
+
+	var total int
+
+	for _, a := range as {
+		switch strings.ToLower(a.ActionType) {
+		case "block", "log", "redirect":
+			_, stripped := policy.ExcludeProtectedIPNets(a.Nets, protected)
+			if len(stripped) == 0 {
+				continue
+			}
+
+			policy.ReportStrippedIPNets(stripped, a.ActionType)
+
+			total += len(stripped)
+		}
+	}
+
+	if total > 0 {
+		return fmt.Errorf("%d networks in actions overlap protected networks", total)
+	}
+
+	return nil
+}
+
+// checkActionsOverlaps reports networks appearing in the lists of more than one action type for the same policy.
+// A sharded action's networks may be applied to any of its shards so are compared with the lists of each.
+func checkActionsOverlaps(as []policy.Action, failOnOverlap bool) error {
+	var policies []string
+
+	listsByPolicy := make(map[string][]policy.IPNetList)
+
+	for _, a := range as {
+		for _, p := range append([]string{a.Policy}, a.Shards...) {
+			key := strings.ToLower(p)
+			if _, ok := listsByPolicy[key]; !ok {
+				policies = append(policies, p)
+			}
+
+			listsByPolicy[key] = append(listsByPolicy[key], policy.IPNetList{
+				Action:  a.ActionType,
+				Nets:    a.Nets,
+				Sources: a.Sources,
+				Scope:   a.Scope,
+			})
+		}
+	}
+
+	var total int
+
+	for _, p := range policies {
+		overlaps := policy.FindIPNetOverlaps(listsByPolicy[strings.ToLower(p)])
+		if len(overlaps) == 0 {
+			continue
+		}
+
+		log.Printf("%d overlaps found between actions for Policy: %s\n", len(overlaps), policy.ParseResourceID(p).Name)
+
+		policy.ReportIPNetOverlaps(overlaps)
+
+		total += len(overlaps)
+	}
+
+	if total > 0 && failOnOverlap {
+		return fmt.Errorf("%d overlaps found between actions", total)
+	}
+
+	return nil
+}
+
+// actionPaths returns the comma separated paths the action's ips were loaded from
+func actionPaths(a policy.Action) string {
+	paths := make([]string, 0, len(a.Paths))
+
+	for _, p := range a.Paths {
+		paths = append(paths, p.String())
+	}
+
+	return strings.Join(paths, ",")
 }